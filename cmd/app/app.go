@@ -2,11 +2,15 @@ package app
 
 import (
 	"context"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redsync/redsync/v4"
 	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/kakaluote000/demo-api/pkg/tracing"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 )
@@ -18,27 +22,86 @@ type App struct {
 	Redis  *redis.Client
 	RS     *redsync.Redsync
 	Router *gin.Engine
-	Ctx    context.Context
-	Log    *logrus.Logger
+	// LoginGuard 登录失败计数和锁定，登录、两步验证、改密和解锁共用，随配置热更新
+	LoginGuard *auth.LoginGuard
+	// Ctx 在应用关闭时被取消
+	Ctx context.Context
+	Log *logrus.Logger
+
+	lifecycle
 }
 
 func NewApp() *App {
+	if err := auth.Init(pkg.AppConfig.Security.JWT); err != nil {
+		pkg.Log.Fatalf("failed to load jwt keys: %v", err)
+	}
+	if err := security.SetPasswordPolicy(pkg.AppConfig.Security.Password); err != nil {
+		pkg.Log.Fatalf("failed to load password policy: %v", err)
+	}
+	security.SetHashConfig(pkg.AppConfig.Security.PasswordHash)
+	pkg.OnConfigReload(func(_, cfg *pkg.Config) {
+		auth.SetExpiry(cfg.Security.JWT.Expiry, cfg.Security.JWT.RefreshExpiry)
+		if err := auth.SetKeys(cfg.Security.JWT.Keys); err != nil {
+			pkg.Log.WithError(err).Error("Failed to reload jwt keys, keeping current keys")
+		}
+		if err := security.SetPasswordPolicy(cfg.Security.Password); err != nil {
+			pkg.Log.WithError(err).Error("Failed to reload password policy, keeping current policy")
+		}
+		security.SetHashConfig(cfg.Security.PasswordHash)
+	})
+
+	shutdownTracing, err := tracing.Init(pkg.AppConfig.Tracing)
+	if err != nil {
+		pkg.Log.Fatalf("failed to init tracing: %v", err)
+	}
+
 	db := pkg.InitDB()
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		pkg.Log.Fatalf("failed to register gorm tracing: %v", err)
+	}
 	rdb, rs, ctx := pkg.InitRedis()
+	rdb.AddHook(tracing.RedisHook{})
 
-	GlobalApp = &App{
-		DB:     db,
-		Redis:  rdb,
-		RS:     rs,
-		Router: gin.Default(),
-		Ctx:    ctx,
-		Log:    pkg.Log,
-	}
+	GlobalApp = NewAppWith(ctx, db, rdb, rs)
+
+	// 最先注册、最后停止，确保关闭过程中产生的 span 也能导出
+	GlobalApp.AddWorker("tracing", func(ctx context.Context) {
+		<-ctx.Done()
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			pkg.Log.WithError(err).Warn("Failed to flush traces")
+		}
+	})
 	return GlobalApp
 }
 
-func (app *App) Run() {
-	if err := app.Router.Run(":8080"); err != nil {
-		pkg.Log.Fatalf("failed to run server: %v", err)
+// NewAppWith 使用已建立的连接创建应用，便于测试和自行管理连接的场景
+func NewAppWith(ctx context.Context, db *gorm.DB, rdb *redis.Client, rs *redsync.Redsync) *App {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// 访问日志由 middleware.LoggerMiddleware 统一输出，不使用 gin 默认的 Logger
+	router := gin.New()
+	router.Use(gin.Recovery())
+	// 默认不信任任何代理，否则客户端可以通过 X-Forwarded-For 伪造 IP，绕过 API key 的 IP 限制、登录锁定和限流
+	if err := router.SetTrustedProxies(pkg.AppConfig.Server.TrustedProxies); err != nil {
+		pkg.Log.WithError(err).Error("Invalid trusted proxies, trusting none")
+		router.SetTrustedProxies(nil)
+	}
+
+	guard := auth.NewLoginGuard(rdb, pkg.AppConfig.Security.Lockout)
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		guard.SetConfig(next.Security.Lockout)
+	})
+
+	return &App{
+		DB:         db,
+		Redis:      rdb,
+		RS:         rs,
+		Router:     router,
+		LoginGuard: guard,
+		Ctx:        ctx,
+		Log:        pkg.Log,
+		lifecycle:  lifecycle{cancel: cancel, draining: make(chan struct{})},
 	}
 }
@@ -0,0 +1,258 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/tlsutil"
+)
+
+const (
+	defaultPort            = 8080
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+type worker struct {
+	name   string
+	run    func(ctx context.Context)
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// lifecycle 管理 HTTP 服务、后台任务以及关闭流程
+type lifecycle struct {
+	cancel context.CancelFunc
+	ready  atomic.Bool
+	// 开始等待进行中请求完成时关闭
+	draining chan struct{}
+
+	mu        sync.Mutex
+	server    *http.Server
+	tlsConfig *tls.Config
+	workers   []*worker
+	shutdown  sync.Once
+}
+
+// AddWorker 注册后台任务，run 应阻塞直到 ctx 被取消。
+// 任务在 Serve 时按注册顺序启动，关闭时按相反顺序逐个停止。
+func (app *App) AddWorker(name string, run func(ctx context.Context)) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	app.workers = append(app.workers, &worker{name: name, run: run})
+}
+
+// EnableTLS 使用配置中的证书提供 HTTPS 服务，证书文件变化时自动重新加载
+func (app *App) EnableTLS(cfg pkg.TLSConfig) error {
+	tlsConfig, reloader, err := tlsutil.NewServerConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	app.mu.Lock()
+	app.tlsConfig = tlsConfig
+	app.mu.Unlock()
+
+	app.AddWorker("tls-cert-reloader", func(ctx context.Context) {
+		if err := reloader.Watch(ctx); err != nil {
+			app.Log.WithError(err).Error("TLS certificate watcher stopped")
+		}
+	})
+	return nil
+}
+
+// TLSConfig 返回 EnableTLS 设置的 TLS 配置，未启用时为 nil。证书重新加载对使用该配置的其他服务同样生效
+func (app *App) TLSConfig() *tls.Config {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.tlsConfig
+}
+
+// Draining 返回开始等待进行中请求完成时关闭的 channel，SSE 等长连接应在此时结束，
+// 否则会占满整个关闭等待时间
+func (app *App) Draining() <-chan struct{} {
+	return app.draining
+}
+
+// Ready 返回应用是否可以接收流量，关闭开始后立即变为 false
+func (app *App) Ready() bool {
+	return app.ready.Load()
+}
+
+func (app *App) Run() {
+	port := pkg.AppConfig.Server.Port
+	if port == 0 {
+		port = defaultPort
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		pkg.Log.Fatalf("failed to run server: %v", err)
+	}
+
+	if tlsCfg := pkg.AppConfig.Server.TLS; tlsCfg.Enabled {
+		if err := app.EnableTLS(tlsCfg); err != nil {
+			pkg.Log.Fatalf("failed to configure tls: %v", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.Serve(ctx, listener); err != nil {
+		pkg.Log.Fatalf("failed to run server: %v", err)
+	}
+}
+
+// Serve 在 listener 上提供服务，ctx 被取消后执行优雅关闭并返回
+func (app *App) Serve(ctx context.Context, listener net.Listener) error {
+	app.mu.Lock()
+	app.server = &http.Server{
+		Handler:           app.Router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	server := app.server
+	if app.tlsConfig != nil {
+		listener = tls.NewListener(listener, app.tlsConfig)
+	}
+	app.mu.Unlock()
+
+	app.startWorkers()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+	app.ready.Store(true)
+	app.Log.Infof("Server listening on %s", listener.Addr())
+
+	select {
+	case err := <-serveErr:
+		app.Shutdown(context.Background())
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		app.Log.Info("Shutdown signal received")
+		return app.Shutdown(context.Background())
+	}
+}
+
+// Shutdown 依次执行：readiness 失败、等待进行中请求完成、停止后台任务、取消 App.Ctx、关闭数据库和 Redis
+func (app *App) Shutdown(ctx context.Context) error {
+	var err error
+	app.shutdown.Do(func() {
+		err = app.doShutdown(ctx)
+	})
+	return err
+}
+
+func (app *App) doShutdown(ctx context.Context) error {
+	cfg := pkg.AppConfig.Server
+	timeout := cfg.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	app.ready.Store(false)
+
+	// 给负载均衡留出摘除流量的时间
+	if cfg.ShutdownDelay > 0 {
+		app.Log.Infof("Readiness failing, waiting %s before draining", cfg.ShutdownDelay)
+		select {
+		case <-time.After(cfg.ShutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	var errs []error
+	close(app.draining)
+
+	app.mu.Lock()
+	server := app.server
+	app.mu.Unlock()
+	if server != nil {
+		drainCtx, cancel := context.WithTimeout(ctx, timeout)
+		if err := server.Shutdown(drainCtx); err != nil {
+			app.Log.WithError(err).Warn("In-flight requests did not finish before deadline, closing connections")
+			server.Close()
+			errs = append(errs, fmt.Errorf("drain requests: %w", err))
+		}
+		cancel()
+	}
+
+	workerCtx, cancel := context.WithTimeout(ctx, timeout)
+	errs = append(errs, app.stopWorkers(workerCtx)...)
+	cancel()
+
+	if app.cancel != nil {
+		app.cancel()
+	}
+
+	if app.DB != nil {
+		if sqlDB, err := app.DB.DB(); err == nil {
+			if err := sqlDB.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close database: %w", err))
+			}
+		}
+	}
+	if app.Redis != nil {
+		if err := app.Redis.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close redis: %w", err))
+		}
+	}
+
+	app.Log.Info("Server stopped")
+	return errors.Join(errs...)
+}
+
+func (app *App) startWorkers() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	for _, w := range app.workers {
+		if w.done != nil {
+			continue
+		}
+		ctx, cancel := context.WithCancel(app.Ctx)
+		w.cancel = cancel
+		w.done = make(chan struct{})
+		go func(w *worker) {
+			defer close(w.done)
+			w.run(ctx)
+		}(w)
+		app.Log.Infof("Worker %s started", w.name)
+	}
+}
+
+func (app *App) stopWorkers(ctx context.Context) []error {
+	app.mu.Lock()
+	workers := app.workers
+	app.mu.Unlock()
+
+	var errs []error
+	for i := len(workers) - 1; i >= 0; i-- {
+		w := workers[i]
+		if w.done == nil {
+			continue
+		}
+		w.cancel()
+		select {
+		case <-w.done:
+			app.Log.Infof("Worker %s stopped", w.name)
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("worker %s did not stop: %w", w.name, ctx.Err()))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := app.NewAppWith(context.Background(), nil, nil, nil)
+
+	started := make(chan struct{})
+	a.Router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"message": "done"})
+	})
+
+	var (
+		mu      sync.Mutex
+		stopped []string
+	)
+	for _, name := range []string{"first", "second"} {
+		name := name
+		a.AddWorker(name, func(ctx context.Context) {
+			<-ctx.Done()
+			mu.Lock()
+			stopped = append(stopped, name)
+			mu.Unlock()
+		})
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(ctx, listener)
+	}()
+	require.Eventually(t, a.Ready, time.Second, 10*time.Millisecond)
+
+	respCh := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String() + "/slow")
+		if err == nil {
+			respCh <- resp
+		}
+		close(respCh)
+	}()
+	<-started
+
+	// 模拟收到 SIGTERM
+	cancel()
+
+	resp := <-respCh
+	require.NotNil(t, resp, "in-flight request should be drained")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	require.NoError(t, <-served)
+	assert.False(t, a.Ready())
+	assert.Error(t, a.Ctx.Err(), "App.Ctx should be cancelled")
+	assert.Equal(t, []string{"second", "first"}, stopped)
+}
+
+func TestShutdownEndsLongLivedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := app.NewAppWith(context.Background(), nil, nil, nil)
+
+	started := make(chan struct{})
+	a.Router.GET("/stream", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+		close(started)
+		<-a.Draining()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(ctx, listener)
+	}()
+	require.Eventually(t, a.Ready, time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	<-started
+
+	// 长连接在开始排空时结束，不会占满关闭等待时间
+	start := time.Now()
+	cancel()
+	require.NoError(t, <-served)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/tlsutil/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMutualTLS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	serverCA := tlstest.NewCA(t, dir, "server-ca")
+	clientCA := tlstest.NewCA(t, dir, "client-ca")
+	otherCA := tlstest.NewCA(t, dir, "other-ca")
+	certFile, keyFile := serverCA.IssueServerCert(t, dir, "server")
+
+	a := app.NewAppWith(context.Background(), nil, nil, nil)
+	require.NoError(t, a.EnableTLS(pkg.TLSConfig{
+		Enabled:      true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCA.CertFile,
+	}))
+
+	a.Router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	monitoring := a.Router.Group("/monitoring", middleware.ClientCertMiddleware())
+	monitoring.GET("/whoami", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"subject": c.GetString("clientCertCommonName")})
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(ctx, listener)
+	}()
+	defer func() {
+		cancel()
+		<-served
+	}()
+	require.Eventually(t, a.Ready, time.Second, 10*time.Millisecond)
+
+	baseURL := "https://" + listener.Addr().String()
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      serverCA.Pool(),
+			Certificates: certs,
+		}}}
+	}
+
+	tests := []struct {
+		name        string
+		client      *http.Client
+		path        string
+		wantStatus  int
+		wantSubject string
+	}{
+		{name: "Public route without client cert", client: newClient(), path: "/health", wantStatus: http.StatusOK},
+		{name: "Monitoring without client cert", client: newClient(), path: "/monitoring/whoami", wantStatus: http.StatusForbidden},
+		{
+			name:        "Monitoring with trusted client cert",
+			client:      newClient(clientCA.IssueClientCert(t, dir, "alertmanager")),
+			path:        "/monitoring/whoami",
+			wantStatus:  http.StatusOK,
+			wantSubject: "alertmanager",
+		},
+		{
+			name:       "Monitoring with untrusted client cert",
+			client:     newClient(otherCA.IssueClientCert(t, dir, "intruder")),
+			path:       "/monitoring/whoami",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.client.Get(baseURL + tt.path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+
+			if tt.wantSubject != "" {
+				var body map[string]string
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tt.wantSubject, body["subject"])
+			}
+		})
+	}
+}
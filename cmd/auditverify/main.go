@@ -0,0 +1,65 @@
+// auditverify 校验审计日志的哈希链，链完整时退出码为 0，否则为 1。
+//
+//	go run ./cmd/auditverify [-anchor <hash>] [-json]
+//
+// 每次校验输出的 head 应保存在数据库之外，下次校验时通过 -anchor 传入，
+// 用于发现链从末尾被截断
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+)
+
+func main() {
+	anchor := flag.String("anchor", "", "hash of an entry that must still be on the chain")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	flag.Parse()
+
+	pkg.InitConfig()
+	db := pkg.InitDB()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	result, err := audit.Verify(ctx, db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read audit log: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *anchor != "" {
+		var count int64
+		if err := db.WithContext(ctx).Model(&models.AuditLog{}).Where("hash = ? AND seq IS NOT NULL", *anchor).Count(&count).Error; err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read audit log: %v\n", err)
+			os.Exit(2)
+		}
+		if count == 0 {
+			result.Problems = append(result.Problems, audit.Problem{Message: fmt.Sprintf("anchor %s is not on the chain, entries may have been removed", *anchor)})
+		}
+	}
+
+	if *asJSON {
+		json.NewEncoder(os.Stdout).Encode(result)
+	} else {
+		fmt.Printf("entries: %d (legacy, not chained: %d)\n", result.Entries, result.Legacy)
+		fmt.Printf("last seq: %d\n", result.LastSeq)
+		fmt.Printf("head: %s\n", result.Head)
+		for _, p := range result.Problems {
+			fmt.Printf("seq %d (id %d): %s\n", p.Seq, p.ID, p.Message)
+		}
+		if result.OK() {
+			fmt.Println("audit chain OK")
+		}
+	}
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
@@ -9,9 +9,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
 	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
 	"github.com/kakaluote000/demo-api/pkg/alert"
+	"github.com/kakaluote000/demo-api/pkg/audit"
 	"github.com/kakaluote000/demo-api/pkg/notification"
+	"github.com/kakaluote000/demo-api/pkg/response"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type Alert struct {
@@ -34,20 +38,39 @@ type AlertWebhook struct {
 	Alerts            []Alert           `json:"alerts"`
 }
 
+// UpdateAlertStatusRequest 更新告警处理状态
+type UpdateAlertStatusRequest struct {
+	HandleStatus string `json:"handle_status" binding:"required,max=32"`
+	HandleNote   string `json:"handle_note" binding:"max=1024"`
+	HandledBy    string `json:"handled_by" binding:"max=64"`
+}
+
+// AlertWebhookHandler godoc
+// @Summary 接收告警
+// @Description Alertmanager webhook，需要客户端证书或带 alerts:write 的 API key
+// @Tags 监控
+// @Accept json
+// @Produce json
+// @Param body body AlertWebhook true "Alertmanager 告警"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,401,403 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /monitoring/webhook [post]
 func AlertWebhookHandler(app *app.App) gin.HandlerFunc {
-	notificationManager := notification.NewNotificationManager()
-	notificationManager.AddNotifier(notification.NewWebhookNotifier("https://your-webhook-url"))
+	notificationManager := notification.NewNotificationManagerFromConfig(pkg.CurrentConfig().Notification)
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		notificationManager.ApplyConfig(next.Notification)
+	})
 	alertProcessor := alert.NewAlertProcessor(app.DB, notificationManager)
 
 	return func(c *gin.Context) {
 		var webhook AlertWebhook
-		if err := c.ShouldBindJSON(&webhook); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !bindJSON(c, &webhook) {
 			return
 		}
 
 		// 记录告警信息
-		log := app.Log.WithFields(logrus.Fields{
+		log := pkg.LoggerFromContext(c.Request.Context()).WithFields(logrus.Fields{
 			"status":   webhook.Status,
 			"receiver": webhook.Receiver,
 			"alerts":   len(webhook.Alerts),
@@ -58,7 +81,7 @@ func AlertWebhookHandler(app *app.App) gin.HandlerFunc {
 			// 将告警信息存入Redis用于统计
 			alertKey := fmt.Sprintf("alert:%s:%s", alert.Labels["alertname"], alert.StartsAt)
 			alertData, _ := json.Marshal(alert)
-			app.Redis.Set(app.Ctx, alertKey, alertData, 24*time.Hour)
+			app.Redis.Set(c.Request.Context(), alertKey, alertData, alertRetention())
 
 			log.WithFields(logrus.Fields{
 				"alertname": alert.Labels["alertname"],
@@ -94,7 +117,7 @@ func AlertWebhookHandler(app *app.App) gin.HandlerFunc {
 				HandleStatus: "pending",
 			}
 
-			if err := app.DB.Create(&alertHistory).Error; err != nil {
+			if err := app.DB.WithContext(c.Request.Context()).Create(&alertHistory).Error; err != nil {
 				log.WithError(err).Error("Failed to save alert history")
 				continue
 			}
@@ -105,15 +128,23 @@ func AlertWebhookHandler(app *app.App) gin.HandlerFunc {
 			}
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Alert received and processed"})
+		response.Success(c, http.StatusOK, response.MsgAlertReceived, nil)
 	}
 }
 
+// alertRetention 返回告警在 Redis 中的保留时长，默认 24 小时
+func alertRetention() time.Duration {
+	if retention := pkg.CurrentConfig().Alert.Retention; retention > 0 {
+		return retention
+	}
+	return 24 * time.Hour
+}
+
 // 添加获取告警历史的处理器
 func GetAlertHistoryHandler(app *app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var alerts []models.AlertHistory
-		query := app.DB.Order("created_at desc")
+		query := app.DB.WithContext(c.Request.Context()).Order("created_at desc")
 
 		// 支持按状态筛选
 		if status := c.Query("status"); status != "" {
@@ -134,11 +165,11 @@ func GetAlertHistoryHandler(app *app.App) gin.HandlerFunc {
 		}
 
 		if err := query.Find(&alerts).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert history"})
+			response.Fail(c, response.ErrInternal.Wrap(err))
 			return
 		}
 
-		c.JSON(http.StatusOK, alerts)
+		response.OK(c, alerts)
 	}
 }
 
@@ -146,31 +177,47 @@ func GetAlertHistoryHandler(app *app.App) gin.HandlerFunc {
 func UpdateAlertStatusHandler(app *app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		alertID := c.Param("id")
-		var updateData struct {
-			HandleStatus string `json:"handle_status"`
-			HandleNote   string `json:"handle_note"`
-			HandledBy    string `json:"handled_by"`
+		var updateData UpdateAlertStatusRequest
+		if !bindJSON(c, &updateData) {
+			return
 		}
 
-		if err := c.ShouldBindJSON(&updateData); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		db := app.DB.WithContext(c.Request.Context())
+		var before models.AlertHistory
+		if err := db.Where("id = ?", alertID).First(&before).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				response.Fail(c, response.ErrAlertNotFound)
+			} else {
+				response.Fail(c, response.ErrInternal.Wrap(err))
+			}
 			return
 		}
 
-		result := app.DB.Model(&models.AlertHistory{}).
-			Where("id = ?", alertID).
-			Updates(map[string]interface{}{
-				"handle_status": updateData.HandleStatus,
-				"handle_note":   updateData.HandleNote,
-				"handled_by":    updateData.HandledBy,
+		changes := map[string]interface{}{
+			"handle_status": updateData.HandleStatus,
+			"handle_note":   updateData.HandleNote,
+			"handled_by":    updateData.HandledBy,
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&models.AlertHistory{}).Where("id = ?", alertID).Updates(changes).Error; err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{
+				Action: "alert.update_status",
+				Target: fmt.Sprintf("alert:%d", before.ID),
+				Before: map[string]interface{}{
+					"handle_status": before.HandleStatus,
+					"handle_note":   before.HandleNote,
+					"handled_by":    before.HandledBy,
+				},
+				After: changes,
 			})
-
-		if result.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert status"})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "Alert status updated successfully"})
+		response.Success(c, http.StatusOK, response.MsgAlertUpdated, nil)
 	}
 }
 
@@ -192,10 +239,10 @@ func GetAlertStatsHandler(app *app.App) gin.HandlerFunc {
 
 		// 扫描所有告警
 		pattern := "alert:*"
-		iter := app.Redis.Scan(app.Ctx, 0, pattern, 0).Iterator()
-		for iter.Next(app.Ctx) {
+		iter := app.Redis.Scan(c.Request.Context(), 0, pattern, 0).Iterator()
+		for iter.Next(c.Request.Context()) {
 			key := iter.Val()
-			alertData, err := app.Redis.Get(app.Ctx, key).Result()
+			alertData, err := app.Redis.Get(c.Request.Context(), key).Result()
 			if err != nil {
 				continue
 			}
@@ -223,6 +270,6 @@ func GetAlertStatsHandler(app *app.App) gin.HandlerFunc {
 			}
 		}
 
-		c.JSON(http.StatusOK, stats)
+		response.OK(c, stats)
 	}
 }
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"gorm.io/gorm"
+)
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required,max=64"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1,dive,required"`
+	AllowedIPs []string   `json:"allowed_ips" binding:"omitempty,dive,ip|cidr"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// APIKeyResponse 返回给客户端的 API key 信息，不包含 key 的哈希
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	AllowedIPs []string   `json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedBy  uint       `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyResponse struct {
+	// 明文 key 只返回这一次
+	Key    string         `json:"key"`
+	APIKey APIKeyResponse `json:"api_key"`
+}
+
+func newAPIKeyResponse(key *models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     splitList(key.Scopes),
+		AllowedIPs: splitList(key.AllowedIPs),
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		CreatedBy:  key.CreatedBy,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+// splitList 拆分逗号分隔的列表，忽略空项
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// CreateAPIKeyHandler godoc
+// @Summary 创建 API key
+// @Description 为服务间调用创建带授权范围的 API key，明文只在响应中返回一次
+// @Tags API Key
+// @Accept json
+// @Produce json
+// @Param body body CreateAPIKeyRequest true "API key 信息"
+// @Success 201 {object} response.SuccessResponse{data=CreateAPIKeyResponse}
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/api-keys [post]
+// @DeprecatedRouter /apiKeys [post]
+func CreateAPIKeyHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req CreateAPIKeyRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		scopes, err := auth.ParseScopes(req.Scopes)
+		if err != nil {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails(err.Error()))
+			return
+		}
+		if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("expires_at must be in the future"))
+			return
+		}
+
+		var key string
+		var record *models.APIKey
+		var createErr error
+		err = app.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			key, record, createErr = auth.NewAPIKeyStore(tx).Create(c.Request.Context(), auth.NewAPIKey{
+				Name:       req.Name,
+				Scopes:     scopes,
+				AllowedIPs: req.AllowedIPs,
+				ExpiresAt:  req.ExpiresAt,
+				CreatedBy:  c.GetUint("userID"),
+			})
+			if createErr != nil {
+				return createErr
+			}
+			return recordAuditTx(c, tx, audit.Entry{
+				Action: "api_key.create",
+				Target: apiKeyTarget(record.ID),
+				After:  newAPIKeyResponse(record),
+			})
+		})
+		if createErr != nil {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails(createErr.Error()))
+			return
+		}
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+
+		pkg.LoggerFromContext(c.Request.Context()).Infof("API key %d (%s) created with scopes %s", record.ID, record.Prefix, record.Scopes)
+		response.Success(c, http.StatusCreated, response.MsgSuccess, CreateAPIKeyResponse{Key: key, APIKey: newAPIKeyResponse(record)})
+	}
+}
+
+// ListAPIKeysHandler godoc
+// @Summary 列出 API key
+// @Description 列出未吊销的 API key，不包含明文
+// @Tags API Key
+// @Produce json
+// @Success 200 {object} response.SuccessResponse{data=[]APIKeyResponse}
+// @Failure 403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/api-keys [get]
+// @DeprecatedRouter /apiKeys [get]
+func ListAPIKeysHandler(app *app.App) gin.HandlerFunc {
+	apiKeys := auth.NewAPIKeyStore(app.DB)
+	return func(c *gin.Context) {
+		keys, err := apiKeys.List(c.Request.Context())
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		resp := make([]APIKeyResponse, 0, len(keys))
+		for i := range keys {
+			resp = append(resp, newAPIKeyResponse(&keys[i]))
+		}
+		response.OK(c, resp)
+	}
+}
+
+// RevokeAPIKeyHandler godoc
+// @Summary 吊销 API key
+// @Description 吊销后该 key 立即不可用
+// @Tags API Key
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/api-keys/{id} [delete]
+// @DeprecatedRouter /apiKeys/{id} [delete]
+func RevokeAPIKeyHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("Invalid API key id"))
+			return
+		}
+
+		err = app.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			if err := auth.NewAPIKeyStore(tx).Revoke(c.Request.Context(), uint(id)); err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{Action: "api_key.revoke", Target: apiKeyTarget(uint(id))})
+		})
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				response.Fail(c, response.ErrAPIKeyNotFound)
+			} else {
+				response.Fail(c, response.ErrDatabase.Wrap(err))
+			}
+			return
+		}
+
+		pkg.LoggerFromContext(c.Request.Context()).Infof("API key %d revoked", id)
+		response.Success(c, http.StatusOK, response.MsgAPIKeyRevoked, nil)
+	}
+}
+
+func apiKeyTarget(id uint) string {
+	return fmt.Sprintf("api_key:%d", id)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"gorm.io/gorm"
+)
+
+// auditEntry 补全操作者、请求 ID 和来源 IP。
+// ClientIP 只在请求来自 server.trusted_proxies 时才使用 X-Forwarded-For，客户端无法伪造
+func auditEntry(c *gin.Context, entry audit.Entry) audit.Entry {
+	if entry.Actor == "" {
+		if principal, ok := c.Get("principal"); ok {
+			entry.Actor = principal.(*auth.Principal).String()
+		}
+	}
+	entry.RequestID = c.GetString("requestID")
+	entry.IP = c.ClientIP()
+	return entry
+}
+
+// recordAuditTx 在状态变更的事务中写入审计日志，写入失败时整个事务回滚
+func recordAuditTx(c *gin.Context, tx *gorm.DB, entry audit.Entry) error {
+	return audit.NewRecorder(tx).Record(c.Request.Context(), auditEntry(c, entry))
+}
+
+// recordAudit 写入审计日志，用于没有数据库状态变更的操作（如导出、Redis 中的锁定状态）。
+// 写入失败时请求失败，已写入响应
+func recordAudit(c *gin.Context, recorder *audit.Recorder, entry audit.Entry) bool {
+	if err := recorder.Record(c.Request.Context(), auditEntry(c, entry)); err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return false
+	}
+	return true
+}
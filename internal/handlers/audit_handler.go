@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditExportCSV    = "csv"
+	AuditExportNDJSON = "ndjson"
+
+	// 导出时每批读取的记录数
+	auditExportBatchSize = 500
+)
+
+type AuditLogListResponse struct {
+	Entries  []models.AuditLog `json:"entries"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+}
+
+// ListAuditLogsHandler godoc
+// @Summary 查询审计日志
+// @Description 分页查询审计日志，按时间倒序，仅审计员可用
+// @Tags 审计
+// @Produce json
+// @Param actor query string false "操作者，如 user:1"
+// @Param action query string false "操作，如 wallet.update"
+// @Param target query string false "操作对象，如 user:5"
+// @Param request_id query string false "请求 ID"
+// @Param from query string false "起始时间，RFC3339"
+// @Param to query string false "结束时间，RFC3339"
+// @Param page query int false "页码，从 1 开始"
+// @Param page_size query int false "每页数量，最大 100"
+// @Success 200 {object} response.SuccessResponse{data=AuditLogListResponse}
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/audit-logs [get]
+// @DeprecatedRouter /audit [get]
+func ListAuditLogsHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, pageSize, ok := parsePagination(c)
+		if !ok {
+			return
+		}
+		query, ok := auditLogQuery(c, app.DB.WithContext(c.Request.Context()))
+		if !ok {
+			return
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		entries := []models.AuditLog{}
+		if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&entries).Error; err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		response.OK(c, AuditLogListResponse{Entries: entries, Total: total, Page: page, PageSize: pageSize})
+	}
+}
+
+// ExportAuditLogsHandler godoc
+// @Summary 导出审计日志
+// @Description 按条件导出审计日志，按写入顺序排列，包含哈希链字段便于离线校验。导出操作本身也会被审计
+// @Tags 审计
+// @Produce json,application/x-ndjson,text/csv
+// @Param format query string false "导出格式" Enums(ndjson, csv) default(ndjson)
+// @Param actor query string false "操作者"
+// @Param action query string false "操作"
+// @Param target query string false "操作对象"
+// @Param request_id query string false "请求 ID"
+// @Param from query string false "起始时间，RFC3339"
+// @Param to query string false "结束时间，RFC3339"
+// @Success 200 {file} file
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/audit-logs/export [get]
+// @DeprecatedRouter /audit/export [get]
+func ExportAuditLogsHandler(app *app.App) gin.HandlerFunc {
+	recorder := audit.NewRecorder(app.DB)
+	return func(c *gin.Context) {
+		format := c.DefaultQuery("format", AuditExportNDJSON)
+		if format != AuditExportNDJSON && format != AuditExportCSV {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("format must be ndjson or csv"))
+			return
+		}
+		query, ok := auditLogQuery(c, app.DB.WithContext(c.Request.Context()))
+		if !ok {
+			return
+		}
+		// 导出本身需要留痕，审计写入失败时不导出
+		if !recordAudit(c, recorder, audit.Entry{Action: "audit.export", After: c.Request.URL.Query()}) {
+			return
+		}
+
+		filename := fmt.Sprintf("audit-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+		var write func(*models.AuditLog) error
+		var flush func() error
+		if format == AuditExportCSV {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			w := csv.NewWriter(c.Writer)
+			w.Write([]string{"id", "seq", "created_at", "actor", "action", "target", "before", "after", "request_id", "ip", "prev_hash", "hash"})
+			write = func(log *models.AuditLog) error { return w.Write(auditCSVRecord(log)) }
+			flush = func() error { w.Flush(); return w.Error() }
+		} else {
+			c.Header("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(c.Writer)
+			write = func(log *models.AuditLog) error { return enc.Encode(log) }
+			flush = func() error { return nil }
+		}
+		c.Status(http.StatusOK)
+
+		// 按 ID 分批读取，避免一次加载全部记录
+		var lastID uint
+		for {
+			var batch []models.AuditLog
+			if err := query.Session(&gorm.Session{}).Where("id > ?", lastID).Order("id").Limit(auditExportBatchSize).Find(&batch).Error; err != nil {
+				// 响应头已发送，只能中断输出
+				pkg.LoggerFromContext(c.Request.Context()).WithError(err).Error("Failed to export audit log")
+				return
+			}
+			for i := range batch {
+				if err := write(&batch[i]); err != nil {
+					return
+				}
+			}
+			if err := flush(); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			if len(batch) < auditExportBatchSize {
+				return
+			}
+			lastID = batch[len(batch)-1].ID
+		}
+	}
+}
+
+// auditLogQuery 根据查询参数构造过滤条件，失败时已写入响应
+func auditLogQuery(c *gin.Context, db *gorm.DB) (*gorm.DB, bool) {
+	query := db.Model(&models.AuditLog{})
+	for _, column := range []string{"actor", "action", "target", "request_id"} {
+		if v := c.Query(column); v != "" {
+			query = query.Where(column+" = ?", v)
+		}
+	}
+	for _, bound := range []struct{ param, cond string }{{"from", "created_at >= ?"}, {"to", "created_at <= ?"}} {
+		v := c.Query(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails(bound.param+" must be an RFC3339 timestamp"))
+			return nil, false
+		}
+		query = query.Where(bound.cond, t.UTC())
+	}
+	return query, true
+}
+
+func auditCSVRecord(log *models.AuditLog) []string {
+	seq := ""
+	if log.Seq != nil {
+		seq = strconv.FormatUint(*log.Seq, 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(log.ID), 10), seq, log.CreatedAt.UTC().Format(time.RFC3339Nano),
+		log.Actor, log.Action, log.Target, log.Before, log.After, log.RequestID, log.IP,
+		log.PrevHash, log.Hash,
+	}
+}
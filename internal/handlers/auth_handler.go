@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/kakaluote000/demo-api/pkg/security"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshTokenHandler godoc
+// @Summary 刷新 token
+// @Description 使用 refresh token 换取新的 access token 和 refresh token，旧 refresh token 立即失效
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body RefreshRequest true "refresh token"
+// @Success 200 {object} response.SuccessResponse{data=LoginResponse}
+// @Failure 400,401 {object} response.ErrorResponse
+// @Router /api/v1/auth/refresh [post]
+// @DeprecatedRouter /refresh [post]
+func RefreshTokenHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		pair, err := tokens.Refresh(c.Request.Context(), req.RefreshToken)
+		switch {
+		case errors.Is(err, auth.ErrRefreshTokenReused):
+			pkg.LoggerFromContext(c.Request.Context()).Warn("Refresh token reuse detected, session revoked")
+			response.Fail(c, response.ErrInvalidRefreshToken)
+			return
+		case errors.Is(err, auth.ErrRefreshTokenInvalid), errors.Is(err, auth.ErrNotRefreshToken), errors.Is(err, auth.ErrTokenRevoked):
+			response.Fail(c, response.ErrInvalidRefreshToken)
+			return
+		case err != nil:
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+
+		response.OK(c, newLoginResponse(pair))
+	}
+}
+
+// LogoutHandler godoc
+// @Summary 退出登录
+// @Description 注销当前会话，当前 access token 和对应的 refresh token 立即失效
+// @Tags 用户管理
+// @Produce json
+// @Success 200 {object} response.SuccessResponse
+// @Failure 401,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/logout [post]
+// @DeprecatedRouter /logout [post]
+func LogoutHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		claims, ok := c.Get("claims")
+		if !ok {
+			response.Fail(c, response.ErrUserSessionRequired)
+			return
+		}
+		if err := tokens.Revoke(c.Request.Context(), claims.(*auth.Claims)); err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgLoggedOut, nil)
+	}
+}
+
+// LogoutAllHandler godoc
+// @Summary 退出所有会话
+// @Description 注销当前用户的所有会话，之前签发的所有 token 立即失效
+// @Tags 用户管理
+// @Produce json
+// @Success 200 {object} response.SuccessResponse
+// @Failure 401,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/logout/all [post]
+// @DeprecatedRouter /logout/all [post]
+func LogoutAllHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		userID, ok := c.Get("userID")
+		if !ok {
+			response.Fail(c, response.ErrUserSessionRequired)
+			return
+		}
+		if err := tokens.RevokeAll(c.Request.Context(), userID.(uint)); err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgAllLoggedOut, nil)
+	}
+}
+
+// JWKSHandler godoc
+// @Summary JWT 公钥集合
+// @Description 返回校验 RS256/EdDSA token 所需的公钥 (JWKS)，按 kid 匹配
+// @Tags 用户管理
+// @Produce json
+// @Success 200 {object} auth.JWKS
+// @Router /.well-known/jwks.json [get]
+func JWKSHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 允许校验方缓存，轮换时新密钥应提前加入配置
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, auth.CurrentJWKS())
+	}
+}
+
+// 用户不存在时用于比较的哈希，使两种失败的耗时一致
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := security.HashPassword("dummy-password-for-timing")
+	if err != nil {
+		pkg.Log.WithError(err).Error("Failed to create dummy password hash")
+	}
+	return hash
+})
+
+// recordLoginFailure 记录登录失败，触发锁定时写入审计日志。
+// 无论账号是否存在都返回同样的响应。
+func recordLoginFailure(c *gin.Context, guard *auth.LoginGuard, recorder *audit.Recorder, username string) {
+	if countLoginFailure(c, guard, recorder, username) {
+		response.Fail(c, response.ErrInvalidCredentials)
+	}
+}
+
+// countLoginFailure 累计失败次数，密码和两步验证码的失败共用同一计数。
+// 锁定的审计日志写入失败时返回 false，已写入响应
+func countLoginFailure(c *gin.Context, guard *auth.LoginGuard, recorder *audit.Recorder, username string) bool {
+	metrics.LoginAttempts.WithLabelValues("failure").Inc()
+	lockouts, err := guard.RecordFailure(c.Request.Context(), username, c.ClientIP())
+	if err != nil {
+		pkg.LoggerFromContext(c.Request.Context()).WithError(err).Error("Failed to record login failure")
+	}
+	for _, lockout := range lockouts {
+		metrics.AccountLockouts.WithLabelValues(lockout.Scope).Inc()
+		pkg.LoggerFromContext(c.Request.Context()).Warnf("Login locked for %s %s after %d failures", lockout.Scope, lockout.Subject, lockout.Failures)
+		if !recordAudit(c, recorder, audit.Entry{
+			Action: "auth.lockout",
+			Target: lockout.Scope + ":" + lockout.Subject,
+			After:  lockout,
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkLoginGuard 检查账号或来源 IP 是否因失败次数过多被锁定，被锁定时已写入响应
+func checkLoginGuard(c *gin.Context, guard *auth.LoginGuard, username string) bool {
+	wait, err := guard.Check(c.Request.Context(), username, c.ClientIP())
+	if err != nil {
+		pkg.LoggerFromContext(c.Request.Context()).WithError(err).Error("Failed to check login lockout")
+		response.Fail(c, response.ErrServiceUnavailable.Wrap(err))
+		return false
+	}
+	if wait > 0 {
+		rejectThrottledLogin(c, wait)
+		return false
+	}
+	return true
+}
+
+func rejectThrottledLogin(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	response.Fail(c, response.ErrLoginThrottled)
+}
@@ -5,11 +5,18 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/pkg/response"
 )
 
+// HealthCheckHandler godoc
+// @Summary 存活检查
+// @Tags 监控
+// @Produce json
+// @Success 200 {object} response.SuccessResponse
+// @Router /health [get]
 func HealthCheckHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
+		response.Success(c, http.StatusOK, response.MsgHealthy, gin.H{
 			"status":  "ok",
 			"service": "currency-management-system",
 			"version": "1.0.0",
@@ -17,25 +24,39 @@ func HealthCheckHandler() gin.HandlerFunc {
 	}
 }
 
+// ReadinessCheckHandler godoc
+// @Summary 就绪检查
+// @Description 检查数据库和 Redis 连接，服务关闭过程中返回 503
+// @Tags 监控
+// @Produce json
+// @Success 200 {object} response.SuccessResponse
+// @Failure 503 {object} response.ErrorResponse
+// @Router /readiness [get]
 func ReadinessCheckHandler(app *app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// 关闭过程中不再接收新流量
+		if !app.Ready() {
+			response.Fail(c, response.ErrServiceUnavailable.WithDetails("Server is not accepting traffic"))
+			return
+		}
+
 		// 检查数据库连接
 		sqlDB, err := app.DB.DB()
 		if err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "message": "Database connection error"})
+			response.Fail(c, response.ErrServiceUnavailable.WithDetails("Database connection error").Wrap(err))
 			return
 		}
-		if err := sqlDB.Ping(); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "message": "Database ping failed"})
+		if err := sqlDB.PingContext(c.Request.Context()); err != nil {
+			response.Fail(c, response.ErrServiceUnavailable.WithDetails("Database ping failed").Wrap(err))
 			return
 		}
 
 		// 检查Redis连接
-		if err := app.Redis.Ping(app.Ctx).Err(); err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "message": "Redis connection error"})
+		if err := app.Redis.Ping(c.Request.Context()).Err(); err != nil {
+			response.Fail(c, response.ErrServiceUnavailable.WithDetails("Redis connection error").Wrap(err))
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		response.Success(c, http.StatusOK, response.MsgHealthy, gin.H{"status": "ok"})
 	}
 }
@@ -0,0 +1,558 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	defaultMFAIssuer  = "demo-api"
+)
+
+// MFAChallengeResponse 密码校验通过但还需两步验证时返回
+type MFAChallengeResponse struct {
+	// 已启用两步验证，使用 mfa_token 调用 /login/2fa 提交验证码
+	MFARequired bool `json:"mfa_required,omitempty"`
+	// 角色要求两步验证但尚未绑定，使用 mfa_token 调用 /login/2fa/enroll 完成绑定
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+	MFAToken              string `json:"mfa_token"`
+	ExpiresIn             int64  `json:"expires_in"`
+}
+
+// MFALoginRequest 验证码和恢复码二选一
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// MFAVerifyRequest 登录过程中绑定时需要带上 mfa_token
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type MFAEnrollResponse struct {
+	Secret string `json:"secret"`
+	// otpauth:// URI，客户端据此生成二维码
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// MFAVerifyResponse 恢复码明文只在此时返回一次
+type MFAVerifyResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// 登录过程中完成绑定时同时返回 token
+	*LoginResponse
+}
+
+// LoginMFAHandler godoc
+// @Summary 两步验证登录
+// @Description 使用 /login 返回的 mfa_token 和验证器中的验证码（或一次性恢复码）完成登录
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFALoginRequest true "两步验证信息"
+// @Success 200 {object} response.SuccessResponse{data=LoginResponse}
+// @Failure 400,401,429,500 {object} response.ErrorResponse
+// @Router /api/v1/auth/login/2fa [post]
+// @DeprecatedRouter /login/2fa [post]
+func LoginMFAHandler(app *app.App) gin.HandlerFunc {
+	guard := app.LoginGuard
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+
+	return func(c *gin.Context) {
+		var req MFALoginRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		if (req.Code == "") == (req.RecoveryCode == "") {
+			response.Fail(c, response.ErrMFACodeRequired)
+			return
+		}
+
+		claims, user, ok := loadMFAChallenge(c, app.DB, tokens, req.MFAToken, auth.TokenTypeMFA)
+		if !ok {
+			return
+		}
+
+		// 验证码错误与密码错误共用失败计数和锁定
+		if !checkLoginGuard(c, guard, user.Username) {
+			return
+		}
+
+		var valid bool
+		var err error
+		if req.RecoveryCode != "" {
+			// 恢复码的使用和审计日志在同一事务中，审计写入失败时恢复码不被消耗
+			err = app.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+				var err error
+				if valid, err = useRecoveryCode(tx, user.ID, req.RecoveryCode); err != nil || !valid {
+					return err
+				}
+				return recordAuditTx(c, tx, audit.Entry{Actor: userActor(user.ID), Action: "mfa.recovery_code_used", Target: userActor(user.ID)})
+			})
+		} else {
+			valid, err = verifyTOTP(c, tokens, user, req.Code)
+		}
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		if !valid {
+			if countLoginFailure(c, guard, recorder, user.Username) {
+				response.Fail(c, response.ErrInvalidMFACode)
+			}
+			return
+		}
+
+		role, ok := auth.ParseRole(user.Role)
+		if !ok {
+			response.Fail(c, response.ErrPermissionDenied)
+			return
+		}
+		// 凭证只能使用一次
+		if err := tokens.Revoke(c.Request.Context(), claims); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Warn("Failed to revoke mfa challenge")
+		}
+		pair, ok := issueLoginTokens(c, guard, tokens, user, role)
+		if !ok {
+			return
+		}
+		response.OK(c, newLoginResponse(pair))
+	}
+}
+
+// LoginMFAEnrollHandler godoc
+// @Summary 登录时绑定验证器
+// @Description 角色要求两步验证但尚未绑定时，使用 /login 返回的 mfa_token 生成 TOTP 密钥
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFAEnrollRequest true "mfa_token"
+// @Success 200 {object} response.SuccessResponse{data=MFAEnrollResponse}
+// @Failure 400,401,409 {object} response.ErrorResponse
+// @Router /api/v1/auth/login/2fa/enroll [post]
+// @DeprecatedRouter /login/2fa/enroll [post]
+func LoginMFAEnrollHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req MFAEnrollRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		_, user, ok := loadMFAChallenge(c, app.DB, tokens, req.MFAToken, auth.TokenTypeMFAEnroll)
+		if !ok {
+			return
+		}
+		startMFAEnrollment(c, app.DB, user)
+	}
+}
+
+// LoginMFAEnrollVerifyHandler godoc
+// @Summary 登录时确认绑定验证器
+// @Description 提交验证器中的验证码完成绑定，返回一次性恢复码和 token
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFAVerifyRequest true "mfa_token 和验证码"
+// @Success 200 {object} response.SuccessResponse{data=MFAVerifyResponse}
+// @Failure 400,401,409,429,500,503 {object} response.ErrorResponse
+// @Router /api/v1/auth/login/2fa/enroll/verify [post]
+// @DeprecatedRouter /login/2fa/enroll/verify [post]
+func LoginMFAEnrollVerifyHandler(app *app.App) gin.HandlerFunc {
+	guard := app.LoginGuard
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+
+	return func(c *gin.Context) {
+		var req MFAVerifyRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		claims, user, ok := loadMFAChallenge(c, app.DB, tokens, req.MFAToken, auth.TokenTypeMFAEnroll)
+		if !ok {
+			return
+		}
+		role, ok := auth.ParseRole(user.Role)
+		if !ok {
+			response.Fail(c, response.ErrPermissionDenied)
+			return
+		}
+
+		codes, ok := confirmMFAEnrollment(c, app.DB, guard, recorder, tokens, user, req.Code)
+		if !ok {
+			return
+		}
+		if err := tokens.Revoke(c.Request.Context(), claims); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Warn("Failed to revoke mfa challenge")
+		}
+		pair, ok := issueLoginTokens(c, guard, tokens, user, role)
+		if !ok {
+			return
+		}
+		resp := newLoginResponse(pair)
+		response.OK(c, MFAVerifyResponse{RecoveryCodes: codes, LoginResponse: &resp})
+	}
+}
+
+// EnrollMFAHandler godoc
+// @Summary 绑定验证器
+// @Description 为当前用户生成 TOTP 密钥，需再调用 /2fa/verify 确认后才会启用
+// @Tags 用户管理
+// @Produce json
+// @Success 200 {object} response.SuccessResponse{data=MFAEnrollResponse}
+// @Failure 400,409 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/2fa/enroll [post]
+// @DeprecatedRouter /2fa/enroll [post]
+func EnrollMFAHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := currentMFAUser(c, app.DB)
+		if !ok {
+			return
+		}
+		startMFAEnrollment(c, app.DB, user)
+	}
+}
+
+// VerifyMFAHandler godoc
+// @Summary 确认绑定验证器
+// @Description 提交验证器中的验证码启用两步验证，返回一次性恢复码
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFAVerifyRequest true "验证码"
+// @Success 200 {object} response.SuccessResponse{data=MFAVerifyResponse}
+// @Failure 400,409,429,500,503 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/2fa/verify [post]
+// @DeprecatedRouter /2fa/verify [post]
+func VerifyMFAHandler(app *app.App) gin.HandlerFunc {
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req MFAVerifyRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		user, ok := currentMFAUser(c, app.DB)
+		if !ok {
+			return
+		}
+		codes, ok := confirmMFAEnrollment(c, app.DB, app.LoginGuard, recorder, tokens, user, req.Code)
+		if !ok {
+			return
+		}
+		response.OK(c, MFAVerifyResponse{RecoveryCodes: codes})
+	}
+}
+
+// RegenerateRecoveryCodesHandler godoc
+// @Summary 重新生成恢复码
+// @Description 提交当前验证码后生成新的恢复码，旧恢复码全部失效
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFAVerifyRequest true "验证码"
+// @Success 200 {object} response.SuccessResponse{data=MFAVerifyResponse}
+// @Failure 400,429,500,503 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/2fa/recovery-codes [post]
+// @DeprecatedRouter /2fa/recoveryCodes [post]
+func RegenerateRecoveryCodesHandler(app *app.App) gin.HandlerFunc {
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req MFAVerifyRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		user, ok := currentMFAUser(c, app.DB)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled {
+			response.Fail(c, response.ErrMFANotEnabled)
+			return
+		}
+		if !checkTOTP(c, app.LoginGuard, recorder, tokens, user, req.Code) {
+			return
+		}
+
+		var codes []string
+		err := app.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			var err error
+			if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{Action: "mfa.recovery_codes_regenerated", Target: userActor(user.ID)})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.OK(c, MFAVerifyResponse{RecoveryCodes: codes})
+	}
+}
+
+// DisableMFAHandler godoc
+// @Summary 关闭两步验证
+// @Description 提交当前验证码后关闭两步验证并删除恢复码，角色要求两步验证时不允许关闭
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body MFAVerifyRequest true "验证码"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,403,429,500,503 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/2fa/disable [post]
+// @DeprecatedRouter /2fa/disable [post]
+func DisableMFAHandler(app *app.App) gin.HandlerFunc {
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req MFAVerifyRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		user, ok := currentMFAUser(c, app.DB)
+		if !ok {
+			return
+		}
+		if !user.TOTPEnabled {
+			response.Fail(c, response.ErrMFANotEnabled)
+			return
+		}
+		if auth.RequiresMFA(auth.Role(user.Role), pkg.CurrentConfig().Security.MFA.RequiredRoles) {
+			response.Fail(c, response.ErrMFARequired)
+			return
+		}
+		if !checkTOTP(c, app.LoginGuard, recorder, tokens, user, req.Code) {
+			return
+		}
+
+		err := app.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Updates(map[string]interface{}{"totp_secret": "", "totp_enabled": false}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{Action: "mfa.disable", Target: userActor(user.ID)})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgMFADisabled, nil)
+	}
+}
+
+// mfaChallengeType 返回登录需要的两步验证凭证类型，不需要两步验证时返回空
+func mfaChallengeType(user *models.User, role auth.Role) string {
+	if user.TOTPEnabled {
+		return auth.TokenTypeMFA
+	}
+	if auth.RequiresMFA(role, pkg.CurrentConfig().Security.MFA.RequiredRoles) {
+		return auth.TokenTypeMFAEnroll
+	}
+	return ""
+}
+
+func respondMFAChallenge(c *gin.Context, tokens *auth.TokenStore, user *models.User, role auth.Role, tokenType string) {
+	expiry := pkg.CurrentConfig().Security.MFA.ChallengeExpiry
+	token, claims, err := tokens.IssueMFAChallenge(c.Request.Context(), user.ID, role, tokenType, expiry)
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return
+	}
+	response.OK(c, MFAChallengeResponse{
+		MFARequired:           tokenType == auth.TokenTypeMFA,
+		MFAEnrollmentRequired: tokenType == auth.TokenTypeMFAEnroll,
+		MFAToken:              token,
+		ExpiresIn:             int64(time.Until(claims.ExpiresAt.Time).Seconds()),
+	})
+}
+
+// loadMFAChallenge 校验两步验证凭证并加载用户，失败时已写入响应
+func loadMFAChallenge(c *gin.Context, db *gorm.DB, tokens *auth.TokenStore, token, tokenType string) (*auth.Claims, *models.User, bool) {
+	claims, err := tokens.ParseMFAChallenge(c.Request.Context(), token, tokenType)
+	if errors.Is(err, auth.ErrMFAChallengeInvalid) {
+		response.Fail(c, response.ErrInvalidMFAToken)
+		return nil, nil, false
+	}
+	if err != nil {
+		pkg.LoggerFromContext(c.Request.Context()).WithError(err).Error("Failed to check mfa challenge")
+		response.Fail(c, response.ErrServiceUnavailable.Wrap(err))
+		return nil, nil, false
+	}
+
+	var user models.User
+	if err := db.WithContext(c.Request.Context()).First(&user, claims.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			response.Fail(c, response.ErrInvalidMFAToken)
+		} else {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+		}
+		return nil, nil, false
+	}
+	return claims, &user, true
+}
+
+// currentMFAUser 加载当前登录用户，API key 不能绑定验证器
+func currentMFAUser(c *gin.Context, db *gorm.DB) (*models.User, bool) {
+	userID, ok := c.Get("userID")
+	if !ok {
+		response.Fail(c, response.ErrUserSessionRequired)
+		return nil, false
+	}
+	var user models.User
+	if err := db.WithContext(c.Request.Context()).First(&user, userID.(uint)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			response.Fail(c, response.ErrUserNotFound)
+		} else {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+		}
+		return nil, false
+	}
+	return &user, true
+}
+
+// startMFAEnrollment 生成新的 TOTP 密钥，确认前不会启用，重复调用会覆盖未确认的密钥
+func startMFAEnrollment(c *gin.Context, db *gorm.DB, user *models.User) {
+	if user.TOTPEnabled {
+		response.Fail(c, response.ErrMFAAlreadyEnabled)
+		return
+	}
+	secret, err := security.GenerateTOTPSecret()
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return
+	}
+	if err := db.WithContext(c.Request.Context()).Model(user).Update("totp_secret", secret).Error; err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return
+	}
+
+	issuer := pkg.CurrentConfig().Security.MFA.Issuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+	response.OK(c, MFAEnrollResponse{
+		Secret:          secret,
+		ProvisioningURI: security.TOTPProvisioningURI(issuer, user.Username, secret),
+	})
+}
+
+// confirmMFAEnrollment 校验验证码后启用两步验证并生成恢复码，失败时已写入响应
+func confirmMFAEnrollment(c *gin.Context, db *gorm.DB, guard *auth.LoginGuard, recorder *audit.Recorder, tokens *auth.TokenStore, user *models.User, code string) ([]string, bool) {
+	if user.TOTPEnabled {
+		response.Fail(c, response.ErrMFAAlreadyEnabled)
+		return nil, false
+	}
+	if user.TOTPSecret == "" {
+		response.Fail(c, response.ErrMFANotEnrolling)
+		return nil, false
+	}
+	if !checkTOTP(c, guard, recorder, tokens, user, code) {
+		return nil, false
+	}
+
+	var codes []string
+	err := db.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		if codes, err = replaceRecoveryCodes(tx, user.ID); err != nil {
+			return err
+		}
+		return recordAuditTx(c, tx, audit.Entry{Actor: userActor(user.ID), Action: "mfa.enable", Target: userActor(user.ID)})
+	})
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return nil, false
+	}
+	return codes, true
+}
+
+// checkTOTP 校验已登录用户提交的验证码，失败时已写入响应。
+// 与登录共用失败计数和锁定，持有 token 的人不能借此无限次猜测验证码
+func checkTOTP(c *gin.Context, guard *auth.LoginGuard, recorder *audit.Recorder, tokens *auth.TokenStore, user *models.User, code string) bool {
+	if !checkLoginGuard(c, guard, user.Username) {
+		return false
+	}
+	valid, err := verifyTOTP(c, tokens, user, code)
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return false
+	}
+	if !valid {
+		if countLoginFailure(c, guard, recorder, user.Username) {
+			response.Fail(c, response.ErrInvalidTOTPCode)
+		}
+		return false
+	}
+	return true
+}
+
+// verifyTOTP 校验验证码，同一时间步的验证码只能使用一次
+func verifyTOTP(c *gin.Context, tokens *auth.TokenStore, user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+	counter, ok := security.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return tokens.MarkTOTPUsed(c.Request.Context(), user.ID, counter)
+}
+
+// useRecoveryCode 将匹配的未使用恢复码标记为已使用
+func useRecoveryCode(db *gorm.DB, userID uint, code string) (bool, error) {
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, security.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// replaceRecoveryCodes 删除旧恢复码并生成新的一组，返回明文
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	codes, err := security.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	records := make([]models.RecoveryCode, len(codes))
+	for i, code := range codes {
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: security.HashRecoveryCode(code)}
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func userActor(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/notification"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type PasswordResetRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// ChangePasswordHandler godoc
+// @Summary 修改密码
+// @Description 校验旧密码后修改密码，其他会话全部注销，返回新的 token。旧密码错误与登录失败共用计数和锁定
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body ChangePasswordRequest true "旧密码和新密码"
+// @Success 200 {object} response.SuccessResponse{data=LoginResponse}
+// @Failure 400,401,429,500,503 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/auth/password/change [post]
+// @DeprecatedRouter /password/change [post]
+func ChangePasswordHandler(app *app.App) gin.HandlerFunc {
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	guard := app.LoginGuard
+	return func(c *gin.Context) {
+		var req ChangePasswordRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		userID, ok := c.Get("userID")
+		if !ok {
+			response.Fail(c, response.ErrUserSessionRequired)
+			return
+		}
+
+		db := app.DB.WithContext(c.Request.Context())
+		var user models.User
+		if err := db.First(&user, userID.(uint)).Error; err != nil {
+			response.Fail(c, response.ErrUserNotFound)
+			return
+		}
+
+		// 拿到会话的人不能借此无限次猜测旧密码
+		if !checkLoginGuard(c, guard, user.Username) {
+			return
+		}
+		if !security.CheckPasswordHash(req.OldPassword, user.Password) {
+			if countLoginFailure(c, guard, recorder, user.Username) {
+				response.Fail(c, response.ErrWrongPassword)
+			}
+			return
+		}
+		hashedPassword, ok := hashNewPassword(c, req.NewPassword, user.Username)
+		if !ok {
+			return
+		}
+
+		// 先注销所有会话再修改密码，注销失败时密码保持不变
+		if err := tokens.RevokeAll(c.Request.Context(), user.ID); err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		if !savePassword(c, db, &user, hashedPassword, audit.Entry{Action: "auth.password_change", Target: userActor(user.ID)}) {
+			return
+		}
+
+		// 为当前客户端签发新的 token
+		role, _ := auth.ParseRole(user.Role)
+		pair, err := tokens.IssueTokens(c.Request.Context(), user.ID, role)
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.OK(c, newLoginResponse(pair))
+	}
+}
+
+// RequestPasswordResetHandler godoc
+// @Summary 申请重置密码
+// @Description 通过用户通知渠道发送一次性重置链接。无论账号是否存在都返回相同响应
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body PasswordResetRequest true "用户名"
+// @Success 202 {object} response.SuccessResponse
+// @Failure 400 {object} response.ErrorResponse
+// @Router /api/v1/auth/password/reset [post]
+// @DeprecatedRouter /password/reset [post]
+func RequestPasswordResetHandler(app *app.App) gin.HandlerFunc {
+	sender := newPasswordResetSender(app)
+	return func(c *gin.Context) {
+		var req PasswordResetRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		// 查询账号、签发 token 和发送都在后台进行，响应时间不因账号是否存在而不同
+		if !sender.enqueue(pkg.LoggerFromContext(c.Request.Context()), req.Username) {
+			pkg.LoggerFromContext(c.Request.Context()).Warn("Too many pending password resets, request dropped")
+		}
+		response.Success(c, http.StatusAccepted, response.MsgPasswordResetSent, nil)
+	}
+}
+
+const (
+	// 同时进行的重置链接发送上限，超过时丢弃新的申请，未认证的请求不能无限占用资源
+	maxPasswordResetSends = 32
+	// 单次发送（查询账号、签发 token 和通知）的超时时间
+	passwordResetSendTimeout = 30 * time.Second
+)
+
+// passwordResetSender 在后台发送重置链接。发送随 App.Ctx 取消，应用关闭时等待已开始的发送完成
+type passwordResetSender struct {
+	app                 *app.App
+	resets              *auth.PasswordResetStore
+	notificationManager *notification.NotificationManager
+	slots               chan struct{}
+	wg                  sync.WaitGroup
+}
+
+func newPasswordResetSender(app *app.App) *passwordResetSender {
+	s := &passwordResetSender{
+		app:                 app,
+		resets:              auth.NewPasswordResetStore(app.Redis),
+		notificationManager: notification.NewNotificationManagerFromConfig(pkg.CurrentConfig().Notification),
+		slots:               make(chan struct{}, maxPasswordResetSends),
+	}
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		s.notificationManager.ApplyConfig(next.Notification)
+	})
+	// 进行中的请求处理完后才停止后台任务，此时不会再有新的发送
+	app.AddWorker("password-reset", func(ctx context.Context) {
+		<-ctx.Done()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// enqueue 在后台为用户发送重置链接，同时进行的发送已达上限时返回 false
+func (s *passwordResetSender) enqueue(log *logrus.Entry, username string) bool {
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		return false
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.slots }()
+		ctx, cancel := context.WithTimeout(pkg.ContextWithLogger(s.app.Ctx, log), passwordResetSendTimeout)
+		defer cancel()
+		s.send(ctx, username)
+	}()
+	return true
+}
+
+// send 为存在的账号签发重置 token 并通过用户通知渠道发送，账号不存在时什么也不做
+func (s *passwordResetSender) send(ctx context.Context, username string) {
+	log := pkg.LoggerFromContext(ctx)
+
+	var user models.User
+	err := s.app.DB.WithContext(ctx).Where("username = ?", username).First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to look up user for password reset")
+		return
+	}
+
+	cfg := pkg.CurrentConfig().Security.PasswordReset
+	token, err := s.resets.Issue(ctx, user.ID, cfg.Expiry)
+	if err != nil {
+		log.WithError(err).Error("Failed to issue password reset token")
+		return
+	}
+	for _, err := range s.notificationManager.NotifyUser(user.Username, passwordResetMessage(cfg, token)) {
+		log.WithError(err).Errorf("Failed to send password reset to user %d", user.ID)
+	}
+}
+
+// ConfirmPasswordResetHandler godoc
+// @Summary 重置密码
+// @Description 使用重置 token 设置新密码，token 只能使用一次，成功后所有会话被注销
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param body body PasswordResetConfirmRequest true "重置 token 和新密码"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,500 {object} response.ErrorResponse
+// @Router /api/v1/auth/password/reset/confirm [post]
+// @DeprecatedRouter /password/reset/confirm [post]
+func ConfirmPasswordResetHandler(app *app.App) gin.HandlerFunc {
+	resets := auth.NewPasswordResetStore(app.Redis)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	guard := app.LoginGuard
+
+	return func(c *gin.Context) {
+		var req PasswordResetConfirmRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		userID, err := resets.Lookup(c.Request.Context(), req.Token)
+		if errors.Is(err, auth.ErrResetTokenInvalid) {
+			response.Fail(c, response.ErrInvalidResetToken)
+			return
+		}
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		db := app.DB.WithContext(c.Request.Context())
+		var user models.User
+		if err := db.First(&user, userID).Error; err != nil {
+			response.Fail(c, response.ErrInvalidResetToken)
+			return
+		}
+		// 先检查密码策略，避免不合格的密码消耗掉 token
+		hashedPassword, ok := hashNewPassword(c, req.NewPassword, user.Username)
+		if !ok {
+			return
+		}
+
+		// 与修改密码相同，先注销所有会话再修改密码。注销失败时 token 没有被消耗，可以重试
+		if err := tokens.RevokeAll(c.Request.Context(), user.ID); err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		// token 只能使用一次，并发请求中只有一个能成功
+		if _, err := resets.Consume(c.Request.Context(), req.Token); err != nil {
+			response.Fail(c, response.ErrInvalidResetToken)
+			return
+		}
+		if !savePassword(c, db, &user, hashedPassword, audit.Entry{Actor: userActor(user.ID), Action: "auth.password_reset", Target: userActor(user.ID)}) {
+			return
+		}
+
+		// 重置成功说明是本人操作，解除因密码错误导致的锁定
+		if _, err := guard.Unlock(c.Request.Context(), user.Username); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Warn("Failed to clear login lockout")
+		}
+		response.Success(c, http.StatusOK, response.MsgPasswordReset, nil)
+	}
+}
+
+// checkPasswordPolicy 检查密码策略，未通过时返回每一条未满足的规则
+func checkPasswordPolicy(c *gin.Context, password, username string) bool {
+	if violations := security.CheckPassword(password, username); len(violations) > 0 {
+		response.Fail(c, response.ErrWeakPassword.WithDetails(violations))
+		return false
+	}
+	return true
+}
+
+// hashNewPassword 检查密码策略后计算新密码的哈希，失败时已写入响应
+func hashNewPassword(c *gin.Context, password, username string) (string, bool) {
+	if !checkPasswordPolicy(c, password, username) {
+		return "", false
+	}
+	hashedPassword, err := security.HashPassword(password)
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return "", false
+	}
+	return hashedPassword, true
+}
+
+// savePassword 在同一事务中保存新密码的哈希并写入审计日志，失败时已写入响应
+func savePassword(c *gin.Context, db *gorm.DB, user *models.User, hashedPassword string, entry audit.Entry) bool {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return recordAuditTx(c, tx, entry)
+	})
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return false
+	}
+	return true
+}
+
+func passwordResetMessage(cfg pkg.PasswordResetConfig, token string) string {
+	if cfg.URL == "" {
+		return fmt.Sprintf("密码重置 token: %s", token)
+	}
+	link, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Sprintf("密码重置 token: %s", token)
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return fmt.Sprintf("点击链接重置密码: %s", link)
+}
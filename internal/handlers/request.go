@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+// bindJSON 解析并校验请求体，失败时已写入响应，每个未通过校验的字段都列在 details 中。
+// 请求体会缓存在上下文中，中间件已经读取过时同样可以解析
+func bindJSON(c *gin.Context, req interface{}) bool {
+	if err := c.ShouldBindBodyWith(req, binding.JSON); err != nil {
+		response.Fail(c, response.Invalid(err))
+		return false
+	}
+	return true
+}
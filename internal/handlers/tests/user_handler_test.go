@@ -10,7 +10,6 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
 	"github.com/kakaluote000/demo-api/internal/handlers"
-	"github.com/kakaluote000/demo-api/internal/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -22,12 +21,12 @@ func TestRegisterHandler(t *testing.T) {
 
 	tests := []struct {
 		name       string
-		user       models.User
+		user       handlers.RegisterRequest
 		wantStatus int
 	}{
 		{
 			name: "Valid registration",
-			user: models.User{
+			user: handlers.RegisterRequest{
 				Username: "testuser",
 				Password: "password123",
 			},
@@ -35,7 +34,7 @@ func TestRegisterHandler(t *testing.T) {
 		},
 		{
 			name: "Invalid registration - empty username",
-			user: models.User{
+			user: handlers.RegisterRequest{
 				Password: "password123",
 			},
 			wantStatus: http.StatusBadRequest,
@@ -0,0 +1,565 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	UserStatusActive   = "active"
+	UserStatusDisabled = "disabled"
+	UserStatusDeleted  = "deleted"
+)
+
+// UserSummary 管理接口返回的用户信息，不包含密码哈希
+type UserSummary struct {
+	ID          uint       `json:"id"`
+	Username    string     `json:"username"`
+	Role        string     `json:"role"`
+	Status      string     `json:"status"`
+	TOTPEnabled bool       `json:"totp_enabled"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	DisabledAt  *time.Time `json:"disabled_at,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+}
+
+type UserListResponse struct {
+	Users    []UserSummary `json:"users"`
+	Total    int64         `json:"total"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+}
+
+type UserDetailResponse struct {
+	User    UserSummary      `json:"user"`
+	Wallets []WalletResponse `json:"wallets"`
+}
+
+// SetUserRoleRequest 修改用户角色的请求
+type SetUserRoleRequest struct {
+	Role string `json:"role" binding:"required" example:"operator"`
+}
+
+func newUserSummary(user *models.User) UserSummary {
+	summary := UserSummary{
+		ID:          user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		Status:      UserStatusActive,
+		TOTPEnabled: user.TOTPEnabled,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
+		DisabledAt:  user.DisabledAt,
+	}
+	if user.DisabledAt != nil {
+		summary.Status = UserStatusDisabled
+	}
+	if user.DeletedAt.Valid {
+		summary.Status = UserStatusDeleted
+		summary.DeletedAt = &user.DeletedAt.Time
+	}
+	return summary
+}
+
+// ListUsersHandler godoc
+// @Summary 用户列表
+// @Description 分页列出用户，支持按用户名模糊搜索、按角色和状态过滤
+// @Tags 用户管理
+// @Produce json
+// @Param q query string false "用户名关键字"
+// @Param role query string false "角色"
+// @Param status query string false "active、disabled 或 deleted"
+// @Param page query int false "页码，从 1 开始"
+// @Param page_size query int false "每页数量，最大 100"
+// @Success 200 {object} response.SuccessResponse{data=UserListResponse}
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users [get]
+// @DeprecatedRouter /users [get]
+func ListUsersHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, pageSize, ok := parsePagination(c)
+		if !ok {
+			return
+		}
+
+		query := app.DB.WithContext(c.Request.Context()).Model(&models.User{})
+		switch c.Query("status") {
+		case "":
+			// 默认不包含已删除的用户
+		case UserStatusActive:
+			query = query.Where("disabled_at IS NULL")
+		case UserStatusDisabled:
+			query = query.Where("disabled_at IS NOT NULL")
+		case UserStatusDeleted:
+			query = query.Unscoped().Where("deleted_at IS NOT NULL")
+		default:
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("status must be one of active, disabled or deleted"))
+			return
+		}
+		if q := strings.TrimSpace(c.Query("q")); q != "" {
+			query = query.Where("username LIKE ? ESCAPE '!'", "%"+escapeLike(q)+"%")
+		}
+		if role := c.Query("role"); role != "" {
+			if _, ok := auth.ParseRole(role); !ok {
+				response.Fail(c, response.ErrUnknownRole)
+				return
+			}
+			query = query.Where("role = ?", role)
+		}
+
+		var total int64
+		if err := query.Count(&total).Error; err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		var users []models.User
+		if err := query.Order("id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error; err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+
+		resp := UserListResponse{Users: make([]UserSummary, len(users)), Total: total, Page: page, PageSize: pageSize}
+		for i := range users {
+			resp.Users[i] = newUserSummary(&users[i])
+		}
+		response.OK(c, resp)
+	}
+}
+
+// GetUserHandler godoc
+// @Summary 用户详情
+// @Description 返回用户信息及其钱包，包括已删除的用户
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse{data=UserDetailResponse}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id} [get]
+// @DeprecatedRouter /users/{id} [get]
+func GetUserHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := app.DB.WithContext(c.Request.Context())
+		user, ok := loadUserParam(c, db.Unscoped())
+		if !ok {
+			return
+		}
+
+		var wallets []models.UserCurrency
+		if err := db.Where("user_id = ?", user.ID).Order("currency_id").Find(&wallets).Error; err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		resp := UserDetailResponse{User: newUserSummary(user), Wallets: make([]WalletResponse, 0, len(wallets))}
+		for i := range wallets {
+			resp.Wallets = append(resp.Wallets, newWalletResponse(&wallets[i]))
+		}
+		response.OK(c, resp)
+	}
+}
+
+// DisableUserHandler godoc
+// @Summary 禁用用户
+// @Description 禁用后用户无法登录，已签发的 token 立即失效
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse{data=UserSummary}
+// @Failure 400,403,404,409,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/disable [post]
+// @DeprecatedRouter /users/{id}/disable [post]
+func DisableUserHandler(app *app.App) gin.HandlerFunc {
+	return setUserDisabledHandler(app, true)
+}
+
+// EnableUserHandler godoc
+// @Summary 启用用户
+// @Description 解除禁用，用户需要重新登录
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse{data=UserSummary}
+// @Failure 400,403,404,409,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/enable [post]
+// @DeprecatedRouter /users/{id}/enable [post]
+func EnableUserHandler(app *app.App) gin.HandlerFunc {
+	return setUserDisabledHandler(app, false)
+}
+
+func setUserDisabledHandler(app *app.App, disable bool) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	action := "user.enable"
+	if disable {
+		action = "user.disable"
+	}
+
+	return func(c *gin.Context) {
+		db := app.DB.WithContext(c.Request.Context())
+		user, ok := loadUserParam(c, db)
+		if !ok {
+			return
+		}
+		if disable && isCurrentUser(c, user.ID) {
+			response.Fail(c, response.ErrCannotModifySelf)
+			return
+		}
+		if disable && user.DisabledAt != nil {
+			response.Fail(c, response.ErrUserAlreadyDisabled)
+			return
+		}
+		if !disable && user.DisabledAt == nil {
+			response.Fail(c, response.ErrUserNotDisabled)
+			return
+		}
+
+		before := newUserSummary(user)
+		var disabledAt *time.Time
+		if disable {
+			now := time.Now()
+			disabledAt = &now
+		}
+		var after UserSummary
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Update("disabled_at", disabledAt).Error; err != nil {
+				return err
+			}
+			user.DisabledAt = disabledAt
+			after = newUserSummary(user)
+			return recordAuditTx(c, tx, audit.Entry{Action: action, Target: userActor(user.ID), Before: before, After: after})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+
+		// 先更新数据库再更新 Redis。Redis 失败时登录仍会被拒绝，已签发的 token 在缓存过期前可能继续有效
+		if err := tokens.SetUserDisabled(c.Request.Context(), user.ID, disable); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Errorf("Failed to update session state for user %d", user.ID)
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.OK(c, after)
+	}
+}
+
+// DeleteUserHandler godoc
+// @Summary 删除用户
+// @Description 软删除用户并注销其所有会话，可通过 restore 恢复
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id} [delete]
+// @DeprecatedRouter /users/{id} [delete]
+func DeleteUserHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		db := app.DB.WithContext(c.Request.Context())
+		user, ok := loadUserParam(c, db)
+		if !ok {
+			return
+		}
+		if isCurrentUser(c, user.ID) {
+			response.Fail(c, response.ErrCannotModifySelf)
+			return
+		}
+
+		// 先注销所有会话再删除，注销失败时用户保持不变，与禁用相同不允许 token 在删除后继续有效
+		if err := tokens.RevokeAll(c.Request.Context(), user.ID); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Errorf("Failed to revoke sessions for user %d", user.ID)
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+
+		before := newUserSummary(user)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(user).Error; err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{Action: "user.delete", Target: userActor(user.ID), Before: before})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgUserDeleted, nil)
+	}
+}
+
+// RestoreUserHandler godoc
+// @Summary 恢复用户
+// @Description 恢复已软删除的用户
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse{data=UserSummary}
+// @Failure 400,403,404,409,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/restore [post]
+// @DeprecatedRouter /users/{id}/restore [post]
+func RestoreUserHandler(app *app.App) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := app.DB.WithContext(c.Request.Context())
+		user, ok := loadUserParam(c, db.Unscoped())
+		if !ok {
+			return
+		}
+		if !user.DeletedAt.Valid {
+			response.Fail(c, response.ErrUserNotDeleted)
+			return
+		}
+
+		before := newUserSummary(user)
+		var after UserSummary
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Model(user).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+			user.DeletedAt = gorm.DeletedAt{}
+			after = newUserSummary(user)
+			return recordAuditTx(c, tx, audit.Entry{Action: "user.restore", Target: userActor(user.ID), Before: before, After: after})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		response.OK(c, after)
+	}
+}
+
+// SetUserRoleHandler godoc
+// @Summary 修改用户角色
+// @Description 角色为 user、operator、admin 或 auditor。修改后用户的所有会话立即失效，重新登录后按新角色签发 token
+// @Tags 用户管理
+// @Accept json
+// @Produce json
+// @Param id path int true "用户ID"
+// @Param request body SetUserRoleRequest true "新角色"
+// @Success 200 {object} response.SuccessResponse{data=UserSummary}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/role [put]
+func SetUserRoleHandler(app *app.App) gin.HandlerFunc {
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		var req SetUserRoleRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+		role, ok := auth.ParseRole(req.Role)
+		if !ok {
+			response.Fail(c, response.ErrUnknownRole.WithDetails(fmt.Sprintf("role must be one of %v", auth.Roles())))
+			return
+		}
+
+		db := app.DB.WithContext(c.Request.Context())
+		user, ok := loadUserParam(c, db)
+		if !ok {
+			return
+		}
+		// 不能修改自己的角色，避免最后一个管理员取消自己的权限
+		if isCurrentUser(c, user.ID) {
+			response.Fail(c, response.ErrCannotModifySelf)
+			return
+		}
+		if user.Role == string(role) {
+			response.OK(c, newUserSummary(user))
+			return
+		}
+
+		// 角色写在 token 中，先注销所有会话，注销失败时不修改角色
+		if err := tokens.RevokeAll(c.Request.Context(), user.ID); err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Errorf("Failed to revoke sessions for user %d", user.ID)
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+
+		before := newUserSummary(user)
+		var after UserSummary
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(user).Update("role", string(role)).Error; err != nil {
+				return err
+			}
+			user.Role = string(role)
+			after = newUserSummary(user)
+			return recordAuditTx(c, tx, audit.Entry{Action: "user.role_change", Target: userActor(user.ID), Before: before, After: after})
+		})
+		if err != nil {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+			return
+		}
+		response.OK(c, after)
+	}
+}
+
+// UnlockUserHandler godoc
+// @Summary 解除登录锁定
+// @Description 清除用户因连续登录失败产生的锁定和失败计数
+// @Tags 用户管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/unlock [post]
+// @DeprecatedRouter /users/{id}/unlock [post]
+func UnlockUserHandler(app *app.App) gin.HandlerFunc {
+	guard := app.LoginGuard
+	recorder := audit.NewRecorder(app.DB)
+	return func(c *gin.Context) {
+		user, ok := loadUserParam(c, app.DB.WithContext(c.Request.Context()))
+		if !ok {
+			return
+		}
+
+		unlocked, err := guard.Unlock(c.Request.Context(), user.Username)
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+
+		if !recordAudit(c, recorder, audit.Entry{
+			Action: "auth.unlock",
+			Target: userActor(user.ID),
+			After:  gin.H{"username": user.Username, "was_locked": unlocked},
+		}) {
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgUserUnlocked, nil)
+	}
+}
+
+// EnsureBootstrapAdmin 在没有可用的管理员时创建 security.bootstrap_admin 配置的用户，
+// 用户已存在时将其提升为管理员，使新部署可以登录并分配其他角色。已有管理员或未配置用户名时不做修改
+func EnsureBootstrapAdmin(ctx context.Context, db *gorm.DB, cfg pkg.BootstrapAdminConfig) error {
+	if cfg.Username == "" {
+		return nil
+	}
+	log := pkg.LoggerFromContext(ctx)
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var admins int64
+		if err := tx.Model(&models.User{}).Where("role = ? AND disabled_at IS NULL", string(auth.RoleAdmin)).Count(&admins).Error; err != nil {
+			return err
+		}
+		if admins > 0 {
+			return nil
+		}
+
+		var user models.User
+		err := tx.Unscoped().Where("username = ?", cfg.Username).First(&user).Error
+		if err == gorm.ErrRecordNotFound {
+			if violations := security.CheckPassword(cfg.Password, cfg.Username); len(violations) > 0 {
+				messages := make([]string, len(violations))
+				for i, v := range violations {
+					messages[i] = v.Message
+				}
+				return fmt.Errorf("security.bootstrap_admin.password: %s", strings.Join(messages, "; "))
+			}
+			hashedPassword, err := security.HashPassword(cfg.Password)
+			if err != nil {
+				return err
+			}
+			user = models.User{Username: cfg.Username, Password: hashedPassword, Role: string(auth.RoleAdmin)}
+			if err := tx.Create(&user).Error; err != nil {
+				// 多个实例同时启动时由其他实例创建
+				if pkg.IsDuplicateKey(tx, err) {
+					return nil
+				}
+				return err
+			}
+			log.Warnf("Created bootstrap admin %q", user.Username)
+			return audit.NewRecorder(tx).Record(ctx, audit.Entry{
+				Actor: audit.ActorSystem, Action: "user.bootstrap_admin", Target: userActor(user.ID), After: newUserSummary(&user),
+			})
+		}
+		if err != nil {
+			return err
+		}
+		if user.DeletedAt.Valid || user.DisabledAt != nil {
+			return fmt.Errorf("bootstrap admin %q is deleted or disabled", user.Username)
+		}
+
+		// 已有用户的密码不变，重新登录后按新角色签发 token
+		before := newUserSummary(&user)
+		if err := tx.Model(&user).Update("role", string(auth.RoleAdmin)).Error; err != nil {
+			return err
+		}
+		user.Role = string(auth.RoleAdmin)
+		log.Warnf("Promoted %q to bootstrap admin", user.Username)
+		return audit.NewRecorder(tx).Record(ctx, audit.Entry{
+			Actor: audit.ActorSystem, Action: "user.bootstrap_admin", Target: userActor(user.ID), Before: before, After: newUserSummary(&user),
+		})
+	})
+}
+
+// loadUserParam 按路径参数 id 加载用户，失败时已写入响应
+func loadUserParam(c *gin.Context, db *gorm.DB) (*models.User, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		response.Fail(c, response.ErrInvalidRequest.WithDetails("Invalid user id"))
+		return nil, false
+	}
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			response.Fail(c, response.ErrUserNotFound)
+		} else {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
+		}
+		return nil, false
+	}
+	return &user, true
+}
+
+func isCurrentUser(c *gin.Context, userID uint) bool {
+	current, ok := c.Get("userID")
+	return ok && current.(uint) == userID
+}
+
+// parsePagination 解析 page 和 page_size 查询参数，失败时已写入响应
+func parsePagination(c *gin.Context) (int, int, bool) {
+	page, pageSize := 1, defaultPageSize
+	var err error
+	if v := c.Query("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("page must be a positive integer"))
+			return 0, 0, false
+		}
+	}
+	if v := c.Query("page_size"); v != "" {
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize < 1 || pageSize > maxPageSize {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("page_size must be between 1 and 100"))
+			return 0, 0, false
+		}
+	}
+	return page, pageSize, true
+}
+
+// escapeLike 转义 LIKE 中的通配符，转义字符为 !，MySQL 和 SQLite 都支持
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -4,23 +4,76 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/ledger"
 	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
 	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
 	"github.com/kakaluote000/demo-api/pkg/security"
 	"gorm.io/gorm"
 )
 
+// RegisterRequest 注册请求，密码的长度和复杂度由密码策略检查
+type RegisterRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=32"`
+	Password string `json:"password" binding:"required,max=128"`
+}
+
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" binding:"required,max=64"`
+	Password string `json:"password" binding:"required,max=128"`
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	// Token 与 AccessToken 相同，为兼容旧客户端保留
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// WalletRequest 创建钱包或直接设置余额
+type WalletRequest struct {
+	UserID      uint `json:"user_id" binding:"required"`
+	CurrencyID  uint `json:"currency_id" binding:"required"`
+	CurrencyNum uint `json:"currency_num" binding:"lte=1000000000000"`
+}
+
+// CurrencyAmountRequest 增加或扣减货币，数量必须大于 0
+type CurrencyAmountRequest struct {
+	UserID      uint `json:"user_id" binding:"required"`
+	CurrencyID  uint `json:"currency_id" binding:"required"`
+	CurrencyNum uint `json:"currency_num" binding:"required,gt=0,lte=1000000000000" minimum:"1"`
+}
+
+// WalletResponse 返回给客户端的钱包信息
+type WalletResponse struct {
+	UserID      uint      `json:"user_id"`
+	CurrencyID  uint      `json:"currency_id"`
+	CurrencyNum uint      `json:"currency_num"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CurrencyBalanceResponse 增减货币后的余额
+type CurrencyBalanceResponse struct {
+	NewCurrencyNum uint `json:"new_currency_num"`
+}
+
+func newWalletResponse(wallet *models.UserCurrency) WalletResponse {
+	return WalletResponse{
+		UserID:      wallet.UserID,
+		CurrencyID:  wallet.CurrencyID,
+		CurrencyNum: wallet.CurrencyNum,
+		UpdatedAt:   wallet.UpdatedAt,
+	}
 }
 
 // RegisterHandler godoc
@@ -29,46 +82,65 @@ type LoginResponse struct {
 // @Tags 用户管理
 // @Accept json
 // @Produce json
-// @Param user body models.User true "用户信息"
+// @Param user body RegisterRequest true "用户信息"
 // @Success 200 {object} response.SuccessResponse
 // @Failure 400 {object} response.ErrorResponse
-// @Router /register [post]
+// @Router /api/v1/auth/register [post]
+// @DeprecatedRouter /register [post]
 func RegisterHandler(app *app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var user models.User
-		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		var req RegisterRequest
+		if !bindJSON(c, &req) {
 			return
 		}
 
-		// 验证密码强度
-		if !security.ValidatePassword(user.Password) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet security requirements"})
+		// 验证密码策略
+		if !checkPasswordPolicy(c, req.Password, req.Username) {
 			return
 		}
 
 		// 加密密码
-		hashedPassword, err := security.HashPassword(user.Password)
+		hashedPassword, err := security.HashPassword(req.Password)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process password"})
+			response.Fail(c, response.ErrInternal.Wrap(err))
 			return
 		}
-		user.Password = hashedPassword
+		// 注册只能创建普通用户，其他角色由管理员分配
+		user := models.User{
+			Username: req.Username,
+			Password: hashedPassword,
+			Role:     string(auth.RoleUser),
+		}
 
-		db := app.DB
-		// 检查用户名是否已经存在
+		db := app.DB.WithContext(c.Request.Context())
+		// 检查用户名是否已经存在。已删除用户的用户名不能重新注册，否则该用户无法恢复
 		var existingUser models.User
-		if err := db.Where("username = ?", user.Username).First(&existingUser).Error; err == nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Username already exists"})
+		if err := db.Unscoped().Where("username = ?", user.Username).First(&existingUser).Error; err == nil {
+			response.Fail(c, response.ErrUsernameTaken)
 			return
 		}
 
-		if err := db.Create(&user).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+			return recordAuditTx(c, tx, audit.Entry{
+				Actor:  userActor(user.ID),
+				Action: "user.register",
+				Target: userActor(user.ID),
+				After:  gin.H{"username": user.Username, "role": user.Role},
+			})
+		})
+		// 并发注册同一用户名时由唯一索引拒绝
+		if pkg.IsDuplicateKey(db, err) {
+			response.Fail(c, response.ErrUsernameTaken)
 			return
 		}
-
-		c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
+		if err != nil {
+			response.Fail(c, response.ErrInternal.Wrap(err))
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgUserRegistered, nil)
 	}
 }
 
@@ -79,351 +151,312 @@ func RegisterHandler(app *app.App) gin.HandlerFunc {
 // @Accept json
 // @Produce json
 // @Param user body LoginRequest true "登录信息"
-// @Success 200 {object} response.SuccessResponse
-// @Failure 400,401 {object} response.ErrorResponse
-// @Router /login [post]
+// @Success 200 {object} response.SuccessResponse{data=LoginResponse} "未启用两步验证时直接返回 token，否则返回 MFAChallengeResponse"
+// @Failure 400,401,429,500 {object} response.ErrorResponse
+// @Router /api/v1/auth/login [post]
+// @DeprecatedRouter /login [post]
 func LoginHandler(app *app.App) gin.HandlerFunc {
+	guard := app.LoginGuard
+	recorder := audit.NewRecorder(app.DB)
+	tokens := auth.NewTokenStore(app.Redis, app.DB)
+
 	return func(c *gin.Context) {
-		db := app.DB
+		db := app.DB.WithContext(c.Request.Context())
 		var loginReq LoginRequest
-		if err := c.ShouldBindJSON(&loginReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !bindJSON(c, &loginReq) {
+			return
+		}
+
+		// 处于锁定或延迟期内的请求直接拒绝，不校验密码
+		wait, err := guard.Check(c.Request.Context(), loginReq.Username, c.ClientIP())
+		if err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Error("Failed to check login lockout")
+			response.Fail(c, response.ErrServiceUnavailable.Wrap(err))
+			return
+		}
+		if wait > 0 {
+			metrics.LoginAttempts.WithLabelValues("throttled").Inc()
+			rejectThrottledLogin(c, wait)
 			return
 		}
 
 		var user models.User
-		if err := db.Where("username = ?", loginReq.Username).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
+		err = db.Where("username = ?", loginReq.Username).First(&user).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			response.Fail(c, response.ErrDatabase.Wrap(err))
 			return
 		}
 
-		// 验证密码
+		// 验证密码，用户不存在时同样计算一次哈希，避免通过响应时间判断账号是否存在
+		if err == gorm.ErrRecordNotFound {
+			security.CheckPasswordHash(loginReq.Password, dummyPasswordHash())
+			recordLoginFailure(c, guard, recorder, loginReq.Username)
+			return
+		}
 		if !security.CheckPasswordHash(loginReq.Password, user.Password) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			recordLoginFailure(c, guard, recorder, loginReq.Username)
+			return
+		}
+		// 哈希算法或参数已过时的，用明文密码按当前配置重新计算
+		if security.NeedsRehash(user.Password) {
+			rehashPassword(c, db, &user, loginReq.Password)
+		}
+
+		if user.DisabledAt != nil {
+			response.Fail(c, response.ErrAccountDisabled)
 			return
 		}
 
-		// 生成 JWT token
-		token, err := auth.GenerateToken(user.ID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		role, ok := auth.ParseRole(user.Role)
+		if !ok {
+			pkg.LoggerFromContext(c.Request.Context()).Errorf("User %d has unknown role %q", user.ID, user.Role)
+			response.Fail(c, response.ErrPermissionDenied)
 			return
 		}
 
-		// 将 token 存入 Redis，用于后续验证
-		tokenKey := fmt.Sprintf("user_token:%d", user.ID)
-		err = app.Redis.Set(app.Ctx, tokenKey, token, 24*time.Hour).Err()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save token"})
+		// 启用了两步验证或角色要求两步验证时先返回短期凭证，验证通过后再签发 token
+		if challenge := mfaChallengeType(&user, role); challenge != "" {
+			respondMFAChallenge(c, tokens, &user, role, challenge)
 			return
 		}
 
-		c.JSON(http.StatusOK, LoginResponse{
-			Token: token,
-		})
+		pair, ok := issueLoginTokens(c, guard, tokens, &user, role)
+		if !ok {
+			return
+		}
+		response.OK(c, newLoginResponse(pair))
+	}
+}
+
+// rehashPassword 升级密码哈希，失败不影响本次登录。
+// 只在密码仍是校验时的旧哈希时更新，不会覆盖同时发生的修改或重置
+func rehashPassword(c *gin.Context, db *gorm.DB, user *models.User, password string) {
+	hashedPassword, err := security.HashPassword(password)
+	if err == nil {
+		err = db.Model(&models.User{}).Where("id = ? AND password = ?", user.ID, user.Password).Update("password", hashedPassword).Error
+	}
+	if err != nil {
+		pkg.LoggerFromContext(c.Request.Context()).WithError(err).Warnf("Failed to upgrade password hash for user %d", user.ID)
+	}
+}
+
+// issueLoginTokens 登录全部验证通过后清除失败计数并签发 token，失败时已写入响应
+func issueLoginTokens(c *gin.Context, guard *auth.LoginGuard, tokens *auth.TokenStore, user *models.User, role auth.Role) (*auth.TokenPair, bool) {
+	if err := guard.RecordSuccess(c.Request.Context(), user.Username); err != nil {
+		pkg.LoggerFromContext(c.Request.Context()).WithError(err).Warn("Failed to reset login failures")
+	}
+	metrics.LoginAttempts.WithLabelValues("success").Inc()
+
+	// 签发 access token 和 refresh token，会话状态保存在 Redis
+	pair, err := tokens.IssueTokens(c.Request.Context(), user.ID, role)
+	if err != nil {
+		response.Fail(c, response.ErrInternal.Wrap(err))
+		return nil, false
+	}
+	return pair, true
+}
+
+func newLoginResponse(tokens *auth.TokenPair) LoginResponse {
+	return LoginResponse{
+		Token:        tokens.AccessToken,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
 	}
 }
 
 // AddUserCurrencyHandler godoc
 // @Summary 添加用户货币
-// @Description 为用户添加新的货币类型
+// @Description 为用户添加新的货币类型。已废弃，请使用 POST /api/v1/users/{id}/wallets
 // @Tags 货币管理
 // @Accept json
 // @Produce json
-// @Param userCurrency body models.UserCurrency true "用户货币信息"
+// @Param userCurrency body WalletRequest true "用户货币信息"
 // @Success 200 {object} response.SuccessResponse
-// @Failure 400,404,500 {object} response.ErrorResponse
+// @Failure 400,404,409,500 {object} response.ErrorResponse
 // @Security Bearer
+// @Deprecated
 // @Router /userCurrency [post]
 func AddUserCurrencyHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
 	return func(c *gin.Context) {
-		db := app.DB
-		rdb := app.Redis
-		var userCurrency models.UserCurrency
-		if err := c.ShouldBindJSON(&userCurrency); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		var req WalletRequest
+		if !bindJSON(c, &req) {
 			return
 		}
 
-		// 检查用户是否存在
-		var user models.User
-		if err := db.Where("id = ?", userCurrency.UserID).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
-			return
-		}
-
-		// 创建用户货币记录
-		if err := db.Create(&userCurrency).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add user currency"})
+		if _, err := svc.CreateWallet(c.Request.Context(), callerFromContext(c), req.UserID, req.CurrencyID, req.CurrencyNum); err != nil {
+			response.Fail(c, err)
 			return
 		}
-
-		// 清除缓存
-		cacheKey := fmt.Sprintf("user_currency:%d", userCurrency.UserID)
-		rdb.Del(app.Ctx, cacheKey)
-
-		c.JSON(http.StatusOK, gin.H{"message": "User currency added successfully"})
+		response.Success(c, http.StatusOK, response.MsgWalletCreated, nil)
 	}
 }
 
 // GetUserCurrencyHandler godoc
 // @Summary 获取用户货币
-// @Description 获取指定用户的货币信息
+// @Description 获取指定用户的货币信息。已废弃，请使用 GET /api/v1/users/{id}/wallets
 // @Tags 货币管理
 // @Accept json
 // @Produce json
 // @Param id path int true "用户ID"
-// @Success 200 {object} models.UserCurrency
+// @Success 200 {object} response.SuccessResponse{data=WalletResponse}
 // @Failure 404,500 {object} response.ErrorResponse
 // @Security Bearer
+// @Deprecated
 // @Router /userCurrency/{id} [get]
 func GetUserCurrencyHandler(app *app.App) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		db := app.DB
+		db := app.DB.WithContext(c.Request.Context())
 		rdb := app.Redis
 		id := c.Param("id")
-		cacheKey := fmt.Sprintf("user_currency:%s", id)
+		userID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			response.Fail(c, response.ErrInvalidRequest.WithDetails("Invalid user id"))
+			return
+		}
+		// 普通用户只能查看自己的钱包
+		if !authorizeUser(c, uint(userID), auth.PermWalletRead, auth.PermWalletReadAll) {
+			return
+		}
+		cacheKey := fmt.Sprintf("user_currency:%d", userID)
 
 		// 尝试从缓存中获取数据
-		cachedData, err := rdb.Get(app.Ctx, cacheKey).Result()
+		cachedData, err := rdb.Get(c.Request.Context(), cacheKey).Result()
 		if err == nil {
-			var userCurrency models.UserCurrency
-			if err := json.Unmarshal([]byte(cachedData), &userCurrency); err == nil {
-				c.JSON(http.StatusOK, userCurrency)
+			var wallet WalletResponse
+			if err := json.Unmarshal([]byte(cachedData), &wallet); err == nil {
+				response.OK(c, wallet)
 				return
 			}
 		}
 
 		// 如果缓存中没有数据，则从数据库中获取
 		var userCurrency models.UserCurrency
-		if err := db.Where("user_id = ?", id).First(&userCurrency).Error; err != nil {
+		if err := db.Where("user_id = ?", userID).First(&userCurrency).Error; err != nil {
 			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User currency not found"})
+				response.Fail(c, response.ErrWalletNotFound)
 			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				response.Fail(c, response.ErrDatabase.Wrap(err))
 			}
 			return
 		}
 
 		// 将数据存入缓存
-		data, err := json.Marshal(userCurrency)
+		wallet := newWalletResponse(&userCurrency)
+		data, err := json.Marshal(wallet)
 		if err == nil {
-			rdb.Set(app.Ctx, cacheKey, data, time.Hour) // 缓存有效期为1小时
+			rdb.Set(c.Request.Context(), cacheKey, data, time.Hour) // 缓存有效期为1小时
 		}
 
-		c.JSON(http.StatusOK, userCurrency)
+		response.OK(c, wallet)
 	}
 }
 
 // UpdateUserCurrencyHandler godoc
 // @Summary 更新用户货币
-// @Description 更新用户的货币数量
+// @Description 更新用户的货币数量。已废弃，请使用 PUT /api/v1/users/{id}/wallets/{currency}
 // @Tags 货币管理
 // @Accept json
 // @Produce json
-// @Param userCurrency body models.UserCurrency true "用户货币信息"
+// @Param userCurrency body WalletRequest true "用户货币信息"
 // @Success 200 {object} response.SuccessResponse
 // @Failure 400,404,500 {object} response.ErrorResponse
 // @Security Bearer
-// @Router /userCurrency [put]
+// @Deprecated
+// @Router /updateUserCurrency [post]
 func UpdateUserCurrencyHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
 	return func(c *gin.Context) {
-		db := app.DB
-		rdb := app.Redis
-		var userCurrency models.UserCurrency
-		if err := c.ShouldBindJSON(&userCurrency); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		// 检查用户是否存在
-		var user models.User
-		if err := db.Where("id = ?", userCurrency.UserID).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
+		var req WalletRequest
+		if !bindJSON(c, &req) {
 			return
 		}
 
-		// 更新用户货币记录
-		if err := db.Model(&models.UserCurrency{}).Where("user_id = ? AND currency_id = ?", userCurrency.UserID, userCurrency.CurrencyID).Updates(models.UserCurrency{
-			CurrencyNum: userCurrency.CurrencyNum,
-		}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user currency"})
+		if _, err := svc.SetBalance(c.Request.Context(), callerFromContext(c), req.UserID, req.CurrencyID, req.CurrencyNum); err != nil {
+			response.Fail(c, err)
 			return
 		}
-
-		// 清除缓存
-		cacheKey := fmt.Sprintf("user_currency:%d", userCurrency.UserID)
-		rdb.Del(app.Ctx, cacheKey)
-
-		c.JSON(http.StatusOK, gin.H{"message": "User currency updated successfully"})
+		response.Success(c, http.StatusOK, response.MsgWalletUpdated, nil)
 	}
 }
 
 // AddCurrencyNumHandler godoc
 // @Summary 增加货币数量
-// @Description 增加用户的货币数量
+// @Description 增加用户的货币数量。已废弃，请使用 POST /api/v1/transactions
 // @Tags 货币管理
 // @Accept json
 // @Produce json
-// @Param userCurrency body models.UserCurrency true "用户货币信息"
-// @Success 200 {object} response.SuccessResponse
+// @Param userCurrency body CurrencyAmountRequest true "用户货币信息"
+// @Success 200 {object} response.SuccessResponse{data=CurrencyBalanceResponse}
 // @Failure 400,404,500 {object} response.ErrorResponse
 // @Security Bearer
+// @Deprecated
 // @Router /addCurrencyNum [post]
 func AddCurrencyNumHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
 	return func(c *gin.Context) {
-		db := app.DB
-		rdb := app.Redis
-		// 从上下文中获取解析后的userCurrency
-		val, ok := c.Get("userCurrency")
-		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userCurrency"})
-			return
-		}
-
-		userCurrency := val.(models.UserCurrency)
-
-		// 检查用户是否存在
-		var user models.User
-		if err := db.Where("id = ?", userCurrency.UserID).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
-			return
-		}
-
-		// 检查用户货币记录是否存在
-		var existingUserCurrency models.UserCurrency
-		if err := db.Where("user_id = ? AND currency_id = ?", userCurrency.UserID, userCurrency.CurrencyID).First(&existingUserCurrency).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User currency not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
-			return
-		}
-
-		// 增加货币数量
-		newCurrencyNum := existingUserCurrency.CurrencyNum + userCurrency.CurrencyNum
-		if err := db.Model(&models.UserCurrency{}).Where("user_id = ? AND currency_id = ?", userCurrency.UserID, userCurrency.CurrencyID).Updates(models.UserCurrency{
-			CurrencyNum: newCurrencyNum,
-		}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user currency"})
+		var userCurrency CurrencyAmountRequest
+		if !bindJSON(c, &userCurrency) {
 			return
 		}
 
-		// 记录流水日志
-		transaction := models.CurrencyTransaction{
-			UserID:          userCurrency.UserID,
-			CurrencyID:      userCurrency.CurrencyID,
-			Amount:          userCurrency.CurrencyNum,
-			Type:            "add",
-			TransactionTime: time.Now(),
-		}
-		if err := db.Create(&transaction).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log transaction"})
+		change, err := svc.Apply(c.Request.Context(), callerFromContext(c), ledger.Transaction{
+			UserID:     userCurrency.UserID,
+			CurrencyID: userCurrency.CurrencyID,
+			Type:       TransactionCredit,
+			Amount:     userCurrency.CurrencyNum,
+		})
+		if err != nil {
+			response.Fail(c, err)
 			return
 		}
-
-		// 清除缓存
-		cacheKey := fmt.Sprintf("user_currency:%d", userCurrency.UserID)
-		rdb.Del(app.Ctx, cacheKey)
-
-		c.JSON(http.StatusOK, gin.H{"message": "User currency added successfully", "new_currency_num": newCurrencyNum})
+		response.Success(c, http.StatusOK, response.MsgCurrencyAdded, CurrencyBalanceResponse{NewCurrencyNum: change.After})
 	}
 }
 
 // SubtractCurrencyNumHandler godoc
 // @Summary 减少货币数量
-// @Description 减少用户的货币数量
+// @Description 减少用户的货币数量。已废弃，请使用 POST /api/v1/transactions
 // @Tags 货币管理
 // @Accept json
 // @Produce json
-// @Param userCurrency body models.UserCurrency true "用户货币信息"
-// @Success 200 {object} response.SuccessResponse
+// @Param userCurrency body CurrencyAmountRequest true "用户货币信息"
+// @Success 200 {object} response.SuccessResponse{data=CurrencyBalanceResponse}
 // @Failure 400,404,500 {object} response.ErrorResponse
 // @Security Bearer
+// @Deprecated
 // @Router /subtractCurrencyNum [post]
 func SubtractCurrencyNumHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
 	return func(c *gin.Context) {
-		db := app.DB
-		rdb := app.Redis
-		// 从上下文中获取解析后的userCurrency
-		val, ok := c.Get("userCurrency")
-		if !ok {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userCurrency"})
-			return
-		}
-
-		userCurrency := val.(models.UserCurrency)
-
-		// 检查用户是否存在
-		var user models.User
-		if err := db.Where("id = ?", userCurrency.UserID).First(&user).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
-			return
-		}
-
-		// 检查用户货币记录是否存在
-		var existingUserCurrency models.UserCurrency
-		if err := db.Where("user_id = ? AND currency_id = ?", userCurrency.UserID, userCurrency.CurrencyID).First(&existingUserCurrency).Error; err != nil {
-			if err == gorm.ErrRecordNotFound {
-				c.JSON(http.StatusNotFound, gin.H{"error": "User currency not found"})
-			} else {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-			}
+		var userCurrency CurrencyAmountRequest
+		if !bindJSON(c, &userCurrency) {
 			return
 		}
 
-		// 检查是否会导致负数
-		if existingUserCurrency.CurrencyNum < userCurrency.CurrencyNum {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient currency"})
-			return
-		}
-
-		// 扣减货币数量
-		newCurrencyNum := existingUserCurrency.CurrencyNum - userCurrency.CurrencyNum
-		if err := db.Model(&models.UserCurrency{}).Where("user_id = ? AND currency_id = ?", userCurrency.UserID, userCurrency.CurrencyID).Updates(models.UserCurrency{
-			CurrencyNum: newCurrencyNum}).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user currency"})
-			return
-		}
-
-		// 记录流水日志
-		transaction := models.CurrencyTransaction{
-			UserID:          userCurrency.UserID,
-			CurrencyID:      userCurrency.CurrencyID,
-			Amount:          userCurrency.CurrencyNum,
-			Type:            "subtract",
-			TransactionTime: time.Now(),
-		}
-		if err := db.Create(&transaction).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log transaction"})
+		change, err := svc.Apply(c.Request.Context(), callerFromContext(c), ledger.Transaction{
+			UserID:     userCurrency.UserID,
+			CurrencyID: userCurrency.CurrencyID,
+			Type:       TransactionDebit,
+			Amount:     userCurrency.CurrencyNum,
+		})
+		if err != nil {
+			response.Fail(c, err)
 			return
 		}
+		response.Success(c, http.StatusOK, response.MsgCurrencySubtracted, CurrencyBalanceResponse{NewCurrencyNum: change.After})
+	}
+}
 
-		// 清除缓存
-		cacheKey := fmt.Sprintf("user_currency:%d", userCurrency.UserID)
-		rdb.Del(app.Ctx, cacheKey)
-
-		c.JSON(http.StatusOK, gin.H{"message": "User currency subtracted successfully", "new_currency_num": newCurrencyNum})
+// authorizeUser 检查调用方能否操作 userID 的资源，不能时返回 403
+func authorizeUser(c *gin.Context, userID uint, ownPerm, anyPerm auth.Permission) bool {
+	principal, ok := c.Get("principal")
+	if ok && principal.(*auth.Principal).CanAccessUser(userID, ownPerm, anyPerm) {
+		return true
 	}
+	pkg.LoggerFromContext(c.Request.Context()).Warnf("Access to resources of user %d denied", userID)
+	response.Fail(c, response.ErrPermissionDenied)
+	return false
 }
@@ -0,0 +1,471 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/ledger"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+const (
+	TransactionCredit = ledger.TypeCredit
+	TransactionDebit  = ledger.TypeDebit
+)
+
+const (
+	defaultHeartbeat = 15 * time.Second
+	// 建议 EventSource 断线后的重连间隔
+	sseRetry = 3 * time.Second
+)
+
+// CreateWalletRequest 为用户创建指定币种的钱包
+type CreateWalletRequest struct {
+	CurrencyID  uint `json:"currency_id" binding:"required"`
+	CurrencyNum uint `json:"currency_num" binding:"lte=1000000000000"`
+}
+
+// SetBalanceRequest 直接设置钱包余额
+type SetBalanceRequest struct {
+	CurrencyNum uint `json:"currency_num" binding:"lte=1000000000000"`
+}
+
+// CreateTransactionRequest 增加或扣减一个钱包的余额
+type CreateTransactionRequest struct {
+	UserID     uint   `json:"user_id" binding:"required"`
+	CurrencyID uint   `json:"currency_id" binding:"required"`
+	Type       string `json:"type" binding:"required,oneof=credit debit"`
+	Amount     uint   `json:"amount" binding:"required,gt=0,lte=1000000000000" minimum:"1"`
+}
+
+// TransferRequest 从一个用户的钱包转账到另一个用户的同币种钱包
+type TransferRequest struct {
+	FromUserID uint `json:"from_user_id" binding:"required"`
+	ToUserID   uint `json:"to_user_id" binding:"required,nefield=FromUserID"`
+	CurrencyID uint `json:"currency_id" binding:"required"`
+	Amount     uint `json:"amount" binding:"required,gt=0,lte=1000000000000" minimum:"1"`
+}
+
+type TransactionResponse struct {
+	ID         uint      `json:"id"`
+	UserID     uint      `json:"user_id"`
+	CurrencyID uint      `json:"currency_id"`
+	Type       string    `json:"type"`
+	Amount     uint      `json:"amount"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// TransactionResultResponse 交易结果，Balance 为交易后的余额
+type TransactionResultResponse struct {
+	TransactionResponse
+	Balance uint `json:"balance"`
+}
+
+type TransactionListResponse struct {
+	Transactions []TransactionResponse `json:"transactions"`
+	Total        int64                 `json:"total"`
+	Page         int                   `json:"page"`
+	PageSize     int                   `json:"page_size"`
+}
+
+// TransferResponse 转账结果，Balance 为转出方转账后的余额
+type TransferResponse struct {
+	FromUserID uint `json:"from_user_id"`
+	ToUserID   uint `json:"to_user_id"`
+	CurrencyID uint `json:"currency_id"`
+	Amount     uint `json:"amount"`
+	Balance    uint `json:"balance"`
+}
+
+func newTransactionResponse(record *models.CurrencyTransaction) TransactionResponse {
+	return TransactionResponse{
+		ID:         record.ID,
+		UserID:     record.UserID,
+		CurrencyID: record.CurrencyID,
+		Type:       ledger.RecordType(record),
+		Amount:     record.Amount,
+		CreatedAt:  record.TransactionTime,
+	}
+}
+
+// ListWalletsHandler godoc
+// @Summary 用户钱包列表
+// @Description 列出用户所有币种的钱包，普通用户只能查看自己的钱包
+// @Tags 货币管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Success 200 {object} response.SuccessResponse{data=[]WalletResponse}
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/wallets [get]
+func ListWalletsHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+
+		wallets, err := svc.ListWallets(c.Request.Context(), callerFromContext(c), userID)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		resp := make([]WalletResponse, 0, len(wallets))
+		for i := range wallets {
+			resp = append(resp, newWalletResponse(&wallets[i]))
+		}
+		response.OK(c, resp)
+	}
+}
+
+// GetWalletHandler godoc
+// @Summary 获取钱包
+// @Description 获取用户指定币种的钱包，普通用户只能查看自己的钱包
+// @Tags 货币管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Param currency path int true "币种ID"
+// @Success 200 {object} response.SuccessResponse{data=WalletResponse}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/wallets/{currency} [get]
+func GetWalletHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		currencyID, ok := uintParam(c, "currency")
+		if !ok {
+			return
+		}
+
+		wallet, err := svc.GetWallet(c.Request.Context(), callerFromContext(c), userID, currencyID)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		response.OK(c, newWalletResponse(wallet))
+	}
+}
+
+// CreateWalletHandler godoc
+// @Summary 创建钱包
+// @Description 为用户创建指定币种的钱包，每个币种只能有一个钱包
+// @Tags 货币管理
+// @Accept json
+// @Produce json
+// @Param id path int true "用户ID"
+// @Param body body CreateWalletRequest true "币种和初始余额"
+// @Success 201 {object} response.SuccessResponse{data=WalletResponse}
+// @Failure 400,403,404,409,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/wallets [post]
+func CreateWalletHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		var req CreateWalletRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		wallet, err := svc.CreateWallet(c.Request.Context(), callerFromContext(c), userID, req.CurrencyID, req.CurrencyNum)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		response.Success(c, http.StatusCreated, response.MsgWalletCreated, newWalletResponse(wallet))
+	}
+}
+
+// SetWalletBalanceHandler godoc
+// @Summary 设置余额
+// @Description 直接设置钱包余额，不产生流水，仅限运营和管理员
+// @Tags 货币管理
+// @Accept json
+// @Produce json
+// @Param id path int true "用户ID"
+// @Param currency path int true "币种ID"
+// @Param body body SetBalanceRequest true "新的余额"
+// @Success 200 {object} response.SuccessResponse{data=WalletResponse}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/wallets/{currency} [put]
+func SetWalletBalanceHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		currencyID, ok := uintParam(c, "currency")
+		if !ok {
+			return
+		}
+		var req SetBalanceRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		wallet, err := svc.SetBalance(c.Request.Context(), callerFromContext(c), userID, currencyID, req.CurrencyNum)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		response.Success(c, http.StatusOK, response.MsgWalletUpdated, newWalletResponse(wallet))
+	}
+}
+
+// ListTransactionsHandler godoc
+// @Summary 交易流水
+// @Description 按时间倒序分页列出用户的交易流水，普通用户只能查看自己的流水
+// @Tags 货币管理
+// @Produce json
+// @Param id path int true "用户ID"
+// @Param currency_id query int false "币种ID"
+// @Param page query int false "页码，从 1 开始"
+// @Param page_size query int false "每页数量，最大 100"
+// @Success 200 {object} response.SuccessResponse{data=TransactionListResponse}
+// @Failure 400,403,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/transactions [get]
+func ListTransactionsHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		page, pageSize, ok := parsePagination(c)
+		if !ok {
+			return
+		}
+		query := ledger.TransactionQuery{UserID: userID, Page: page, PageSize: pageSize}
+		if v := c.Query("currency_id"); v != "" {
+			currencyID, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				response.Fail(c, response.ErrInvalidRequest.WithDetails("Invalid currency_id"))
+				return
+			}
+			query.CurrencyID = uint(currencyID)
+		}
+
+		result, err := svc.ListTransactions(c.Request.Context(), callerFromContext(c), query)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		resp := TransactionListResponse{Transactions: make([]TransactionResponse, len(result.Records)), Total: result.Total, Page: result.Page, PageSize: result.PageSize}
+		for i := range result.Records {
+			resp.Transactions[i] = newTransactionResponse(&result.Records[i])
+		}
+		response.OK(c, resp)
+	}
+}
+
+// CreateTransactionHandler godoc
+// @Summary 增减余额
+// @Description credit 增加余额，需要 wallet:credit 权限；debit 扣减余额，普通用户只能从自己的钱包扣减
+// @Tags 货币管理
+// @Accept json
+// @Produce json
+// @Param body body CreateTransactionRequest true "交易信息"
+// @Success 201 {object} response.SuccessResponse{data=TransactionResultResponse}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/transactions [post]
+func CreateTransactionHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		var req CreateTransactionRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		change, err := svc.Apply(c.Request.Context(), callerFromContext(c), ledger.Transaction{
+			UserID:     req.UserID,
+			CurrencyID: req.CurrencyID,
+			Type:       req.Type,
+			Amount:     req.Amount,
+		})
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		response.Success(c, http.StatusCreated, response.MsgSuccess, TransactionResultResponse{
+			TransactionResponse: newTransactionResponse(&change.Record),
+			Balance:             change.After,
+		})
+	}
+}
+
+// CreateTransferHandler godoc
+// @Summary 转账
+// @Description 从一个用户的钱包转账到另一个用户的同币种钱包，两边的余额在同一个数据库事务中变更。普通用户只能从自己的钱包转出
+// @Tags 货币管理
+// @Accept json
+// @Produce json
+// @Param body body TransferRequest true "转账信息"
+// @Success 201 {object} response.SuccessResponse{data=TransferResponse}
+// @Failure 400,403,404,500 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/transfers [post]
+func CreateTransferHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		var req TransferRequest
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		result, err := svc.Transfer(c.Request.Context(), callerFromContext(c), ledger.Transfer{
+			FromUserID: req.FromUserID,
+			ToUserID:   req.ToUserID,
+			CurrencyID: req.CurrencyID,
+			Amount:     req.Amount,
+		})
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		response.Success(c, http.StatusCreated, response.MsgSuccess, TransferResponse{
+			FromUserID: req.FromUserID,
+			ToUserID:   req.ToUserID,
+			CurrencyID: req.CurrencyID,
+			Amount:     req.Amount,
+			Balance:    result.Debit.After,
+		})
+	}
+}
+
+// StreamBalanceEventsHandler godoc
+// @Summary 余额变更推送
+// @Description 以 Server-Sent Events 推送用户钱包的余额变更，普通用户只能订阅自己的钱包，代替轮询钱包接口。
+// @Description 余额变更的事件名为 balance，data 为 BalanceEvent；另外按配置的间隔发送 heartbeat 事件。
+// @Description 断线重连时通过 Last-Event-ID 请求头或 last_event_id 参数补发之后的事件，无法恢复时先收到 reset 事件，需要重新查询余额。
+// @Description 服务端结束响应后客户端应带上最后收到的事件 ID 重连
+// @Tags 货币管理
+// @Produce json,text/event-stream
+// @Param id path int true "用户ID"
+// @Param Last-Event-ID header string false "最后收到的事件 ID"
+// @Param last_event_id query string false "最后收到的事件 ID，首次连接无法设置请求头时使用"
+// @Success 200 {string} string "事件流"
+// @Failure 400,401,403,503 {object} response.ErrorResponse
+// @Security Bearer
+// @Router /api/v1/users/{id}/balance-events [get]
+func StreamBalanceEventsHandler(app *app.App) gin.HandlerFunc {
+	svc := ledger.NewService(app)
+	return func(c *gin.Context) {
+		userID, ok := uintParam(c, "id")
+		if !ok {
+			return
+		}
+		lastEventID := c.GetHeader("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("last_event_id")
+		}
+
+		ctx := c.Request.Context()
+		sub, err := svc.Subscribe(ctx, callerFromContext(c), userID, lastEventID)
+		if err != nil {
+			response.Fail(c, err)
+			return
+		}
+		defer sub.Close()
+
+		subscribers := metrics.BalanceSubscribers.WithLabelValues("sse")
+		subscribers.Inc()
+		defer subscribers.Dec()
+
+		interval := pkg.AppConfig.Server.Events.Heartbeat
+		if interval <= 0 {
+			interval = defaultHeartbeat
+		}
+		heartbeat := time.NewTicker(interval)
+		defer heartbeat.Stop()
+
+		header := c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		// 关闭 nginx 等反向代理的响应缓冲
+		header.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry.Milliseconds())
+		c.Writer.Flush()
+
+		for {
+			var err error
+			select {
+			case event, ok := <-sub.Events():
+				if !ok {
+					// 客户端跟不上或 Redis 重新连接，客户端重连后从历史恢复
+					return
+				}
+				name := "balance"
+				if event.Type == ledger.EventReset {
+					name = ledger.EventReset
+				}
+				err = writeEvent(c, event.ID, name, event)
+			case now := <-heartbeat.C:
+				err = writeEvent(c, "", "heartbeat", gin.H{"time": now})
+			case <-ctx.Done():
+				return
+			case <-app.Draining():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent 写出一个 SSE 事件并立即发送，data 编码为单行 JSON
+func writeEvent(c *gin.Context, id, name string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", id)
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// callerFromContext 由认证中间件设置的调用方构造 ledger.Caller
+func callerFromContext(c *gin.Context) ledger.Caller {
+	caller := ledger.Caller{RequestID: c.GetString("requestID"), IP: c.ClientIP()}
+	if principal, ok := c.Get("principal"); ok {
+		caller.Principal = principal.(*auth.Principal)
+	}
+	return caller
+}
+
+// uintParam 解析路径中的正整数参数，失败时已写入响应
+func uintParam(c *gin.Context, name string) (uint, bool) {
+	v, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || v == 0 {
+		response.Fail(c, response.ErrInvalidRequest.WithDetails("Invalid "+name))
+		return 0, false
+	}
+	return uint(v), true
+}
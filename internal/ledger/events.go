@@ -0,0 +1,426 @@
+package ledger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+// 余额变更类型，直接设置余额和创建钱包为 set。
+// reset 表示无法从客户端给出的事件 ID 恢复（历史已过期或 ID 无效），客户端需要重新查询余额
+const (
+	EventCredit = TypeCredit
+	EventDebit  = TypeDebit
+	EventSet    = "set"
+	EventReset  = "reset"
+)
+
+const (
+	defaultHistorySize = 1000
+	defaultHistoryTTL  = 24 * time.Hour
+	// 每个订阅缓冲的实时事件数，客户端跟不上时断开订阅，由客户端重连后从历史恢复
+	subscriptionBuffer   = 64
+	balanceChannelPrefix = "balance_events:"
+)
+
+// BalanceEvent 钱包余额变更事件，通过 Redis 发布给所有实例上的订阅者
+type BalanceEvent struct {
+	// ID 事件在该用户历史中的 ID，按发生顺序递增，断线重连时用于恢复
+	ID         string `json:"id,omitempty"`
+	UserID     uint   `json:"user_id"`
+	CurrencyID uint   `json:"currency_id"`
+	Type       string `json:"type"`
+	// 本次变更的数量，set 时为新的余额
+	Amount  uint `json:"amount"`
+	Balance uint `json:"balance"`
+	// 对应的流水 ID，set 时为 0
+	TransactionID uint      `json:"transaction_id,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+func changeEvent(change *Change) BalanceEvent {
+	return BalanceEvent{
+		UserID:        change.Record.UserID,
+		CurrencyID:    change.Record.CurrencyID,
+		Type:          RecordType(&change.Record),
+		Amount:        change.Record.Amount,
+		Balance:       change.After,
+		TransactionID: change.Record.ID,
+		Time:          change.Record.TransactionTime,
+	}
+}
+
+func balanceChannel(userID uint) string {
+	return fmt.Sprintf("%s%d", balanceChannelPrefix, userID)
+}
+
+// historyKey 用户最近的事件，Redis stream 的条目 ID 即事件 ID
+func historyKey(userID uint) string {
+	return fmt.Sprintf("balance_history:%d", userID)
+}
+
+func historyLimits() (int64, time.Duration) {
+	cfg := pkg.AppConfig.Server.Events
+	size, ttl := cfg.HistorySize, cfg.HistoryTTL
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	if ttl <= 0 {
+		ttl = defaultHistoryTTL
+	}
+	return size, ttl
+}
+
+// publishScript 在同一个脚本中写入历史并发布，各实例收到事件的顺序与事件 ID 一致，
+// 订阅者可以按 ID 去重。消息格式为 "<事件 ID> <JSON>"
+var publishScript = redis.NewScript(`
+local id = redis.call("XADD", KEYS[1], "MAXLEN", ARGV[1], "*", "event", ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+redis.call("PUBLISH", KEYS[2], id .. " " .. ARGV[2])
+return id
+`)
+
+// publish 发布余额变更事件。交易已经提交，请求取消后仍然发布，发布失败只记录日志
+func (s *Service) publish(ctx context.Context, event BalanceEvent) {
+	size, ttl := historyLimits()
+	data, err := json.Marshal(event)
+	if err == nil {
+		keys := []string{historyKey(event.UserID), balanceChannel(event.UserID)}
+		err = publishScript.Run(context.WithoutCancel(ctx), s.redis, keys, size, data, ttl.Milliseconds()).Err()
+	}
+	if err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Warnf("Failed to publish balance change of user %d", event.UserID)
+	}
+}
+
+// PublishBalance 发布钱包余额被直接设置的事件，供不经过 Service 修改余额的旧接口使用
+func (s *Service) PublishBalance(ctx context.Context, wallet *models.UserCurrency) {
+	s.publish(ctx, BalanceEvent{
+		UserID:     wallet.UserID,
+		CurrencyID: wallet.CurrencyID,
+		Type:       EventSet,
+		Amount:     wallet.CurrencyNum,
+		Balance:    wallet.CurrencyNum,
+		Time:       wallet.UpdatedAt,
+	})
+}
+
+func decodeMessage(payload string) (BalanceEvent, error) {
+	var event BalanceEvent
+	id, data, ok := strings.Cut(payload, " ")
+	if !ok {
+		return event, errors.New("missing event id")
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return event, err
+	}
+	event.ID = id
+	return event, nil
+}
+
+// eventID Redis stream ID，格式为 <毫秒时间戳>-<序号>
+type eventID struct {
+	ms, seq uint64
+}
+
+func parseEventID(s string) (eventID, bool) {
+	ms, seq, ok := strings.Cut(s, "-")
+	if !ok {
+		return eventID{}, false
+	}
+	var id eventID
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return eventID{}, false
+	}
+	if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return eventID{}, false
+	}
+	return id, true
+}
+
+func (id eventID) after(other eventID) bool {
+	return id.ms > other.ms || id.ms == other.ms && id.seq > other.seq
+}
+
+// hub 每个应用实例只用一个 Redis 连接按模式订阅所有用户的余额事件，再分发给本实例上的订阅，
+// Redis 连接数与订阅数无关
+type hub struct {
+	redis *redis.Client
+
+	mu     sync.Mutex
+	pubsub *redis.PubSub
+	subs   map[uint]map[*Subscription]struct{}
+	closed bool
+}
+
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[*app.App]*hub)
+)
+
+// hubFor 返回应用的 hub，App.Ctx 取消时关闭其中所有订阅
+func hubFor(app *app.App) *hub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	if h, ok := hubs[app]; ok {
+		return h
+	}
+
+	h := &hub{redis: app.Redis, subs: make(map[uint]map[*Subscription]struct{})}
+	hubs[app] = h
+	if app.Ctx != nil {
+		go func() {
+			<-app.Ctx.Done()
+			hubsMu.Lock()
+			delete(hubs, app)
+			hubsMu.Unlock()
+			h.close()
+		}()
+	}
+	return h
+}
+
+// add 注册订阅，第一个订阅时建立 Redis 订阅。返回时 Redis 订阅已经生效
+func (h *hub) add(ctx context.Context, sub *Subscription) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return response.ErrServiceUnavailable
+	}
+
+	if h.pubsub == nil {
+		// Redis 订阅在多个请求间共用，不随当前请求取消
+		pubsub := h.redis.PSubscribe(context.WithoutCancel(ctx), balanceChannelPrefix+"*")
+		if _, err := pubsub.Receive(ctx); err != nil {
+			pubsub.Close()
+			return response.ErrServiceUnavailable.Wrap(err)
+		}
+		h.pubsub = pubsub
+		go h.run(pubsub)
+	}
+
+	if h.subs[sub.userID] == nil {
+		h.subs[sub.userID] = make(map[*Subscription]struct{})
+	}
+	h.subs[sub.userID][sub] = struct{}{}
+	return nil
+}
+
+func (h *hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeLocked(sub)
+}
+
+// removeLocked 注销订阅并关闭其实时事件 channel，订阅已注销时不做任何事
+func (h *hub) removeLocked(sub *Subscription) bool {
+	subs := h.subs[sub.userID]
+	if _, ok := subs[sub]; !ok {
+		return false
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.userID)
+	}
+	close(sub.live)
+	return true
+}
+
+// dropAll 断开所有订阅，客户端重连后从历史恢复
+func (h *hub) dropAll(reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for sub := range subs {
+			if h.removeLocked(sub) {
+				metrics.BalanceSubscriptionsDropped.WithLabelValues(reason).Inc()
+			}
+		}
+	}
+}
+
+func (h *hub) dispatch(event BalanceEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs[event.UserID] {
+		select {
+		case sub.live <- event:
+		default:
+			h.removeLocked(sub)
+			metrics.BalanceSubscriptionsDropped.WithLabelValues("lagged").Inc()
+		}
+	}
+}
+
+func (h *hub) run(pubsub *redis.PubSub) {
+	for msg := range pubsub.ChannelWithSubscriptions(context.Background(), 100) {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			// 连接断开后 go-redis 会自动重新订阅，期间发布的事件已经丢失
+			if msg.Kind == "psubscribe" {
+				pkg.Log.Warn("Balance event subscription reconnected, dropping local subscribers")
+				h.dropAll("resubscribed")
+			}
+		case *redis.Message:
+			event, err := decodeMessage(msg.Payload)
+			if err != nil {
+				pkg.Log.WithError(err).Warnf("Ignoring malformed balance event on %s", msg.Channel)
+				continue
+			}
+			h.dispatch(event)
+		}
+	}
+}
+
+func (h *hub) close() {
+	h.mu.Lock()
+	h.closed = true
+	pubsub := h.pubsub
+	h.pubsub = nil
+	h.mu.Unlock()
+
+	if pubsub != nil {
+		pubsub.Close()
+	}
+	h.dropAll("shutdown")
+}
+
+// Subscription 一个用户的余额变更订阅，不再使用时需要 Close
+type Subscription struct {
+	hub    *hub
+	userID uint
+	// hub 写入的实时事件，客户端跟不上、Redis 重连或应用关闭时被关闭
+	live   chan BalanceEvent
+	events chan BalanceEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Subscribe 订阅用户钱包的余额变更，普通用户只能订阅自己的钱包。返回时订阅已经生效。
+// lastEventID 不为空时先补发历史中该事件之后的事件，历史中找不到该事件时先发送 reset 事件
+func (s *Service) Subscribe(ctx context.Context, caller Caller, userID uint, lastEventID string) (*Subscription, error) {
+	if err := validate(required("user_id", userID)); err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(ctx, caller, userID, auth.PermWalletRead, auth.PermWalletReadAll); err != nil {
+		return nil, err
+	}
+
+	sub := &Subscription{
+		hub:    s.events,
+		userID: userID,
+		live:   make(chan BalanceEvent, subscriptionBuffer),
+		events: make(chan BalanceEvent),
+		done:   make(chan struct{}),
+	}
+	// 先开始接收实时事件再读取历史，不会漏掉两者之间发布的事件，重复的部分按事件 ID 去掉
+	if err := s.events.add(ctx, sub); err != nil {
+		return nil, err
+	}
+	var backlog []BalanceEvent
+	if lastEventID != "" {
+		var err error
+		if backlog, err = s.history(ctx, userID, lastEventID); err != nil {
+			sub.Close()
+			return nil, err
+		}
+	}
+	go sub.run(backlog)
+	return sub, nil
+}
+
+// history 返回历史中 lastEventID 之后的事件。lastEventID 已不在历史中时无法确定丢失了哪些事件，只返回 reset 事件
+func (s *Service) history(ctx context.Context, userID uint, lastEventID string) ([]BalanceEvent, error) {
+	reset := []BalanceEvent{{UserID: userID, Type: EventReset, Time: time.Now()}}
+	if _, ok := parseEventID(lastEventID); !ok {
+		return reset, nil
+	}
+
+	entries, err := s.redis.XRange(ctx, historyKey(userID), lastEventID, "+").Result()
+	if err != nil {
+		return nil, response.ErrServiceUnavailable.Wrap(err)
+	}
+	if len(entries) == 0 || entries[0].ID != lastEventID {
+		return reset, nil
+	}
+
+	events := make([]BalanceEvent, 0, len(entries)-1)
+	for _, entry := range entries[1:] {
+		data, _ := entry.Values["event"].(string)
+		var event BalanceEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			pkg.LoggerFromContext(ctx).WithError(err).Warnf("Ignoring malformed balance event %s of user %d", entry.ID, userID)
+			continue
+		}
+		event.ID = entry.ID
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Events 返回事件 channel。订阅被关闭后 channel 被关闭，客户端应使用最后收到的事件 ID 重新订阅
+func (sub *Subscription) Events() <-chan BalanceEvent {
+	return sub.events
+}
+
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		close(sub.done)
+		sub.hub.remove(sub)
+	})
+}
+
+// run 先发送补发的事件，再转发实时事件，跳过补发时已经发送过的事件
+func (sub *Subscription) run(backlog []BalanceEvent) {
+	defer close(sub.events)
+
+	var cursor eventID
+	for _, event := range backlog {
+		if !sub.send(event) {
+			return
+		}
+		if id, ok := parseEventID(event.ID); ok {
+			cursor = id
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.live:
+			if !ok {
+				return
+			}
+			if id, ok := parseEventID(event.ID); ok && !id.after(cursor) {
+				continue
+			}
+			if !sub.send(event) {
+				return
+			}
+		case <-sub.done:
+			return
+		}
+	}
+}
+
+func (sub *Subscription) send(event BalanceEvent) bool {
+	select {
+	case sub.events <- event:
+		return true
+	case <-sub.done:
+		return false
+	}
+}
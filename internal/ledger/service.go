@@ -0,0 +1,469 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TypeCredit = "credit"
+	TypeDebit  = "debit"
+)
+
+const (
+	// MaxAmount 单笔交易和余额设置的上限，与 REST 请求体的校验一致
+	MaxAmount       = 1000000000000
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// 流水表中的类型沿用 add 和 subtract
+var recordTypes = map[string]string{
+	TypeCredit: "add",
+	TypeDebit:  "subtract",
+}
+
+// RecordType 返回流水对应的交易类型
+func RecordType(record *models.CurrencyTransaction) string {
+	if record.Type == recordTypes[TypeDebit] {
+		return TypeDebit
+	}
+	return TypeCredit
+}
+
+// Caller 发起操作的调用方，用于授权和审计
+type Caller struct {
+	Principal *auth.Principal
+	RequestID string
+	IP        string
+}
+
+// Transaction 增加或扣减一个钱包的余额
+type Transaction struct {
+	UserID     uint
+	CurrencyID uint
+	Type       string
+	Amount     uint
+}
+
+// Transfer 从一个用户的钱包转账到另一个用户的同币种钱包
+type Transfer struct {
+	FromUserID uint
+	ToUserID   uint
+	CurrencyID uint
+	Amount     uint
+}
+
+// TransactionQuery 分页查询流水，CurrencyID 为 0 时不按币种过滤，Page 和 PageSize 为 0 时使用默认值
+type TransactionQuery struct {
+	UserID     uint
+	CurrencyID uint
+	Page       int
+	PageSize   int
+}
+
+// Change 一次余额变更前后的余额和对应的流水
+type Change struct {
+	Before uint
+	After  uint
+	Record models.CurrencyTransaction
+}
+
+// TransferResult 转账双方的余额变更
+type TransferResult struct {
+	Debit  *Change
+	Credit *Change
+}
+
+// TransactionPage 一页流水
+type TransactionPage struct {
+	Records  []models.CurrencyTransaction
+	Total    int64
+	Page     int
+	PageSize int
+}
+
+// Service 钱包和交易的业务逻辑，REST handler 和 gRPC 服务共用，权限检查也在这里进行。
+// 返回的错误为 *response.Error
+type Service struct {
+	db     *gorm.DB
+	redis  *redis.Client
+	events *hub
+}
+
+func NewService(app *app.App) *Service {
+	return &Service{db: app.DB, redis: app.Redis, events: hubFor(app)}
+}
+
+// ListWallets 列出用户所有币种的钱包，普通用户只能查看自己的钱包
+func (s *Service) ListWallets(ctx context.Context, caller Caller, userID uint) ([]models.UserCurrency, error) {
+	if err := validate(required("user_id", userID)); err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(ctx, caller, userID, auth.PermWalletRead, auth.PermWalletReadAll); err != nil {
+		return nil, err
+	}
+	var wallets []models.UserCurrency
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("currency_id").Find(&wallets).Error; err != nil {
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	return wallets, nil
+}
+
+// GetWallet 获取用户指定币种的钱包，普通用户只能查看自己的钱包
+func (s *Service) GetWallet(ctx context.Context, caller Caller, userID, currencyID uint) (*models.UserCurrency, error) {
+	if err := validate(required("user_id", userID), required("currency_id", currencyID)); err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(ctx, caller, userID, auth.PermWalletRead, auth.PermWalletReadAll); err != nil {
+		return nil, err
+	}
+	return findWallet(s.db.WithContext(ctx), userID, currencyID)
+}
+
+// CreateWallet 为用户创建指定币种的钱包，每个币种只能有一个钱包
+func (s *Service) CreateWallet(ctx context.Context, caller Caller, userID, currencyID, amount uint) (*models.UserCurrency, error) {
+	if err := validate(required("user_id", userID), required("currency_id", currencyID), checkAmount("currency_num", amount, false)); err != nil {
+		return nil, err
+	}
+	if err := can(caller, auth.PermWalletManage); err != nil {
+		return nil, err
+	}
+
+	db := s.db.WithContext(ctx)
+	if err := userExists(db, userID); err != nil {
+		return nil, err
+	}
+
+	// 由唯一索引保证每个币种只有一个钱包，并发创建时只有一个成功
+	wallet := models.UserCurrency{UserID: userID, CurrencyID: currencyID, CurrencyNum: amount}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&wallet).Error; err != nil {
+			if pkg.IsDuplicateKey(tx, err) {
+				return response.ErrWalletExists
+			}
+			return response.ErrDatabase.Wrap(err)
+		}
+		return s.audit(ctx, tx, caller, audit.Entry{
+			Action: "wallet.create",
+			Target: userActor(userID),
+			After:  walletState(currencyID, amount),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateCache(ctx, userID)
+	metrics.RecordCurrencyOperation("create", currencyID, amount)
+	s.publish(ctx, BalanceEvent{UserID: userID, CurrencyID: currencyID, Type: EventSet, Amount: amount, Balance: amount, Time: wallet.CreatedAt})
+	return &wallet, nil
+}
+
+// SetBalance 直接设置钱包余额，不产生流水
+func (s *Service) SetBalance(ctx context.Context, caller Caller, userID, currencyID, amount uint) (*models.UserCurrency, error) {
+	if err := validate(required("user_id", userID), required("currency_id", currencyID), checkAmount("currency_num", amount, false)); err != nil {
+		return nil, err
+	}
+	if err := can(caller, auth.PermWalletManage); err != nil {
+		return nil, err
+	}
+
+	var wallet *models.UserCurrency
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 锁定钱包直到事务结束，审计记录的修改前余额不会被并发的增减覆盖
+		var err error
+		if wallet, err = findWallet(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID, currencyID); err != nil {
+			return err
+		}
+		before := wallet.CurrencyNum
+		if err := tx.Model(wallet).Update("currency_num", amount).Error; err != nil {
+			return response.ErrDatabase.Wrap(err)
+		}
+		return s.audit(ctx, tx, caller, audit.Entry{
+			Action: "wallet.update",
+			Target: userActor(userID),
+			Before: walletState(currencyID, before),
+			After:  walletState(currencyID, amount),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.invalidateCache(ctx, userID)
+	metrics.RecordCurrencyOperation("update", currencyID, amount)
+	s.publish(ctx, BalanceEvent{UserID: userID, CurrencyID: currencyID, Type: EventSet, Amount: amount, Balance: amount, Time: wallet.UpdatedAt})
+	return wallet, nil
+}
+
+// Apply 增加或扣减余额。credit 需要 wallet:credit 权限，debit 时普通用户只能从自己的钱包扣减
+func (s *Service) Apply(ctx context.Context, caller Caller, t Transaction) (*Change, error) {
+	if err := validate(
+		required("user_id", t.UserID),
+		required("currency_id", t.CurrencyID),
+		oneOf("type", t.Type, TypeCredit, TypeDebit),
+		checkAmount("amount", t.Amount, true),
+	); err != nil {
+		return nil, err
+	}
+	if t.Type == TypeCredit {
+		if err := can(caller, auth.PermWalletCredit); err != nil {
+			return nil, err
+		}
+	} else if err := authorizeUser(ctx, caller, t.UserID, auth.PermWalletSpend, auth.PermWalletDebit); err != nil {
+		return nil, err
+	}
+
+	db := s.db.WithContext(ctx)
+	if err := userExists(db, t.UserID); err != nil {
+		return nil, err
+	}
+
+	var change *Change
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if change, err = changeBalance(tx, t.UserID, t.CurrencyID, t.Amount, t.Type); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, caller, audit.Entry{
+			Action: "wallet." + t.Type,
+			Target: userActor(t.UserID),
+			Before: walletState(t.CurrencyID, change.Before),
+			After:  walletState(t.CurrencyID, change.After),
+		})
+	})
+	if err != nil {
+		if errors.Is(err, response.ErrInsufficientFunds) {
+			metrics.InsufficientFunds.WithLabelValues(metrics.CurrencyLabel(t.CurrencyID)).Inc()
+		}
+		return nil, err
+	}
+
+	s.invalidateCache(ctx, t.UserID)
+	metrics.RecordCurrencyOperation(recordTypes[t.Type], t.CurrencyID, t.Amount)
+	s.publish(ctx, changeEvent(change))
+	return change, nil
+}
+
+// Transfer 转账，两边的余额在同一个数据库事务中变更。普通用户只能从自己的钱包转出
+func (s *Service) Transfer(ctx context.Context, caller Caller, t Transfer) (*TransferResult, error) {
+	if err := validate(
+		required("from_user_id", t.FromUserID),
+		required("to_user_id", t.ToUserID),
+		required("currency_id", t.CurrencyID),
+		checkAmount("amount", t.Amount, true),
+		notEqual("to_user_id", t.ToUserID, t.FromUserID, "FromUserID"),
+	); err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(ctx, caller, t.FromUserID, auth.PermWalletSpend, auth.PermWalletDebit); err != nil {
+		return nil, err
+	}
+
+	db := s.db.WithContext(ctx)
+	if err := userExists(db, t.FromUserID); err != nil {
+		return nil, err
+	}
+	if err := userExists(db, t.ToUserID); err != nil {
+		return nil, err
+	}
+
+	// 按用户 ID 顺序更新两个钱包，避免方向相反的转账互相死锁
+	var result TransferResult
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if t.FromUserID < t.ToUserID {
+			if result.Debit, err = changeBalance(tx, t.FromUserID, t.CurrencyID, t.Amount, TypeDebit); err != nil {
+				return err
+			}
+			if result.Credit, err = changeBalance(tx, t.ToUserID, t.CurrencyID, t.Amount, TypeCredit); err != nil {
+				return err
+			}
+		} else {
+			if result.Credit, err = changeBalance(tx, t.ToUserID, t.CurrencyID, t.Amount, TypeCredit); err != nil {
+				return err
+			}
+			if result.Debit, err = changeBalance(tx, t.FromUserID, t.CurrencyID, t.Amount, TypeDebit); err != nil {
+				return err
+			}
+		}
+		return s.audit(ctx, tx, caller, audit.Entry{
+			Action: "wallet.transfer",
+			Target: userActor(t.FromUserID),
+			Before: map[string]interface{}{"from": walletState(t.CurrencyID, result.Debit.Before), "to": walletState(t.CurrencyID, result.Credit.Before)},
+			After:  map[string]interface{}{"from": walletState(t.CurrencyID, result.Debit.After), "to": walletState(t.CurrencyID, result.Credit.After), "to_user": userActor(t.ToUserID)},
+		})
+	})
+	if err != nil {
+		if errors.Is(err, response.ErrInsufficientFunds) {
+			metrics.InsufficientFunds.WithLabelValues(metrics.CurrencyLabel(t.CurrencyID)).Inc()
+		}
+		return nil, err
+	}
+
+	s.invalidateCache(ctx, t.FromUserID)
+	s.invalidateCache(ctx, t.ToUserID)
+	metrics.RecordCurrencyOperation("transfer", t.CurrencyID, t.Amount)
+	s.publish(ctx, changeEvent(result.Debit))
+	s.publish(ctx, changeEvent(result.Credit))
+	return &result, nil
+}
+
+// ListTransactions 按时间倒序分页列出用户的交易流水，普通用户只能查看自己的流水
+func (s *Service) ListTransactions(ctx context.Context, caller Caller, q TransactionQuery) (*TransactionPage, error) {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PageSize == 0 {
+		q.PageSize = DefaultPageSize
+	}
+	if err := validate(required("user_id", q.UserID), checkPageSize("page_size", q.PageSize)); err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(ctx, caller, q.UserID, auth.PermWalletRead, auth.PermWalletReadAll); err != nil {
+		return nil, err
+	}
+
+	query := s.db.WithContext(ctx).Model(&models.CurrencyTransaction{}).Where("user_id = ?", q.UserID)
+	if q.CurrencyID != 0 {
+		query = query.Where("currency_id = ?", q.CurrencyID)
+	}
+	page := &TransactionPage{Page: q.Page, PageSize: q.PageSize}
+	if err := query.Count(&page.Total).Error; err != nil {
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	if err := query.Order("id DESC").Offset((q.Page - 1) * q.PageSize).Limit(q.PageSize).Find(&page.Records).Error; err != nil {
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	return page, nil
+}
+
+// changeBalance 在 tx 中原子地增减余额并写入流水，不依赖分布式锁。
+// 钱包不存在返回 ErrWalletNotFound，扣减时余额不足返回 ErrInsufficientFunds
+func changeBalance(tx *gorm.DB, userID, currencyID, amount uint, txType string) (*Change, error) {
+	query := tx.Model(&models.UserCurrency{}).Where("user_id = ? AND currency_id = ?", userID, currencyID)
+	if txType == TypeDebit {
+		query = query.Where("currency_num >= ?", amount).Update("currency_num", gorm.Expr("currency_num - ?", amount))
+	} else {
+		query = query.Update("currency_num", gorm.Expr("currency_num + ?", amount))
+	}
+	if query.Error != nil {
+		return nil, response.ErrDatabase.Wrap(query.Error)
+	}
+
+	var wallet models.UserCurrency
+	if err := tx.Where("user_id = ? AND currency_id = ?", userID, currencyID).First(&wallet).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ErrWalletNotFound.WithDetails(map[string]uint{"user_id": userID, "currency_id": currencyID})
+		}
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	if query.RowsAffected == 0 {
+		return nil, response.ErrInsufficientFunds
+	}
+
+	change := &Change{
+		After: wallet.CurrencyNum,
+		Record: models.CurrencyTransaction{
+			UserID:          userID,
+			CurrencyID:      currencyID,
+			Amount:          amount,
+			Type:            recordTypes[txType],
+			TransactionTime: time.Now(),
+		},
+	}
+	if txType == TypeDebit {
+		change.Before = change.After + amount
+	} else {
+		change.Before = change.After - amount
+	}
+	if err := tx.Create(&change.Record).Error; err != nil {
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	return change, nil
+}
+
+func findWallet(db *gorm.DB, userID, currencyID uint) (*models.UserCurrency, error) {
+	var wallet models.UserCurrency
+	if err := db.Where("user_id = ? AND currency_id = ?", userID, currencyID).First(&wallet).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, response.ErrWalletNotFound
+		}
+		return nil, response.ErrDatabase.Wrap(err)
+	}
+	return &wallet, nil
+}
+
+func userExists(db *gorm.DB, userID uint) error {
+	var count int64
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return response.ErrDatabase.Wrap(err)
+	}
+	if count == 0 {
+		return response.ErrUserNotFound.WithDetails(map[string]uint{"user_id": userID})
+	}
+	return nil
+}
+
+// invalidateCache 清除 GET /userCurrency/:id 使用的缓存
+func (s *Service) invalidateCache(ctx context.Context, userID uint) {
+	s.redis.Del(ctx, fmt.Sprintf("user_currency:%d", userID))
+}
+
+// audit 补全操作者、请求 ID 和来源 IP 后在状态变更的事务中写入审计日志，写入失败时整个事务回滚
+func (s *Service) audit(ctx context.Context, tx *gorm.DB, caller Caller, entry audit.Entry) error {
+	entry.Actor = caller.Principal.String()
+	entry.RequestID = caller.RequestID
+	entry.IP = caller.IP
+	if err := audit.NewRecorder(tx).Record(ctx, entry); err != nil {
+		pkg.LoggerFromContext(ctx).WithError(err).Errorf("Failed to write audit log for %s", entry.Action)
+		return response.ErrDatabase.Wrap(err)
+	}
+	return nil
+}
+
+// can 检查调用方是否拥有权限
+func can(caller Caller, perm auth.Permission) error {
+	if caller.Principal == nil {
+		return response.ErrUnauthorized
+	}
+	if !caller.Principal.Can(perm) {
+		return response.ErrPermissionDenied
+	}
+	return nil
+}
+
+// authorizeUser 检查调用方能否操作 userID 的资源：本人需要 ownPerm，操作他人需要 anyPerm
+func authorizeUser(ctx context.Context, caller Caller, userID uint, ownPerm, anyPerm auth.Permission) error {
+	if caller.Principal == nil {
+		return response.ErrUnauthorized
+	}
+	if !caller.Principal.CanAccessUser(userID, ownPerm, anyPerm) {
+		pkg.LoggerFromContext(ctx).Warnf("Access to resources of user %d denied", userID)
+		return response.ErrPermissionDenied
+	}
+	return nil
+}
+
+func userActor(userID uint) string {
+	return fmt.Sprintf("user:%d", userID)
+}
+
+// walletState 审计日志中记录的钱包余额
+func walletState(currencyID, currencyNum uint) map[string]interface{} {
+	return map[string]interface{}{"currency_id": currencyID, "currency_num": currencyNum}
+}
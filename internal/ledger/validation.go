@@ -0,0 +1,71 @@
+package ledger
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+// 参数校验与 REST 请求体的 binding 标签一致，gRPC 等不经过 gin 绑定的调用方同样受约束。
+// 规则名沿用 binding 标签的写法，每个检查函数通过时返回 nil
+
+func validate(checks ...*response.FieldError) error {
+	var fields []response.FieldError
+	for _, check := range checks {
+		if check != nil {
+			fields = append(fields, *check)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return response.ErrValidationFailed.WithDetails(fields)
+}
+
+func required(field string, v uint) *response.FieldError {
+	if v == 0 {
+		return &response.FieldError{Field: field, Rule: "required"}
+	}
+	return nil
+}
+
+func oneOf(field, v string, values ...string) *response.FieldError {
+	for _, value := range values {
+		if v == value {
+			return nil
+		}
+	}
+	if v == "" {
+		return &response.FieldError{Field: field, Rule: "required"}
+	}
+	return &response.FieldError{Field: field, Rule: "oneof", Param: strings.Join(values, " ")}
+}
+
+// checkAmount 检查数量不超过 MaxAmount，positive 为 true 时还要求大于 0
+func checkAmount(field string, v uint, positive bool) *response.FieldError {
+	if positive && v == 0 {
+		return &response.FieldError{Field: field, Rule: "required"}
+	}
+	if v > MaxAmount {
+		return &response.FieldError{Field: field, Rule: "lte", Param: strconv.Itoa(MaxAmount)}
+	}
+	return nil
+}
+
+func notEqual(field string, v, other uint, otherField string) *response.FieldError {
+	if v != 0 && v == other {
+		return &response.FieldError{Field: field, Rule: "nefield", Param: otherField}
+	}
+	return nil
+}
+
+func checkPageSize(field string, v int) *response.FieldError {
+	if v < 1 {
+		return &response.FieldError{Field: field, Rule: "min", Param: "1"}
+	}
+	if v > MaxPageSize {
+		return &response.FieldError{Field: field, Rule: "max", Param: strconv.Itoa(MaxPageSize)}
+	}
+	return nil
+}
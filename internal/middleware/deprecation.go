@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+)
+
+// DeprecationMiddleware 为已废弃的路由添加 Deprecation 和 Sunset（RFC 8594）响应头。
+// successors 以 "METHOD 路由模板" 为 key，值为替代路由的模板，如 "/api/v1/users/:id/wallets"，
+// 其中的路径参数用当前请求的参数填充后通过 Link 头返回
+func DeprecationMiddleware(sunset time.Time, successors map[string]string) gin.HandlerFunc {
+	sunsetHeader := sunset.UTC().Format(http.TimeFormat)
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", sunsetHeader)
+		if successor, ok := successors[c.Request.Method+" "+route]; ok {
+			if link, ok := expandRoute(successor, c.Params); ok {
+				c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", link))
+			}
+		}
+		metrics.DeprecatedRequests.WithLabelValues(c.Request.Method, route).Inc()
+		c.Next()
+	}
+}
+
+// expandRoute 用请求参数替换路由模板中的 :name，有参数无法替换时返回 false
+func expandRoute(route string, params gin.Params) (string, bool) {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") {
+			continue
+		}
+		value, ok := params.Get(segment[1:])
+		if !ok {
+			return "", false
+		}
+		segments[i] = value
+	}
+	return strings.Join(segments, "/"), true
+}
@@ -1,173 +1,282 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redsync/redsync/v4"
 	"github.com/kakaluote000/demo-api/cmd/app"
-	"github.com/kakaluote000/demo-api/internal/models"
 	"github.com/kakaluote000/demo-api/pkg"
 	"github.com/kakaluote000/demo-api/pkg/auth"
 	"github.com/kakaluote000/demo-api/pkg/metrics"
-	"golang.org/x/time/rate"
-	"gorm.io/gorm"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var log = pkg.Log
 
+// RequestIDHeader 请求 ID 的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDMiddleware 透传客户端提供的请求 ID，没有或不合法时生成新的 ID，并在响应中回显
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !ValidRequestID(requestID) {
+			requestID = NewRequestID()
+		}
+
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("http.request_id", requestID))
+
+		c.Next()
+	}
+}
+
+// ValidRequestID 检查客户端提供的请求 ID，只允许不超过 128 个字符的可见 ASCII 字符
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID 生成随机的请求 ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// LoggerMiddleware 输出结构化访问日志，并为每个请求创建携带请求 ID、路由和用户 ID 的日志
 func LoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
+		method := c.Request.Method
+		route := c.FullPath()
+
+		fields := logrus.Fields{
+			"request_id": c.GetString("requestID"),
+			"method":     method,
+			"route":      route,
+		}
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			fields["trace_id"] = sc.TraceID().String()
+			fields["span_id"] = sc.SpanID().String()
+		}
+		setRequestLogger(c, logrus.NewEntry(log).WithFields(fields))
 
 		c.Next()
 
 		duration := time.Since(start)
 		statusCode := c.Writer.Status()
-		method := c.Request.Method
 		path := c.Request.URL.Path
 
+		// 使用路由模板作为标签，避免 /userCurrency/:id 等路径参数产生大量时间序列
+		routeLabel := route
+		if routeLabel == "" {
+			routeLabel = "unmatched"
+		}
+
 		// 记录请求计数
-		metrics.RequestCounter.WithLabelValues(method, path, fmt.Sprintf("%d", statusCode)).Inc()
+		metrics.RequestCounter.WithLabelValues(method, routeLabel, fmt.Sprintf("%d", statusCode)).Inc()
 
 		// 记录请求持续时间
-		metrics.RequestDuration.WithLabelValues(method, path).Observe(duration.Seconds())
+		metrics.RequestDuration.WithLabelValues(method, routeLabel).Observe(duration.Seconds())
+
+		fields = logrus.Fields{
+			"path":       path,
+			"status":     statusCode,
+			"latency_ms": float64(duration.Microseconds()) / 1000,
+			"client_ip":  c.ClientIP(),
+			"bytes":      c.Writer.Size(),
+			"user_agent": c.Request.UserAgent(),
+		}
+		if len(c.Errors) > 0 {
+			fields["errors"] = c.Errors.String()
+		}
 
-		// 原有的日志记录保持不变
-		log.Printf("LoggerMiddleware 请求日志信息 %s %s %s %d %s",
-			c.ClientIP(), method, path, statusCode, duration)
+		level := logrus.InfoLevel
+		switch {
+		case statusCode >= http.StatusInternalServerError:
+			level = logrus.ErrorLevel
+		case statusCode >= http.StatusBadRequest:
+			level = logrus.WarnLevel
+		}
+		pkg.LoggerFromContext(c.Request.Context()).WithFields(fields).Log(level, "HTTP request")
 	}
 }
 
-// 更新认证中间件
-func AuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.GetHeader("Authorization")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
-			c.Abort()
-			return
-		}
+// setRequestLogger 将请求级日志写入请求的 context，后续处理器通过 pkg.LoggerFromContext 获取
+func setRequestLogger(c *gin.Context, entry *logrus.Entry) {
+	c.Request = c.Request.WithContext(pkg.ContextWithLogger(c.Request.Context(), entry))
+}
 
-		// 移除 Bearer 前缀
-		if len(token) > 7 && token[:7] == "Bearer " {
-			token = token[7:]
-		}
+// APIKeyHeader 服务间调用携带 API key 的请求头，也可使用 "Authorization: ApiKey <key>"
+const APIKeyHeader = "X-API-Key"
 
-		claims, err := auth.ParseToken(token)
+// AuthMiddleware 接受 JWT access token 或 API key，认证通过后将 *auth.Principal 存入上下文。
+// JWT 还会检查 Redis 中的吊销状态。
+func AuthMiddleware(app *app.App) gin.HandlerFunc {
+	authenticator := auth.NewAuthenticator(app.Redis, app.DB)
+	return func(c *gin.Context) {
+		principal, err := authenticator.Authenticate(c.Request.Context(), auth.Credentials{
+			Authorization: c.GetHeader("Authorization"),
+			APIKey:        c.GetHeader(APIKeyHeader),
+			ClientIP:      c.ClientIP(),
+		})
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			if errors.Is(err, auth.ErrAPIKeyIPNotAllowed) {
+				pkg.LoggerFromContext(c.Request.Context()).Warnf("API key used from disallowed ip %s", c.ClientIP())
+			}
+			response.Abort(c, err)
 			return
 		}
 
 		// 将用户信息存储在上下文中
-		c.Set("userID", claims.UserID)
+		if principal.Claims != nil {
+			c.Set("userID", principal.Claims.UserID)
+			c.Set("claims", principal.Claims)
+		}
+		setPrincipal(c, principal)
 		c.Next()
 	}
 }
 
-// 添加限流中间件
-func RateLimitMiddleware() gin.HandlerFunc {
-	limiter := rate.NewLimiter(rate.Every(time.Second), 100) // 每秒100个请求
-	return func(c *gin.Context) {
-		if !limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
-			c.Abort()
-			return
-		}
-		c.Next()
+func setPrincipal(c *gin.Context, principal *auth.Principal) {
+	c.Set("principal", principal)
+	entry := pkg.LoggerFromContext(c.Request.Context()).WithField("principal", principal.String())
+	if principal.Type == auth.PrincipalUser {
+		entry = entry.WithField("user_id", principal.UserID)
 	}
+	setRequestLogger(c, entry)
 }
 
-func CORSMiddleware() gin.HandlerFunc {
+// RequirePermission 要求调用方拥有全部指定权限，需放在 AuthMiddleware 之后
+func RequirePermission(perms ...auth.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 设置允许跨域的源，这里使用 * 表示允许所有源
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		// 设置允许的请求方法
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		// 设置允许的请求头
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
-		// 设置允许携带凭证（如 cookies）
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// 处理预检请求 (OPTIONS 请求)
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
+		val, ok := c.Get("principal")
+		if !ok {
+			response.Abort(c, response.ErrUnauthorized)
 			return
 		}
 
+		principal := val.(*auth.Principal)
+		for _, perm := range perms {
+			if !principal.Can(perm) {
+				pkg.LoggerFromContext(c.Request.Context()).Warnf("%s lacks permission %s", principal, perm)
+				response.Abort(c, response.ErrPermissionDenied)
+				return
+			}
+		}
 		c.Next()
 	}
 }
 
-// 事务中间件
-func TransactionMiddleware(db *gorm.DB) gin.HandlerFunc {
+// RequireAnyPermission 要求调用方至少拥有其中一个权限，用于本人和他人资源共用的路由
+func RequireAnyPermission(perms ...auth.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tx := db.Begin()
-		if tx.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
-			c.Abort()
+		val, ok := c.Get("principal")
+		if !ok {
+			response.Abort(c, response.ErrUnauthorized)
 			return
 		}
 
-		// 将事务存储在上下文中
-		c.Set("tx", tx)
-
-		c.Next()
-
-		if c.Writer.Status() >= http.StatusBadRequest {
-			tx.Rollback()
-		} else {
-			if err := tx.Commit().Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
-				c.Abort()
+		principal := val.(*auth.Principal)
+		for _, perm := range perms {
+			if principal.Can(perm) {
+				c.Next()
 				return
 			}
 		}
+		pkg.LoggerFromContext(c.Request.Context()).Warnf("%s lacks any of permissions %v", principal, perms)
+		response.Abort(c, response.ErrPermissionDenied)
 	}
 }
 
-// 分布式锁中间件
-func DistributedLockMiddleware(app *app.App, lockNamePrefix string, expiry time.Duration) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var userCurrency models.UserCurrency
-		if err := c.ShouldBindJSON(&userCurrency); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			c.Abort()
-			return
+type corsPolicy struct {
+	allowAll         bool
+	allowCredentials bool
+	allowedOrigins   map[string]bool
+	methods          string
+	headers          string
+}
+
+func newCORSPolicy(cfg pkg.CORSConfig) *corsPolicy {
+	policy := &corsPolicy{
+		allowCredentials: cfg.AllowCredentials,
+		allowedOrigins:   make(map[string]bool, len(cfg.AllowedOrigins)),
+		methods:          strings.Join(cfg.AllowedMethods, ", "),
+		headers:          strings.Join(cfg.AllowedHeaders, ", "),
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			policy.allowAll = true
 		}
+		policy.allowedOrigins[origin] = true
+	}
+	return policy
+}
 
-		// 将解析后的数据存储在上下文中
-		c.Set("userCurrency", userCurrency)
+// 跨域中间件，允许的源随配置热更新
+func CORSMiddleware(cfg pkg.CORSConfig) gin.HandlerFunc {
+	var current atomic.Pointer[corsPolicy]
+	current.Store(newCORSPolicy(cfg))
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		current.Store(newCORSPolicy(next.Security.CORS))
+	})
 
-		// 生成动态锁键
-		lockName := fmt.Sprintf("%s:%d", lockNamePrefix, userCurrency.UserID)
-		mutex, acquired := acquireLock(app.RS, lockName, expiry)
-		if !acquired {
-			c.JSON(http.StatusConflict, gin.H{"error": "Resource is locked"})
-			c.Abort()
+	return func(c *gin.Context) {
+		policy := current.Load()
+		origin := c.GetHeader("Origin")
+		if origin != "" {
+			switch {
+			case policy.allowedOrigins[origin]:
+				// 回显具体的源，凭证只对明确列出的源开放
+				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+				c.Writer.Header().Add("Vary", "Origin")
+				if policy.allowCredentials {
+					c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			case policy.allowAll:
+				// 通配符不能与凭证同时使用
+				c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			default:
+				if c.Request.Method == "OPTIONS" {
+					c.AbortWithStatus(http.StatusForbidden)
+					return
+				}
+				c.Next()
+				return
+			}
+			// 设置允许的请求方法
+			c.Writer.Header().Set("Access-Control-Allow-Methods", policy.methods)
+			// 设置允许的请求头
+			c.Writer.Header().Set("Access-Control-Allow-Headers", policy.headers)
+		}
+
+		// 处理预检请求 (OPTIONS 请求)
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
-		defer releaseLock(mutex)
 
 		c.Next()
 	}
 }
-
-func acquireLock(rs *redsync.Redsync, name string, expiry time.Duration) (*redsync.Mutex, bool) {
-	mutex := rs.NewMutex(name, redsync.WithExpiry(expiry))
-	if err := mutex.Lock(); err != nil {
-		log.Errorf("Failed to acquire lock: %v", err)
-		return nil, false
-	}
-	return mutex, true
-}
-
-func releaseLock(mutex *redsync.Mutex) {
-	if _, err := mutex.Unlock(); err != nil {
-		log.Errorf("Failed to release lock: %v", err)
-	}
-}
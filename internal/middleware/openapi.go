@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+func init() {
+	// 审计日志导出的 NDJSON 按文件校验，只检查 Content-Type
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.FileBodyDecoder)
+}
+
+// OpenAPIValidator 按 OpenAPI 文档校验请求和响应
+type OpenAPIValidator struct {
+	router routers.Router
+	cfg    pkg.OpenAPIConfig
+}
+
+// NewOpenAPIValidator 加载 swag 生成的 Swagger 2.0 文档，转换为 OpenAPI 3 后用于校验
+func NewOpenAPIValidator(doc []byte, cfg pkg.OpenAPIConfig) (*OpenAPIValidator, error) {
+	var doc2 openapi2.T
+	if err := json.Unmarshal(doc, &doc2); err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+	doc3, err := openapi2conv.ToV3(&doc2)
+	if err != nil {
+		return nil, fmt.Errorf("convert openapi document: %w", err)
+	}
+	// 文档中的 host 只用于 Swagger UI，校验时按路径匹配，不限制请求的 Host
+	doc3.Servers = nil
+	if err := doc3.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+	router, err := gorillamux.NewRouter(doc3)
+	if err != nil {
+		return nil, fmt.Errorf("build openapi router: %w", err)
+	}
+	return &OpenAPIValidator{router: router, cfg: cfg}, nil
+}
+
+// Middleware 返回校验中间件。放在认证之后，未认证的请求先返回 401。
+// 文档中没有的路由不校验，由测试保证所有路由都有文档
+func (v *OpenAPIValidator) Middleware() gin.HandlerFunc {
+	options := &openapi3filter.Options{
+		MultiError: true,
+		// 认证由 AuthMiddleware 负责
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	return func(c *gin.Context) {
+		if !v.cfg.ValidateRequests && !v.cfg.ValidateResponses {
+			c.Next()
+			return
+		}
+		route, params, err := v.router.FindRoute(c.Request)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: params,
+			Route:      route,
+			Options:    options,
+		}
+		if v.cfg.ValidateRequests {
+			if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+				response.Abort(c, requestValidationError(err))
+				return
+			}
+		}
+		if !v.cfg.ValidateResponses || streaming(route.Operation) {
+			c.Next()
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		err = openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 writer.status,
+			Header:                 writer.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(writer.body.Bytes())),
+			Options:                options,
+		})
+		if err != nil {
+			pkg.LoggerFromContext(c.Request.Context()).WithError(err).Errorf("Response of %s %s does not match the openapi document", c.Request.Method, c.FullPath())
+			for _, key := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+				writer.Header().Del(key)
+			}
+			response.Fail(c, response.ErrResponseValidation.WithDetails(err.Error()))
+			return
+		}
+		c.Writer.WriteHeader(writer.status)
+		c.Writer.Write(writer.body.Bytes())
+	}
+}
+
+// streaming 返回操作是否以事件流响应，事件流不能缓冲，只校验请求
+func streaming(op *openapi3.Operation) bool {
+	ok := op.Responses.Status(http.StatusOK)
+	return ok != nil && ok.Value != nil && ok.Value.Content.Get("text/event-stream") != nil
+}
+
+// bufferedWriter 缓冲完整响应，校验通过后再写给客户端
+type bufferedWriter struct {
+	gin.ResponseWriter
+	status  int
+	written bool
+	body    bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+// Flush 响应校验完成前不发送任何数据
+func (w *bufferedWriter) Flush() {}
+
+// requestValidationError 将校验错误转换为与请求体绑定错误相同的格式，规则名沿用 binding 标签的写法
+func requestValidationError(err error) *response.Error {
+	var fields []response.FieldError
+	var other []string
+	var collect func(err error)
+	collect = func(err error) {
+		// RequestError 会展开为内部的 MultiError，需要先判断
+		reqErr, ok := err.(*openapi3filter.RequestError)
+		if !ok {
+			if multi, ok := err.(openapi3.MultiError); ok {
+				for _, e := range multi {
+					collect(e)
+				}
+			} else {
+				other = append(other, err.Error())
+			}
+			return
+		}
+
+		field := "body"
+		if reqErr.Parameter != nil {
+			field = reqErr.Parameter.Name
+		}
+		var schemaErrs openapi3.MultiError
+		var schemaErr *openapi3.SchemaError
+		var parseErr *openapi3filter.ParseError
+		switch {
+		case errors.As(reqErr.Err, &schemaErrs):
+			for _, e := range schemaErrs {
+				if errors.As(e, &schemaErr) {
+					fields = append(fields, schemaFieldError(field, reqErr.Parameter != nil, schemaErr))
+				} else {
+					other = append(other, e.Error())
+				}
+			}
+		case errors.As(reqErr.Err, &schemaErr):
+			fields = append(fields, schemaFieldError(field, reqErr.Parameter != nil, schemaErr))
+		case errors.Is(reqErr.Err, openapi3filter.ErrInvalidRequired), errors.Is(reqErr.Err, openapi3filter.ErrInvalidEmptyValue):
+			fields = append(fields, response.FieldError{Field: field, Rule: "required"})
+		case reqErr.Parameter != nil && errors.As(reqErr.Err, &parseErr):
+			// 路径和查询参数无法解析为文档中的类型
+			fields = append(fields, response.FieldError{Field: field, Rule: "type", Param: typeName(reqErr.Parameter.Schema)})
+		default:
+			// 请求体不是合法的 JSON、Content-Type 不匹配等
+			other = append(other, reqErr.Error())
+		}
+	}
+	collect(err)
+
+	if len(fields) == 0 {
+		return response.ErrInvalidRequest.WithDetails(strings.Join(other, "; "))
+	}
+	return response.ErrValidationFailed.WithDetails(fields)
+}
+
+// 文档中的校验关键字对应的 binding 规则名
+var schemaRules = map[string]string{
+	"minLength": "min",
+	"maxLength": "max",
+	"minimum":   "min",
+	"maximum":   "max",
+	"minItems":  "min",
+	"maxItems":  "max",
+	"enum":      "oneof",
+}
+
+func schemaFieldError(field string, isParam bool, err *openapi3.SchemaError) response.FieldError {
+	// 请求体的字段名为 JSON 路径，参数的字段名为参数名
+	if path := err.JSONPointer(); len(path) > 0 {
+		if isParam {
+			field += "." + strings.Join(path, ".")
+		} else {
+			field = strings.Join(path, ".")
+		}
+	}
+	rule := err.SchemaField
+	if r, ok := schemaRules[rule]; ok {
+		rule = r
+	}
+
+	schema := err.Schema
+	var param string
+	switch err.SchemaField {
+	case "type":
+		param = typeName(openapi3.NewSchemaRef("", schema))
+	case "minLength":
+		param = strconv.FormatUint(schema.MinLength, 10)
+	case "maxLength":
+		param = formatUint(schema.MaxLength)
+	case "minimum":
+		param = formatFloat(schema.Min)
+	case "maximum":
+		param = formatFloat(schema.Max)
+	case "minItems":
+		param = strconv.FormatUint(schema.MinItems, 10)
+	case "maxItems":
+		param = formatUint(schema.MaxItems)
+	case "enum":
+		values := make([]string, len(schema.Enum))
+		for i, v := range schema.Enum {
+			values[i] = fmt.Sprint(v)
+		}
+		param = strings.Join(values, " ")
+	case "format":
+		param = schema.Format
+	case "pattern":
+		param = schema.Pattern
+	}
+	return response.FieldError{Field: field, Rule: rule, Param: param}
+}
+
+func typeName(ref *openapi3.SchemaRef) string {
+	if ref == nil || ref.Value == nil || ref.Value.Type == nil {
+		return ""
+	}
+	return strings.Join(ref.Value.Type.Slice(), "|")
+}
+
+func formatUint(v *uint64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatUint(*v, 10)
+}
+
+func formatFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*v, 'f', -1, 64)
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/ratelimit"
+	"github.com/kakaluote000/demo-api/pkg/response"
+)
+
+// 限流响应头。X-RateLimit-Reset 为桶恢复满额所需的秒数
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// 等待 Redis 的最长时间，超时后使用本地限流
+const rateLimitTimeout = 200 * time.Millisecond
+
+type rateLimitPolicies struct {
+	fallback ratelimit.Policy
+	// 认证之前按 IP 计数
+	ip ratelimit.Policy
+	// 键为 "POST /login"
+	routes map[string]ratelimit.Policy
+}
+
+func newRateLimitPolicies(cfg pkg.RateLimitConfig) *rateLimitPolicies {
+	policies := &rateLimitPolicies{
+		fallback: ratelimit.Policy{Rate: cfg.RequestsPerSecond, Burst: cfg.Burst},
+		routes:   make(map[string]ratelimit.Policy, len(cfg.Routes)),
+		ip:       ratelimit.Policy{Rate: cfg.IP.RequestsPerSecond, Burst: cfg.IP.Burst},
+	}
+	if policies.ip.Unlimited() {
+		policies.ip = policies.fallback
+	}
+	for _, route := range cfg.Routes {
+		policies.routes[strings.ToUpper(route.Method)+" "+route.Path] = ratelimit.Policy{Rate: route.RequestsPerSecond, Burst: route.Burst}
+	}
+	return policies
+}
+
+// lookup 返回路由对应的策略和计数使用的名称，未单独配置的路由共用 default
+func (p *rateLimitPolicies) lookup(method, route string) (ratelimit.Policy, string) {
+	name := method + " " + route
+	if policy, ok := p.routes[name]; ok {
+		return policy, name
+	}
+	return p.fallback, "default"
+}
+
+// RateLimiter 按客户端和路由限流，策略随配置热更新
+type RateLimiter struct {
+	limiter  ratelimit.Limiter
+	policies atomic.Pointer[rateLimitPolicies]
+}
+
+// NewRateLimiter 创建限流器。rdb 为 nil 时只在本地限流，否则使用 Redis 在所有实例间共享计数，
+// Redis 不可用时退化为本地限流
+func NewRateLimiter(rdb *redis.Client, cfg pkg.RateLimitConfig) *RateLimiter {
+	l := &RateLimiter{limiter: ratelimit.NewLocalLimiter()}
+	if rdb != nil {
+		fallback := ratelimit.NewFallbackLimiter(ratelimit.NewRedisLimiter(rdb), l.limiter)
+		fallback.OnFallback = func(degraded bool, err error) {
+			if degraded {
+				metrics.RateLimitDegraded.Set(1)
+				log.WithError(err).Warn("Redis rate limiter unavailable, falling back to local limits")
+			} else {
+				metrics.RateLimitDegraded.Set(0)
+				log.Info("Redis rate limiter recovered")
+			}
+		}
+		l.limiter = fallback
+	}
+	l.policies.Store(newRateLimitPolicies(cfg))
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		l.policies.Store(newRateLimitPolicies(next.Security.RateLimit))
+	})
+	return l
+}
+
+// Middleware 返回限流中间件。放在 AuthMiddleware 之后时按用户或 API key 计数，否则按 IP 计数
+func (l *RateLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy, name := l.policies.Load().lookup(c.Request.Method, c.FullPath())
+		l.limit(c, policy, rateLimitClient(c)+":"+name, true)
+	}
+}
+
+// IPMiddleware 返回放在 AuthMiddleware 之前按 IP 限流的中间件，所有需要认证的路由共用一个计数。
+// 错误的 token 和猜测的 API key 同样计数，超出后在查询 Redis 和数据库之前被拒绝。
+// 只在拒绝时设置限流响应头，正常请求的响应头由认证之后的 Middleware 设置
+func (l *RateLimiter) IPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l.limit(c, l.policies.Load().ip, "ip:"+c.ClientIP()+":authenticated", false)
+	}
+}
+
+// AllowIP 按 IP 计数，与 IPMiddleware 共用计数和策略，供 gRPC 在认证之前限流
+func (l *RateLimiter) AllowIP(ctx context.Context, ip string) ratelimit.Result {
+	result, _ := l.allow(ctx, l.policies.Load().ip, "ip:"+ip+":authenticated")
+	return result
+}
+
+// Allow 按客户端和路由计数，与 Middleware 使用同一份策略。
+// gRPC 调用的 method 为 "GRPC"，route 为完整方法名，如 /ledger.v1.LedgerService/Debit
+func (l *RateLimiter) Allow(ctx context.Context, client, method, route string) ratelimit.Result {
+	policy, name := l.policies.Load().lookup(method, route)
+	result, _ := l.allow(ctx, policy, client+":"+name)
+	return result
+}
+
+// allow 返回限流结果，checked 为 false 表示不限流或限流器出错，此时直接放行
+func (l *RateLimiter) allow(ctx context.Context, policy ratelimit.Policy, key string) (result ratelimit.Result, checked bool) {
+	if policy.Unlimited() {
+		return ratelimit.Result{Allowed: true}, false
+	}
+
+	limitCtx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+	result, err := l.limiter.Allow(limitCtx, key, policy)
+	cancel()
+	if err != nil {
+		// 本地限流不会出错，这里只是防御
+		pkg.LoggerFromContext(ctx).WithError(err).Error("Rate limiter failed")
+		return ratelimit.Result{Allowed: true}, false
+	}
+	return result, true
+}
+
+func (l *RateLimiter) limit(c *gin.Context, policy ratelimit.Policy, key string, headers bool) {
+	result, checked := l.allow(c.Request.Context(), policy, key)
+	if !checked {
+		c.Next()
+		return
+	}
+
+	if headers || !result.Allowed {
+		c.Header(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+		c.Header(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		c.Header(RateLimitResetHeader, ceilSeconds(result.ResetAfter))
+	}
+	if !result.Allowed {
+		metrics.RateLimitRejections.WithLabelValues(c.Request.Method, c.FullPath()).Inc()
+		c.Header(RetryAfterHeader, ceilSeconds(result.RetryAfter))
+		response.Abort(c, response.ErrRateLimited)
+		return
+	}
+	c.Next()
+}
+
+// RateLimitMiddleware 只在本地限流的中间件，适合单实例部署
+func RateLimitMiddleware(cfg pkg.RateLimitConfig) gin.HandlerFunc {
+	return NewRateLimiter(nil, cfg).Middleware()
+}
+
+// rateLimitClient 返回计数使用的客户端标识，如 user:1、api_key:3、ip:10.0.0.1。
+// ClientIP 只在请求来自 server.trusted_proxies 时才使用 X-Forwarded-For，客户端无法通过伪造该头绕过限流
+func rateLimitClient(c *gin.Context) string {
+	if principal, ok := c.Get("principal"); ok {
+		return principal.(*auth.Principal).String()
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/response"
 	"github.com/unrolled/secure"
 )
 
@@ -39,3 +41,19 @@ func XSSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// ClientCertMiddleware 要求请求携带经 CA 校验的客户端证书 (mTLS)，
+// 证书 CN 存入上下文，证书主题写入请求日志，访问日志可以追溯到具体的客户端证书
+func ClientCertMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			response.Abort(c, response.ErrClientCertRequired)
+			return
+		}
+
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		c.Set("clientCertCommonName", cert.Subject.CommonName)
+		setRequestLogger(c, pkg.LoggerFromContext(c.Request.Context()).WithField("client_cert", cert.Subject.String()))
+		c.Next()
+	}
+}
@@ -1,28 +1,58 @@
 package tests
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
 	"github.com/kakaluote000/demo-api/cmd/app"
 	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
 	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func TestAuthMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 
-	r.Use(middleware.AuthMiddleware())
+	// 用户禁用状态以数据库为准，token 对应的用户必须存在
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pkg.AutoMigrate(db))
+	for _, id := range []uint{1, 2} {
+		require.NoError(t, db.Create(&models.User{Model: gorm.Model{ID: id}, Username: fmt.Sprintf("user%d", id), Password: "x"}).Error)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	a := app.NewAppWith(context.Background(), db, rdb, nil)
+	r.Use(middleware.AuthMiddleware(a))
 	r.GET("/test", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "success"})
 	})
 
+	auth.Init(pkg.JWTConfig{Secret: "test-secret", Expiry: time.Hour})
+	tokens := auth.NewTokenStore(rdb, db)
+	pair, err := tokens.IssueTokens(context.Background(), 1, auth.RoleUser)
+	require.NoError(t, err)
+	revoked, err := tokens.IssueTokens(context.Background(), 2, auth.RoleUser)
+	require.NoError(t, err)
+	revokedToken := revoked.AccessToken
+	claims, err := auth.ParseToken(revokedToken)
+	require.NoError(t, err)
+	require.NoError(t, tokens.Revoke(context.Background(), claims))
+
 	tests := []struct {
 		name       string
 		token      string
@@ -43,6 +73,16 @@ func TestAuthMiddleware(t *testing.T) {
 			token:      "invalid_token",
 			wantStatus: http.StatusUnauthorized,
 		},
+		{
+			name:       "Refresh token",
+			token:      pair.RefreshToken,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Revoked token",
+			token:      revokedToken,
+			wantStatus: http.StatusUnauthorized,
+		},
 	}
 
 	for _, tt := range tests {
@@ -61,7 +101,7 @@ func TestAuthMiddleware(t *testing.T) {
 func TestRateLimitMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.Use(middleware.RateLimitMiddleware())
+	r.Use(middleware.RateLimitMiddleware(pkg.RateLimitConfig{RequestsPerSecond: 1, Burst: 100}))
 	r.GET("/test", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "success"})
 	})
@@ -79,34 +119,137 @@ func TestRateLimitMiddleware(t *testing.T) {
 	}
 }
 
-func TestDistributedLockMiddleware(t *testing.T) {
-	app := app.NewApp()
+func TestCORSMiddleware(t *testing.T) {
 	gin.SetMode(gin.TestMode)
-	r := gin.New()
 
-	r.POST("/test", middleware.DistributedLockMiddleware(app, "test_lock", time.Second), func(c *gin.Context) {
-		c.JSON(200, gin.H{"message": "success"})
+	tests := []struct {
+		name            string
+		cfg             pkg.CORSConfig
+		origin          string
+		method          string
+		wantStatus      int
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{
+			name:            "Allowed origin",
+			cfg:             pkg.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:          "https://app.example.com",
+			method:          "GET",
+			wantStatus:      http.StatusOK,
+			wantOrigin:      "https://app.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:       "Wildcard never sends credentials",
+			cfg:        pkg.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true},
+			origin:     "https://other.example.com",
+			method:     "GET",
+			wantStatus: http.StatusOK,
+			wantOrigin: "*",
+		},
+		{
+			name:       "Disallowed origin",
+			cfg:        pkg.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:     "https://evil.example.com",
+			method:     "GET",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Disallowed preflight",
+			cfg:        pkg.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			origin:     "https://evil.example.com",
+			method:     "OPTIONS",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Allowed preflight",
+			cfg:        pkg.CORSConfig{AllowedOrigins: []string{"https://app.example.com"}},
+			origin:     "https://app.example.com",
+			method:     "OPTIONS",
+			wantStatus: http.StatusNoContent,
+			wantOrigin: "https://app.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(middleware.CORSMiddleware(tt.cfg))
+			r.GET("/test", func(c *gin.Context) {
+				c.JSON(200, gin.H{"message": "success"})
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, "/test", nil)
+			req.Header.Set("Origin", tt.origin)
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			assert.Equal(t, tt.wantOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.wantCredentials, w.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.RequestIDMiddleware())
+	r.GET("/test", func(c *gin.Context) {
+		c.JSON(200, gin.H{"request_id": c.GetString("requestID")})
 	})
 
-	// 测试并发请求
-	done := make(chan bool)
-	for i := 0; i < 10; i++ {
-		go func() {
+	tests := []struct {
+		name      string
+		requestID string
+		wantSame  bool
+	}{
+		{name: "Propagated", requestID: "req-123", wantSame: true},
+		{name: "Generated when missing", requestID: ""},
+		{name: "Regenerated when invalid", requestID: "bad id\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
 			w := httptest.NewRecorder()
-			req := httptest.NewRequest("POST", "/test", strings.NewReader(`{"user_id":1}`))
-			req.Header.Set("Content-Type", "application/json")
+			req := httptest.NewRequest("GET", "/test", nil)
+			if tt.requestID != "" {
+				req.Header.Set(middleware.RequestIDHeader, tt.requestID)
+			}
 			r.ServeHTTP(w, req)
-			done <- true
-		}()
+
+			got := w.Header().Get(middleware.RequestIDHeader)
+			assert.NotEmpty(t, got)
+			assert.Contains(t, w.Body.String(), got)
+			if tt.wantSame {
+				assert.Equal(t, tt.requestID, got)
+			} else {
+				assert.NotEqual(t, tt.requestID, got)
+			}
+		})
 	}
+}
 
-	// 等待所有请求完成
-	for i := 0; i < 10; i++ {
-		<-done
+func TestLoggerMiddlewareUsesRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(middleware.LoggerMiddleware())
+	r.GET("/userCurrency/:id", func(c *gin.Context) {
+		c.JSON(200, gin.H{"message": "success"})
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/userCurrency/"+id, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
 	}
+
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.RequestCounter.WithLabelValues("GET", "/userCurrency/:id", "200")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.RequestCounter.WithLabelValues("GET", "/userCurrency/1", "200")))
 }
 
 func generateValidToken() string {
+	auth.Init(pkg.JWTConfig{Secret: "test-secret", Expiry: time.Hour})
 	token, _ := auth.GenerateToken(1)
 	return token
 }
@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOpenAPIDoc = `{
+	"swagger": "2.0",
+	"info": {"title": "test", "version": "1.0"},
+	"host": "localhost:8080",
+	"basePath": "/",
+	"paths": {
+		"/items/{id}": {
+			"put": {
+				"consumes": ["application/json"],
+				"produces": ["application/json"],
+				"parameters": [
+					{"name": "id", "in": "path", "type": "integer", "required": true},
+					{"name": "mode", "in": "query", "type": "string", "enum": ["fast", "safe"]},
+					{"name": "body", "in": "body", "required": true, "schema": {
+						"type": "object",
+						"required": ["name"],
+						"properties": {
+							"name": {"type": "string", "minLength": 3},
+							"kind": {"type": "string", "enum": ["a", "b"]}
+						}
+					}}
+				],
+				"responses": {
+					"200": {"description": "OK", "schema": {
+						"type": "object",
+						"required": ["code"],
+						"properties": {"code": {"type": "string"}}
+					}}
+				}
+			}
+		}
+	}
+}`
+
+func newOpenAPIRouter(t *testing.T, cfg pkg.OpenAPIConfig, respond func(c *gin.Context)) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	validator, err := middleware.NewOpenAPIValidator([]byte(testOpenAPIDoc), cfg)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(validator.Middleware())
+	r.PUT("/items/:id", respond)
+	r.GET("/undocumented", respond)
+	return r
+}
+
+func TestOpenAPIValidatorRejectsInvalidRequests(t *testing.T) {
+	r := newOpenAPIRouter(t, pkg.OpenAPIConfig{ValidateRequests: true}, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"code": "OK"})
+	})
+	serve := func(method, path, contentType, body string) (int, response.Response) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		var resp response.Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+		return w.Code, resp
+	}
+	fieldErrors := func(resp response.Response) []response.FieldError {
+		require.Equal(t, response.ErrValidationFailed.Code, resp.Code)
+		data, err := json.Marshal(resp.Details)
+		require.NoError(t, err)
+		var fields []response.FieldError
+		require.NoError(t, json.Unmarshal(data, &fields))
+		return fields
+	}
+
+	status, _ := serve("PUT", "/items/1?mode=fast", "application/json", `{"name":"abc","kind":"a"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	// 每个不符合文档的字段都会列出，规则名与 binding 标签一致
+	status, resp := serve("PUT", "/items/1", "application/json", `{"name":"ab","kind":"c"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.ElementsMatch(t, []response.FieldError{
+		{Field: "name", Rule: "min", Param: "3"},
+		{Field: "kind", Rule: "oneof", Param: "a b"},
+	}, fieldErrors(resp))
+
+	_, resp = serve("PUT", "/items/1", "application/json", `{"kind":"a"}`)
+	assert.Equal(t, []response.FieldError{{Field: "name", Rule: "required"}}, fieldErrors(resp))
+
+	_, resp = serve("PUT", "/items/1", "application/json", "")
+	assert.Equal(t, []response.FieldError{{Field: "body", Rule: "required"}}, fieldErrors(resp))
+
+	_, resp = serve("PUT", "/items/abc?mode=slow", "application/json", `{"name":"abc"}`)
+	assert.ElementsMatch(t, []response.FieldError{
+		{Field: "id", Rule: "type", Param: "integer"},
+		{Field: "mode", Rule: "oneof", Param: "fast safe"},
+	}, fieldErrors(resp))
+
+	// 无法按字段说明的错误返回 INVALID_REQUEST
+	status, resp = serve("PUT", "/items/1", "application/json", `{"name":`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, response.ErrInvalidRequest.Code, resp.Code)
+	_, resp = serve("PUT", "/items/1", "text/plain", "name=abc")
+	assert.Equal(t, response.ErrInvalidRequest.Code, resp.Code)
+
+	// 文档中没有的路由不校验
+	status, _ = serve("GET", "/undocumented", "text/plain", "anything")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestOpenAPIValidatorChecksResponses(t *testing.T) {
+	var body gin.H
+	respond := func(c *gin.Context) {
+		c.JSON(http.StatusOK, body)
+	}
+	serve := func(r *gin.Engine) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/items/1", strings.NewReader(`{"name":"abc"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	r := newOpenAPIRouter(t, pkg.OpenAPIConfig{ValidateResponses: true}, respond)
+	body = gin.H{"code": "OK"}
+	w := serve(r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code":"OK"}`, w.Body.String())
+
+	body = gin.H{"code": 1}
+	w = serve(r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), string(response.ErrResponseValidation.Code))
+
+	// 关闭响应校验时原样返回
+	r = newOpenAPIRouter(t, pkg.OpenAPIConfig{ValidateRequests: true}, respond)
+	w = serve(r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"code":1}`, w.Body.String())
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey 定义服务间调用使用的 API key，对应 api_keys 表。
+// 只保存 key 的哈希，明文仅在创建时返回一次。
+type APIKey struct {
+	gorm.Model
+	Name string `gorm:"column:name;not null" json:"name"`
+	// key 的前几位，便于识别是哪个 key
+	Prefix  string `gorm:"column:prefix;not null" json:"prefix"`
+	KeyHash string `gorm:"column:key_hash;type:char(64);not null;uniqueIndex" json:"-"`
+	// 逗号分隔的授权范围，如 wallet:read,wallet:credit
+	Scopes string `gorm:"column:scopes;not null" json:"scopes"`
+	// 逗号分隔的 IP 或 CIDR，为空表示不限制
+	AllowedIPs string     `gorm:"column:allowed_ips" json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time `gorm:"column:expires_at" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at" json:"last_used_at,omitempty"`
+	CreatedBy  uint       `gorm:"column:created_by" json:"created_by"`
+}
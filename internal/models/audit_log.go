@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog 定义审计日志，对应 audit_logs 表，只允许追加。
+// 每条记录的 Hash 覆盖自身内容和上一条的 Hash，按 Seq 连成一条哈希链，
+// 任何记录被修改或删除都会导致之后的链校验失败
+type AuditLog struct {
+	ID uint `gorm:"primaryKey" json:"id"`
+	// 链上的序号，从 1 开始连续递增；启用哈希链之前写入的记录为空
+	Seq *uint64 `gorm:"column:seq;uniqueIndex" json:"seq,omitempty"`
+	// 操作者，如 user:1、api_key:3、system，未登录时为 anonymous
+	Actor  string `gorm:"column:actor;size:64;not null;index" json:"actor"`
+	Action string `gorm:"column:action;size:64;not null;index" json:"action"`
+	// 操作对象，如 user:5、ip:10.0.0.1
+	Target string `gorm:"column:target;size:128;index" json:"target"`
+	// 变更前后的值，JSON 格式
+	Before    string    `gorm:"column:before;type:text" json:"before,omitempty"`
+	After     string    `gorm:"column:after;type:text" json:"after,omitempty"`
+	RequestID string    `gorm:"column:request_id;size:64" json:"request_id,omitempty"`
+	IP        string    `gorm:"column:ip;size:64" json:"ip,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// 上一条记录的 Hash，链上第一条为空
+	PrevHash string `gorm:"column:prev_hash;size:64" json:"prev_hash"`
+	Hash     string `gorm:"column:hash;size:64;index" json:"hash"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode 定义两步验证的一次性恢复码，对应 recovery_codes 表，只保存哈希
+type RecoveryCode struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	UserID   uint   `gorm:"column:user_id;not null;index" json:"user_id"`
+	CodeHash string `gorm:"column:code_hash;type:char(64);not null;index" json:"-"`
+	// 使用后不可再次使用
+	UsedAt    *time.Time `gorm:"column:used_at" json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -10,14 +10,22 @@ import (
 type User struct {
 	gorm.Model
 	Username string `gorm:"column:username;not null;unique" json:"username"`
-	Password string `gorm:"column:password;not null" json:"password"`
+	// 密码哈希，不参与序列化
+	Password string `gorm:"column:password;not null" json:"-"`
+	// 角色：user、operator、admin、auditor，见 auth.Role
+	Role string `gorm:"column:role;type:varchar(32);not null;default:user" json:"role"`
+	// TOTP 密钥 (base32)，绑定验证通过前 TOTPEnabled 为 false
+	TOTPSecret  string `gorm:"column:totp_secret;size:64" json:"-"`
+	TOTPEnabled bool   `gorm:"column:totp_enabled;not null;default:false" json:"totp_enabled"`
+	// 被管理员禁用的时间，为空表示正常
+	DisabledAt *time.Time `gorm:"column:disabled_at" json:"disabled_at,omitempty"`
 }
 
-// UserCurrency 定义用户货币模型，对应 user_currency 表
+// UserCurrency 定义用户货币模型，对应 user_currency 表。每个用户每个币种只有一个钱包，由唯一索引保证
 type UserCurrency struct {
 	gorm.Model
-	UserID      uint `gorm:"column:user_id;not null" json:"user_id"`
-	CurrencyID  uint `gorm:"column:currency_id;not null" json:"currency_id"`
+	UserID      uint `gorm:"column:user_id;not null;uniqueIndex:idx_user_currency" json:"user_id"`
+	CurrencyID  uint `gorm:"column:currency_id;not null;uniqueIndex:idx_user_currency" json:"currency_id"`
 	CurrencyNum uint `gorm:"column:currency_num;not null" json:"currency_num"`
 }
 
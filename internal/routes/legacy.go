@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/handlers"
+	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+)
+
+// LegacySunset 未带版本号的旧路由的下线时间
+var LegacySunset = time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+
+// legacySuccessors 旧路由对应的 /api/v1 路由，通过 Link 头告知客户端。
+// 替代路由的路径参数在旧路由中不存在时（如钱包 ID 在请求体中）不返回 Link
+var legacySuccessors = map[string]string{
+	"POST /login":                   "/api/v1/auth/login",
+	"POST /register":                "/api/v1/auth/register",
+	"POST /refresh":                 "/api/v1/auth/refresh",
+	"POST /login/2fa":               "/api/v1/auth/login/2fa",
+	"POST /login/2fa/enroll":        "/api/v1/auth/login/2fa/enroll",
+	"POST /login/2fa/enroll/verify": "/api/v1/auth/login/2fa/enroll/verify",
+	"POST /password/reset":          "/api/v1/auth/password/reset",
+	"POST /password/reset/confirm":  "/api/v1/auth/password/reset/confirm",
+	"POST /logout":                  "/api/v1/auth/logout",
+	"POST /logout/all":              "/api/v1/auth/logout/all",
+	"POST /password/change":         "/api/v1/auth/password/change",
+	"POST /2fa/enroll":              "/api/v1/auth/2fa/enroll",
+	"POST /2fa/verify":              "/api/v1/auth/2fa/verify",
+	"POST /2fa/recoveryCodes":       "/api/v1/auth/2fa/recovery-codes",
+	"POST /2fa/disable":             "/api/v1/auth/2fa/disable",
+	"GET /userCurrency/:id":         "/api/v1/users/:id/wallets",
+	"POST /addCurrencyNum":          "/api/v1/transactions",
+	"POST /subtractCurrencyNum":     "/api/v1/transactions",
+	"POST /userCurrency":            "/api/v1/users/:id/wallets",
+	"POST /updateUserCurrency":      "/api/v1/users/:id/wallets/:currency",
+	"POST /apiKeys":                 "/api/v1/api-keys",
+	"GET /apiKeys":                  "/api/v1/api-keys",
+	"DELETE /apiKeys/:id":           "/api/v1/api-keys/:id",
+	"GET /users":                    "/api/v1/users",
+	"GET /users/:id":                "/api/v1/users/:id",
+	"POST /users/:id/disable":       "/api/v1/users/:id/disable",
+	"POST /users/:id/enable":        "/api/v1/users/:id/enable",
+	"DELETE /users/:id":             "/api/v1/users/:id",
+	"POST /users/:id/restore":       "/api/v1/users/:id/restore",
+	"POST /users/:id/unlock":        "/api/v1/users/:id/unlock",
+	"GET /audit":                    "/api/v1/audit-logs",
+	"GET /audit/export":             "/api/v1/audit-logs/export",
+}
+
+// setupLegacyRoutes 注册未带版本号的旧路由，行为保持不变，响应中带 Deprecation 和 Sunset 头。
+// 废弃标记放在认证之前，认证失败的响应也会带上
+func setupLegacyRoutes(app *app.App, router *gin.Engine, limiter *middleware.RateLimiter, validate gin.HandlerFunc) {
+	deprecated := middleware.DeprecationMiddleware(LegacySunset, legacySuccessors)
+
+	// 公开路由
+	public := router.Group("/")
+	public.Use(deprecated, limiter.Middleware(), validate)
+	{
+		public.POST("/login", handlers.LoginHandler(app))
+		public.POST("/register", handlers.RegisterHandler(app))
+		public.POST("/refresh", handlers.RefreshTokenHandler(app))
+		// 两步登录，使用 /login 返回的 mfa_token
+		public.POST("/login/2fa", handlers.LoginMFAHandler(app))
+		public.POST("/login/2fa/enroll", handlers.LoginMFAEnrollHandler(app))
+		public.POST("/login/2fa/enroll/verify", handlers.LoginMFAEnrollVerifyHandler(app))
+		public.POST("/password/reset", handlers.RequestPasswordResetHandler(app))
+		public.POST("/password/reset/confirm", handlers.ConfirmPasswordResetHandler(app))
+	}
+
+	// 需要认证的路由
+	authorized := router.Group("/")
+	authorized.Use(deprecated, limiter.IPMiddleware(), middleware.AuthMiddleware(app), limiter.Middleware(), validate)
+	{
+		authorized.POST("/logout", handlers.LogoutHandler(app))
+		authorized.POST("/logout/all", handlers.LogoutAllHandler(app))
+		authorized.POST("/password/change", handlers.ChangePasswordHandler(app))
+		authorized.POST("/2fa/enroll", handlers.EnrollMFAHandler(app))
+		authorized.POST("/2fa/verify", handlers.VerifyMFAHandler(app))
+		authorized.POST("/2fa/recoveryCodes", handlers.RegenerateRecoveryCodesHandler(app))
+		authorized.POST("/2fa/disable", handlers.DisableMFAHandler(app))
+		// 普通用户只能查看自己的钱包、从自己的钱包扣减，归属检查在 handler 中进行。
+		// 余额变更由账本服务在自己的事务中用条件更新完成，不需要请求级事务和分布式锁
+		authorized.GET("/userCurrency/:id",
+			middleware.RequirePermission(auth.PermWalletRead),
+			handlers.GetUserCurrencyHandler(app))
+		authorized.POST("/subtractCurrencyNum",
+			middleware.RequireAnyPermission(auth.PermWalletSpend, auth.PermWalletDebit),
+			handlers.SubtractCurrencyNumHandler(app))
+	}
+
+	// 发放货币仅限运营、管理员和带 wallet:credit 的 API key
+	authorized.POST("/addCurrencyNum",
+		middleware.RequirePermission(auth.PermWalletCredit),
+		handlers.AddCurrencyNumHandler(app))
+
+	// 创建钱包、直接修改余额仅限运营和管理员
+	manage := authorized.Group("/")
+	manage.Use(middleware.RequirePermission(auth.PermWalletManage))
+	{
+		manage.POST("/userCurrency", handlers.AddUserCurrencyHandler(app))
+		manage.POST("/updateUserCurrency", handlers.UpdateUserCurrencyHandler(app))
+	}
+
+	// API key 管理
+	apiKeys := authorized.Group("/apiKeys")
+	apiKeys.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermAPIKeyManage))...)
+	{
+		apiKeys.POST("", handlers.CreateAPIKeyHandler(app))
+		apiKeys.GET("", handlers.ListAPIKeysHandler(app))
+		apiKeys.DELETE("/:id", handlers.RevokeAPIKeyHandler(app))
+	}
+
+	// 用户管理
+	users := authorized.Group("/users")
+	users.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermUserManage))...)
+	{
+		users.GET("", handlers.ListUsersHandler(app))
+		users.GET("/:id", handlers.GetUserHandler(app))
+		users.POST("/:id/disable", handlers.DisableUserHandler(app))
+		users.POST("/:id/enable", handlers.EnableUserHandler(app))
+		users.DELETE("/:id", handlers.DeleteUserHandler(app))
+		users.POST("/:id/restore", handlers.RestoreUserHandler(app))
+		users.POST("/:id/unlock", handlers.UnlockUserHandler(app))
+	}
+
+	// 审计日志查询和导出仅限审计员
+	auditLogs := authorized.Group("/audit")
+	auditLogs.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermAuditRead))...)
+	{
+		auditLogs.GET("", handlers.ListAuditLogsHandler(app))
+		auditLogs.GET("/export", handlers.ExportAuditLogsHandler(app))
+	}
+}
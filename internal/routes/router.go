@@ -1,55 +1,152 @@
 package routes
 
 import (
-	"time"
-
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/docs"
 	"github.com/kakaluote000/demo-api/internal/handlers"
 	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func SetupRoutes(app *app.App) {
 	router := app.Router
-	
+
+	tlsCfg := pkg.AppConfig.Server.TLS
+
 	// 全局中间件
+	router.Use(otelgin.Middleware(pkg.AppConfig.Tracing.ServiceName))
+	router.Use(middleware.RequestIDMiddleware())
 	router.Use(middleware.LoggerMiddleware())
-	router.Use(middleware.CORSMiddleware())
-	router.Use(middleware.RateLimitMiddleware())
+	if tlsCfg.Enabled {
+		router.Use(middleware.SecurityMiddleware())
+	}
+	router.Use(middleware.CORSMiddleware(pkg.AppConfig.Security.CORS))
 
 	// 监控和健康检查路由
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	router.GET("/health", handlers.HealthCheckHandler())
 	router.GET("/readiness", handlers.ReadinessCheckHandler(app))
+	router.GET("/.well-known/jwks.json", handlers.JWKSHandler())
+
+	// 需要认证的路由在认证之前先按 IP 限流，认证之后再按用户或 API key 计数，公开路由按 IP 计数
+	limiter := middleware.NewRateLimiter(app.Redis, pkg.AppConfig.Security.RateLimit)
+
+	// 按接口文档校验请求，放在认证和限流之后
+	validator, err := middleware.NewOpenAPIValidator([]byte(docs.SwaggerInfo.ReadDoc()), pkg.AppConfig.Server.OpenAPI)
+	if err != nil {
+		pkg.Log.Fatalf("Invalid openapi document: %v", err)
+	}
+	validate := validator.Middleware()
+
+	setupV1Routes(app, router.Group("/api/v1"), limiter, validate)
+	setupLegacyRoutes(app, router, limiter, validate)
+
+	// 监控相关路由，配置了客户端 CA 时使用 mTLS，否则需要带 alerts:write 的 API key
+	monitoring := router.Group("/monitoring")
+	monitoring.Use(limiter.IPMiddleware())
+	if tlsCfg.ClientCAFile != "" {
+		monitoring.Use(middleware.ClientCertMiddleware())
+	} else {
+		monitoring.Use(middleware.AuthMiddleware(app), middleware.RequirePermission(auth.PermAlertWrite))
+	}
+	monitoring.Use(limiter.Middleware(), validate)
+	{
+		monitoring.POST("/webhook", handlers.AlertWebhookHandler(app))
+	}
+}
+
+// adminCertMiddleware 配置了客户端 CA 时，用户、API key 和审计日志等管理路由在认证之外还要求客户端证书
+func adminCertMiddleware() []gin.HandlerFunc {
+	if pkg.AppConfig.Server.TLS.ClientCAFile == "" {
+		return nil
+	}
+	return []gin.HandlerFunc{middleware.ClientCertMiddleware()}
+}
 
+// setupV1Routes 注册 /api/v1 下的资源路由
+func setupV1Routes(app *app.App, v1 *gin.RouterGroup, limiter *middleware.RateLimiter, validate gin.HandlerFunc) {
 	// 公开路由
-	public := router.Group("/")
+	public := v1.Group("/auth")
+	public.Use(limiter.Middleware(), validate)
 	{
 		public.POST("/login", handlers.LoginHandler(app))
 		public.POST("/register", handlers.RegisterHandler(app))
+		public.POST("/refresh", handlers.RefreshTokenHandler(app))
+		// 两步登录，使用 /login 返回的 mfa_token
+		public.POST("/login/2fa", handlers.LoginMFAHandler(app))
+		public.POST("/login/2fa/enroll", handlers.LoginMFAEnrollHandler(app))
+		public.POST("/login/2fa/enroll/verify", handlers.LoginMFAEnrollVerifyHandler(app))
+		public.POST("/password/reset", handlers.RequestPasswordResetHandler(app))
+		public.POST("/password/reset/confirm", handlers.ConfirmPasswordResetHandler(app))
 	}
 
 	// 需要认证的路由
-	authorized := router.Group("/")
-	authorized.Use(middleware.AuthMiddleware())
+	authorized := v1.Group("")
+	authorized.Use(limiter.IPMiddleware(), middleware.AuthMiddleware(app), limiter.Middleware(), validate)
+
+	session := authorized.Group("/auth")
 	{
-		authorized.GET("/userCurrency/:id", handlers.GetUserCurrencyHandler(app))
-		authorized.POST("/userCurrency", handlers.AddUserCurrencyHandler(app))
-		authorized.POST("/updateUserCurrency", handlers.UpdateUserCurrencyHandler(app))
-		authorized.POST("/addCurrencyNum", 
-			middleware.TransactionMiddleware(app.DB), 
-			middleware.DistributedLockMiddleware(app, "add_currency_lock", 10*time.Second), 
-			handlers.AddCurrencyNumHandler(app))
-		authorized.POST("/subtractCurrencyNum", 
-			middleware.TransactionMiddleware(app.DB), 
-			middleware.DistributedLockMiddleware(app, "subtract_currency_lock", 10*time.Second), 
-			handlers.SubtractCurrencyNumHandler(app))
-	}
-
-	// 监控相关路由
-	monitoring := router.Group("/monitoring")
+		session.POST("/logout", handlers.LogoutHandler(app))
+		session.POST("/logout/all", handlers.LogoutAllHandler(app))
+		session.POST("/password/change", handlers.ChangePasswordHandler(app))
+		session.POST("/2fa/enroll", handlers.EnrollMFAHandler(app))
+		session.POST("/2fa/verify", handlers.VerifyMFAHandler(app))
+		session.POST("/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler(app))
+		session.POST("/2fa/disable", handlers.DisableMFAHandler(app))
+	}
+
+	// 普通用户只能查看自己的钱包和流水、从自己的钱包扣减和转出，归属检查在 handler 中进行。
+	// 余额变更使用条件更新保证原子性，不需要分布式锁
+	wallets := authorized.Group("/users/:id")
 	{
-		monitoring.POST("/webhook", handlers.AlertWebhookHandler(app))
+		wallets.GET("/wallets", middleware.RequirePermission(auth.PermWalletRead), handlers.ListWalletsHandler(app))
+		wallets.GET("/wallets/:currency", middleware.RequirePermission(auth.PermWalletRead), handlers.GetWalletHandler(app))
+		wallets.GET("/transactions", middleware.RequirePermission(auth.PermWalletRead), handlers.ListTransactionsHandler(app))
+		wallets.GET("/balance-events", middleware.RequirePermission(auth.PermWalletRead), handlers.StreamBalanceEventsHandler(app))
+		// 创建钱包、直接修改余额仅限运营和管理员
+		wallets.POST("/wallets", middleware.RequirePermission(auth.PermWalletManage), handlers.CreateWalletHandler(app))
+		wallets.PUT("/wallets/:currency", middleware.RequirePermission(auth.PermWalletManage), handlers.SetWalletBalanceHandler(app))
+	}
+	// credit 需要 wallet:credit，在 handler 中按交易类型检查
+	authorized.POST("/transactions",
+		middleware.RequireAnyPermission(auth.PermWalletSpend, auth.PermWalletDebit, auth.PermWalletCredit),
+		handlers.CreateTransactionHandler(app))
+	authorized.POST("/transfers",
+		middleware.RequireAnyPermission(auth.PermWalletSpend, auth.PermWalletDebit),
+		handlers.CreateTransferHandler(app))
+
+	// API key 管理
+	apiKeys := authorized.Group("/api-keys")
+	apiKeys.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermAPIKeyManage))...)
+	{
+		apiKeys.POST("", handlers.CreateAPIKeyHandler(app))
+		apiKeys.GET("", handlers.ListAPIKeysHandler(app))
+		apiKeys.DELETE("/:id", handlers.RevokeAPIKeyHandler(app))
+	}
+
+	// 用户管理
+	users := authorized.Group("/users")
+	users.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermUserManage))...)
+	{
+		users.GET("", handlers.ListUsersHandler(app))
+		users.GET("/:id", handlers.GetUserHandler(app))
+		users.POST("/:id/disable", handlers.DisableUserHandler(app))
+		users.POST("/:id/enable", handlers.EnableUserHandler(app))
+		users.DELETE("/:id", handlers.DeleteUserHandler(app))
+		users.POST("/:id/restore", handlers.RestoreUserHandler(app))
+		users.PUT("/:id/role", handlers.SetUserRoleHandler(app))
+		users.POST("/:id/unlock", handlers.UnlockUserHandler(app))
+	}
+
+	// 审计日志查询和导出仅限审计员
+	auditLogs := authorized.Group("/audit-logs")
+	auditLogs.Use(append(adminCertMiddleware(), middleware.RequirePermission(auth.PermAuditRead))...)
+	{
+		auditLogs.GET("", handlers.ListAuditLogsHandler(app))
+		auditLogs.GET("/export", handlers.ExportAuditLogsHandler(app))
 	}
 }
@@ -0,0 +1,1243 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/handlers"
+	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/internal/routes"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 每个角色对应一个用户，otherUserID 是不属于任何调用者的普通用户
+var roleUsers = map[auth.Role]uint{
+	auth.RoleUser:     1,
+	auth.RoleOperator: 2,
+	auth.RoleAdmin:    3,
+	auth.RoleAuditor:  4,
+}
+
+const otherUserID = 5
+
+// opts 在路由创建前修改配置
+func newTestApp(t *testing.T, opts ...func(*pkg.Config)) *app.App {
+	gin.SetMode(gin.TestMode)
+	pkg.AppConfig = pkg.Config{}
+	pkg.AppConfig.Security.RateLimit = pkg.RateLimitConfig{RequestsPerSecond: 1000, Burst: 1000}
+	// 不启用递增延迟，便于测试锁定
+	pkg.AppConfig.Security.Lockout = pkg.LockoutConfig{MaxFailures: 3, DelayAfter: 100}
+	// 请求和响应都必须符合接口文档
+	pkg.AppConfig.Server.OpenAPI = pkg.OpenAPIConfig{ValidateRequests: true, ValidateResponses: true}
+	for _, opt := range opts {
+		opt(&pkg.AppConfig)
+	}
+	auth.Init(pkg.JWTConfig{Secret: "test-secret", Expiry: time.Hour})
+
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pkg.AutoMigrate(db))
+
+	for role, id := range roleUsers {
+		require.NoError(t, db.Create(&models.User{Model: gorm.Model{ID: id}, Username: string(role), Password: "x", Role: string(role)}).Error)
+	}
+	require.NoError(t, db.Create(&models.User{Model: gorm.Model{ID: otherUserID}, Username: "other", Password: "x", Role: string(auth.RoleUser)}).Error)
+	for id := uint(1); id <= otherUserID; id++ {
+		require.NoError(t, db.Create(&models.UserCurrency{UserID: id, CurrencyID: 1, CurrencyNum: 100}).Error)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	a := app.NewAppWith(context.Background(), db, rdb, redsync.New(goredis.NewPool(rdb)))
+	routes.SetupRoutes(a)
+	return a
+}
+
+func request(a *app.App, method, path, token string, body any) *httptest.ResponseRecorder {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return serve(a, method, path, header, body)
+}
+
+func apiKeyRequest(a *app.App, method, path, key string, body any) *httptest.ResponseRecorder {
+	header := http.Header{}
+	header.Set(middleware.APIKeyHeader, key)
+	return serve(a, method, path, header, body)
+}
+
+func serve(a *app.App, method, path string, header http.Header, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	a.Router.ServeHTTP(w, req)
+	return w
+}
+
+// decodeData 解析标准响应，将 data 写入 v
+func decodeData(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	var resp struct {
+		response.Response
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	require.Equal(t, response.CodeOK, resp.Code, w.Body.String())
+	require.NoError(t, json.Unmarshal(resp.Data, v), w.Body.String())
+}
+
+// decodeResponse 解析不带 data 的响应，用于检查错误码
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) response.Response {
+	t.Helper()
+	var resp response.Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp), w.Body.String())
+	return resp
+}
+
+func TestRoutePermissionsByRole(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+
+	type route struct {
+		name   string
+		method string
+		path   func(self uint) string
+		body   func(self uint) any
+	}
+	wallet := func(userID uint) any {
+		return gin.H{"user_id": userID, "currency_id": 1, "currency_num": 1}
+	}
+	self := func(self uint) uint { return self }
+	other := func(uint) uint { return otherUserID }
+	readWallet := func(target func(uint) uint) func(uint) string {
+		return func(id uint) string { return fmt.Sprintf("/userCurrency/%d", target(id)) }
+	}
+	writeWallet := func(target func(uint) uint) func(uint) any {
+		return func(id uint) any { return wallet(target(id)) }
+	}
+	fixed := func(path string) func(uint) string { return func(uint) string { return path } }
+
+	const ok, forbidden = http.StatusOK, http.StatusForbidden
+	cases := []struct {
+		route
+		want map[auth.Role]int
+	}{
+		{
+			route{"read own wallet", "GET", readWallet(self), nil},
+			map[auth.Role]int{auth.RoleUser: ok, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: ok},
+		},
+		{
+			route{"read other wallet", "GET", readWallet(other), nil},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: ok},
+		},
+		{
+			// 每个用户每个币种只能有一个钱包，使用还没有钱包的币种
+			route{"create wallet", "POST", fixed("/userCurrency"), func(id uint) any {
+				return gin.H{"user_id": id, "currency_id": 2, "currency_num": 1}
+			}},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"update own wallet", "POST", fixed("/updateUserCurrency"), writeWallet(self)},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"update other wallet", "POST", fixed("/updateUserCurrency"), writeWallet(other)},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"add to own wallet", "POST", fixed("/addCurrencyNum"), writeWallet(self)},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"add to other wallet", "POST", fixed("/addCurrencyNum"), writeWallet(other)},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"subtract from own wallet", "POST", fixed("/subtractCurrencyNum"), writeWallet(self)},
+			map[auth.Role]int{auth.RoleUser: ok, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+		{
+			route{"subtract from other wallet", "POST", fixed("/subtractCurrencyNum"), writeWallet(other)},
+			map[auth.Role]int{auth.RoleUser: forbidden, auth.RoleOperator: ok, auth.RoleAdmin: ok, auth.RoleAuditor: forbidden},
+		},
+	}
+
+	for _, tc := range cases {
+		for _, role := range auth.Roles() {
+			userID := roleUsers[role]
+			t.Run(tc.name+"/"+string(role), func(t *testing.T) {
+				pair, err := tokens.IssueTokens(context.Background(), userID, role)
+				require.NoError(t, err)
+
+				var body any
+				if tc.body != nil {
+					body = tc.body(userID)
+				}
+				w := request(a, tc.method, tc.path(userID), pair.AccessToken, body)
+				assert.Equal(t, tc.want[role], w.Code, w.Body.String())
+			})
+		}
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		w := request(a, "POST", "/addCurrencyNum", "", wallet(otherUserID))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestRegisterReportsPasswordPolicyViolations(t *testing.T) {
+	a := newTestApp(t)
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "alice"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	var body struct {
+		Code       response.Code              `json:"code"`
+		Violations []security.PolicyViolation `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, response.ErrWeakPassword.Code, body.Code)
+	var rules []string
+	for _, v := range body.Violations {
+		rules = append(rules, v.Rule)
+	}
+	assert.Equal(t, []string{security.RuleMinLength, security.RuleUppercase, security.RuleNumber, security.RuleSpecial, security.RuleUsername}, rules)
+}
+
+func TestRegisterAlwaysCreatesUserRole(t *testing.T) {
+	a := newTestApp(t)
+
+	w := request(a, "POST", "/register", "", gin.H{"username": "mallory", "password": "Str0ng!Pass", "role": "admin"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var user models.User
+	require.NoError(t, a.DB.Where("username = ?", "mallory").First(&user).Error)
+	assert.Equal(t, string(auth.RoleUser), user.Role)
+
+	w = request(a, "POST", "/login", "", gin.H{"username": "mallory", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	decodeData(t, w, &resp)
+	claims, err := auth.ParseToken(resp.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, string(auth.RoleUser), claims.Role)
+
+	w = request(a, "POST", "/addCurrencyNum", resp.AccessToken, gin.H{"user_id": user.ID, "currency_id": 1, "currency_num": 1})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestRequestValidationAndResponseMapping(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	pair, err := tokens.IssueTokens(context.Background(), roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	operator := pair.AccessToken
+
+	fields := func(w *httptest.ResponseRecorder) map[string]string {
+		var body struct {
+			Code    response.Code         `json:"code"`
+			Details []response.FieldError `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+		assert.Equal(t, response.ErrValidationFailed.Code, body.Code)
+		rules := map[string]string{}
+		for _, f := range body.Details {
+			rules[f.Field] = f.Rule
+		}
+		return rules
+	}
+
+	// 每个未通过校验的字段都会列出
+	w := request(a, "POST", "/register", "", gin.H{"username": "al"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, map[string]string{"username": "min", "password": "required"}, fields(w))
+
+	// 请求先按接口文档校验，规则名与 binding 标签一致
+	w = request(a, "POST", "/addCurrencyNum", operator, gin.H{"user_id": otherUserID, "currency_num": 0})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, map[string]string{"currency_id": "required", "currency_num": "min"}, fields(w))
+
+	w = request(a, "POST", "/subtractCurrencyNum", operator, gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": -5})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, map[string]string{"currency_num": "min"}, fields(w))
+
+	w = request(a, "POST", "/subtractCurrencyNum", operator, gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": "5"})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, map[string]string{"currency_num": "type"}, fields(w))
+
+	// 客户端不能指定 ID 等内部字段
+	w = request(a, "POST", "/userCurrency", operator, gin.H{"ID": 999, "CreatedAt": "2000-01-01T00:00:00Z", "user_id": otherUserID, "currency_id": 2, "currency_num": 7})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var wallet models.UserCurrency
+	require.NoError(t, a.DB.Where("user_id = ? AND currency_id = ?", otherUserID, 2).First(&wallet).Error)
+	assert.NotEqual(t, uint(999), wallet.ID)
+	assert.True(t, wallet.CreatedAt.After(time.Now().Add(-time.Minute)))
+
+	// 响应只包含显式映射的字段
+	w = request(a, "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), operator, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var data map[string]any
+	decodeData(t, w, &data)
+	assert.ElementsMatch(t, []string{"user_id", "currency_id", "currency_num", "updated_at"}, keys(data))
+
+	w = request(a, "GET", fmt.Sprintf("/users/%d", otherUserID), operator, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	pair, err = tokens.IssueTokens(context.Background(), roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+	w = request(a, "GET", fmt.Sprintf("/users/%d", otherUserID), pair.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, strings.ToLower(w.Body.String()), "password")
+	assert.NotContains(t, w.Body.String(), "DeletedAt")
+}
+
+func keys(m map[string]any) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestAPIKeyRoutes(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	tokenFor := func(role auth.Role) string {
+		pair, err := tokens.IssueTokens(context.Background(), roleUsers[role], role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	createKey := func(scopes ...string) (string, uint) {
+		w := request(a, "POST", "/apiKeys", tokenFor(auth.RoleAdmin), gin.H{"name": "svc", "scopes": scopes})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var resp struct {
+			Key    string `json:"key"`
+			APIKey struct {
+				ID uint `json:"id"`
+			} `json:"api_key"`
+		}
+		decodeData(t, w, &resp)
+		return resp.Key, resp.APIKey.ID
+	}
+
+	// 只有管理员可以管理 API key
+	for _, role := range []auth.Role{auth.RoleUser, auth.RoleOperator, auth.RoleAuditor} {
+		w := request(a, "POST", "/apiKeys", tokenFor(role), gin.H{"name": "svc", "scopes": []string{"wallet:read"}})
+		assert.Equal(t, http.StatusForbidden, w.Code, role)
+	}
+	w := request(a, "POST", "/apiKeys", tokenFor(auth.RoleAdmin), gin.H{"name": "svc", "scopes": []string{"wallet:manage"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	creditKey, creditKeyID := createKey("wallet:read", "wallet:credit")
+	debitKey, _ := createKey("wallet:debit")
+	alertKey, _ := createKey("alerts:write")
+
+	walletBody := gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": 1}
+	cases := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"read wallet", creditKey, "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), nil, http.StatusOK},
+		{"credit wallet", creditKey, "POST", "/addCurrencyNum", walletBody, http.StatusOK},
+		{"debit without scope", creditKey, "POST", "/subtractCurrencyNum", walletBody, http.StatusForbidden},
+		{"update balance", creditKey, "POST", "/updateUserCurrency", walletBody, http.StatusForbidden},
+		{"manage api keys", creditKey, "GET", "/apiKeys", nil, http.StatusForbidden},
+		{"alerts without scope", creditKey, "POST", "/monitoring/webhook", gin.H{}, http.StatusForbidden},
+		{"logout", creditKey, "POST", "/logout", nil, http.StatusBadRequest},
+		{"debit wallet", debitKey, "POST", "/subtractCurrencyNum", walletBody, http.StatusOK},
+		{"read without scope", debitKey, "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), nil, http.StatusForbidden},
+		{"credit without scope", alertKey, "POST", "/addCurrencyNum", walletBody, http.StatusForbidden},
+		{"alerts", alertKey, "POST", "/monitoring/webhook", "not json", http.StatusBadRequest},
+		{"unknown key", "dak_unknown", "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), nil, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := apiKeyRequest(a, tc.method, tc.path, tc.key, tc.body)
+			assert.Equal(t, tc.want, w.Code, w.Body.String())
+		})
+	}
+
+	w = request(a, "DELETE", fmt.Sprintf("/apiKeys/%d", creditKeyID), tokenFor(auth.RoleAdmin), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = apiKeyRequest(a, "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), creditKey, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+// httptest 请求的对端地址为 192.0.2.1，X-Forwarded-For 只有来自可信代理时才生效
+func TestAPIKeyAllowedIPsIgnoreSpoofedForwardedFor(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		proxies []string
+		want    int
+	}{
+		{"untrusted client", nil, http.StatusForbidden},
+		{"trusted proxy", []string{"192.0.2.0/24"}, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApp(t, func(cfg *pkg.Config) {
+				cfg.Server.TrustedProxies = tc.proxies
+			})
+			pair, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+			require.NoError(t, err)
+			w := request(a, "POST", "/api/v1/api-keys", pair.AccessToken, gin.H{"name": "svc", "scopes": []string{"wallet:read"}, "allowed_ips": []string{"10.1.2.3"}})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			var resp struct {
+				Key string `json:"key"`
+			}
+			decodeData(t, w, &resp)
+
+			header := http.Header{}
+			header.Set(middleware.APIKeyHeader, resp.Key)
+			header.Set("X-Forwarded-For", "10.1.2.3")
+			w = serve(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets", otherUserID), header, nil)
+			assert.Equal(t, tc.want, w.Code, w.Body.String())
+			if tc.want == http.StatusForbidden {
+				assert.Equal(t, response.ErrIPNotAllowed.Code, decodeResponse(t, w).Code)
+			}
+		})
+	}
+}
+
+func TestAdminRoutesRequireClientCert(t *testing.T) {
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Server.TLS.ClientCAFile = "client-ca.pem"
+	})
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	admin, err := tokens.IssueTokens(context.Background(), roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+	auditor, err := tokens.IssueTokens(context.Background(), roleUsers[auth.RoleAuditor], auth.RoleAuditor)
+	require.NoError(t, err)
+
+	cases := []struct {
+		path  string
+		token string
+	}{
+		{"/api/v1/users", admin.AccessToken},
+		{"/api/v1/api-keys", admin.AccessToken},
+		{"/api/v1/audit-logs", auditor.AccessToken},
+		{"/users", admin.AccessToken},
+		{"/apiKeys", admin.AccessToken},
+		{"/audit", auditor.AccessToken},
+	}
+	for _, tc := range cases {
+		w := request(a, "GET", tc.path, tc.token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, tc.path)
+		assert.Equal(t, response.ErrClientCertRequired.Code, decodeResponse(t, w).Code, tc.path)
+	}
+
+	// 带上经过校验的客户端证书后放行
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "ops"}}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		w := httptest.NewRecorder()
+		a.Router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, tc.path+": "+w.Body.String())
+	}
+
+	// 非管理路由不要求客户端证书
+	w := request(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets", roleUsers[auth.RoleAdmin]), admin.AccessToken, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestJWKSEndpoint(t *testing.T) {
+	a := newTestApp(t)
+
+	w := request(a, "GET", "/.well-known/jwks.json", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var jwks auth.JWKS
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+	// 测试使用 HS256，不发布任何公钥
+	assert.NotNil(t, jwks.Keys)
+	assert.Empty(t, jwks.Keys)
+}
+
+func TestLoginLockout(t *testing.T) {
+	a := newTestApp(t)
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	lockoutsBefore := testutil.ToFloat64(metrics.AccountLockouts.WithLabelValues(auth.LockoutScopeUser))
+
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return request(a, "POST", "/login", "", gin.H{"username": username, "password": password})
+	}
+
+	// 存在和不存在的账号得到完全相同的响应
+	for _, username := range []string{"alice", "nobody"} {
+		// request_id 每次不同，只比较错误码和信息
+		var bodies []response.Response
+		for i := 0; i < 3; i++ {
+			w := login(username, "wrong")
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			bodies = append(bodies, decodeResponse(t, w))
+		}
+		w := login(username, "Str0ng!Pass")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, username)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		bodies = append(bodies, decodeResponse(t, w))
+		var got []string
+		for _, body := range bodies {
+			assert.NotEmpty(t, body.RequestID)
+			got = append(got, string(body.Code)+": "+body.Message)
+		}
+		assert.Equal(t, []string{
+			"INVALID_CREDENTIALS: Invalid username or password",
+			"INVALID_CREDENTIALS: Invalid username or password",
+			"INVALID_CREDENTIALS: Invalid username or password",
+			"LOGIN_THROTTLED: Too many failed login attempts, please try again later",
+		}, got, username)
+	}
+	assert.Equal(t, lockoutsBefore+2, testutil.ToFloat64(metrics.AccountLockouts.WithLabelValues(auth.LockoutScopeUser)))
+
+	var user models.User
+	require.NoError(t, a.DB.Where("username = ?", "alice").First(&user).Error)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	pair, err := tokens.IssueTokens(context.Background(), roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	w = request(a, "POST", fmt.Sprintf("/users/%d/unlock", user.ID), pair.AccessToken, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	pair, err = tokens.IssueTokens(context.Background(), roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+	w = request(a, "POST", fmt.Sprintf("/users/%d/unlock", user.ID), pair.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusOK, login("alice", "Str0ng!Pass").Code)
+
+	// 注册产生的 user.register 不在此检查
+	var entries []models.AuditLog
+	require.NoError(t, a.DB.Where("action LIKE ?", "auth.%").Order("id").Find(&entries).Error)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "auth.lockout", entries[0].Action)
+	assert.Equal(t, "user:alice", entries[0].Target)
+	assert.Equal(t, "anonymous", entries[0].Actor)
+	assert.Equal(t, "user:nobody", entries[1].Target)
+	assert.Equal(t, "auth.unlock", entries[2].Action)
+	assert.Equal(t, fmt.Sprintf("user:%d", roleUsers[auth.RoleAdmin]), entries[2].Actor)
+	assert.Equal(t, fmt.Sprintf("user:%d", user.ID), entries[2].Target)
+}
+
+func TestLoginLockoutIgnoresSpoofedForwardedFor(t *testing.T) {
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Security.Lockout = pkg.LockoutConfig{MaxFailures: 100, IPMaxFailures: 3, DelayAfter: 100}
+	})
+
+	// 每次换一个用户名和 X-Forwarded-For，IP 计数仍按连接地址累计
+	for i := 0; i < 3; i++ {
+		header := http.Header{}
+		header.Set("X-Forwarded-For", fmt.Sprintf("10.0.0.%d", i+1))
+		w := serve(a, "POST", "/login", header, gin.H{"username": fmt.Sprintf("guess%d", i), "password": "wrong"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+	header := http.Header{}
+	header.Set("X-Forwarded-For", "10.0.0.9")
+	w := serve(a, "POST", "/login", header, gin.H{"username": "guess9", "password": "wrong"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.Equal(t, response.ErrLoginThrottled.Code, decodeResponse(t, w).Code)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	a := newTestApp(t)
+	pkg.AppConfig.Security.MFA.RequiredRoles = []string{string(auth.RoleAdmin), string(auth.RoleOperator)}
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, a.DB.Model(&models.User{}).Where("username = ?", "alice").Update("role", string(auth.RoleAdmin)).Error)
+
+	decode := func(w *httptest.ResponseRecorder) map[string]any {
+		var body map[string]any
+		decodeData(t, w, &body)
+		return body
+	}
+	login := func() map[string]any {
+		w := request(a, "POST", "/login", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		body := decode(w)
+		assert.NotContains(t, body, "access_token")
+		return body
+	}
+
+	// 管理员未绑定验证器时必须先完成绑定，凭证不能用于访问业务接口
+	body := login()
+	assert.Equal(t, true, body["mfa_enrollment_required"])
+	enrollToken := body["mfa_token"].(string)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", "/userCurrency/1", enrollToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "POST", "/login/2fa", "", gin.H{"mfa_token": enrollToken, "code": "123456"}).Code)
+
+	w = request(a, "POST", "/login/2fa/enroll", "", gin.H{"mfa_token": enrollToken})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	body = decode(w)
+	secret := body["secret"].(string)
+	assert.Contains(t, body["provisioning_uri"], "otpauth://totp/")
+
+	w = request(a, "POST", "/login/2fa/enroll/verify", "", gin.H{"mfa_token": enrollToken, "code": "abcdef"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	code, err := security.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	w = request(a, "POST", "/login/2fa/enroll/verify", "", gin.H{"mfa_token": enrollToken, "code": code})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	body = decode(w)
+	assert.NotEmpty(t, body["access_token"])
+	require.Len(t, body["recovery_codes"], 10)
+	recoveryCode := body["recovery_codes"].([]any)[0].(string)
+	accessToken := body["access_token"].(string)
+
+	// 恢复码只保存哈希
+	var stored []models.RecoveryCode
+	require.NoError(t, a.DB.Find(&stored).Error)
+	require.Len(t, stored, 10)
+	assert.NotEqual(t, recoveryCode, stored[0].CodeHash)
+
+	// 绑定凭证只能使用一次
+	w = request(a, "POST", "/login/2fa/enroll/verify", "", gin.H{"mfa_token": enrollToken, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 已启用后登录需要提交验证码，同一验证码不能重复使用
+	body = login()
+	assert.Equal(t, true, body["mfa_required"])
+	mfaToken := body["mfa_token"].(string)
+	w = request(a, "POST", "/login/2fa", "", gin.H{"mfa_token": mfaToken, "code": code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(a, "POST", "/login/2fa", "", gin.H{"mfa_token": mfaToken, "recovery_code": recoveryCode})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEmpty(t, decode(w)["access_token"])
+
+	// 恢复码只能使用一次
+	w = request(a, "POST", "/login/2fa", "", gin.H{"mfa_token": login()["mfa_token"], "recovery_code": recoveryCode})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// 角色要求两步验证时不能关闭
+	w = request(a, "POST", "/2fa/disable", accessToken, gin.H{"code": code})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestMFAManagementCountsWrongCodes(t *testing.T) {
+	a := newTestApp(t)
+	self := roleUsers[auth.RoleUser]
+	secret, err := security.GenerateTOTPSecret()
+	require.NoError(t, err)
+	require.NoError(t, a.DB.Model(&models.User{}).Where("id = ?", self).Updates(map[string]interface{}{"totp_secret": secret, "totp_enabled": true}).Error)
+	pair, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), self, auth.RoleUser)
+	require.NoError(t, err)
+
+	// 持有 token 也不能无限次猜测验证码，错误次数与登录共用计数
+	w := request(a, "POST", "/api/v1/auth/2fa/recovery-codes", pair.AccessToken, gin.H{"code": "000000"})
+	assert.Equal(t, response.ErrInvalidTOTPCode.Code, decodeResponse(t, w).Code)
+	for i := 0; i < 2; i++ {
+		w = request(a, "POST", "/api/v1/auth/2fa/disable", pair.AccessToken, gin.H{"code": "000000"})
+		assert.Equal(t, response.ErrInvalidTOTPCode.Code, decodeResponse(t, w).Code)
+	}
+	code, err := security.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	w = request(a, "POST", "/api/v1/auth/2fa/disable", pair.AccessToken, gin.H{"code": code})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	w = request(a, "POST", "/api/v1/auth/2fa/recovery-codes", pair.AccessToken, gin.H{"code": code})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+
+	var user models.User
+	require.NoError(t, a.DB.First(&user, self).Error)
+	assert.True(t, user.TOTPEnabled)
+}
+
+func TestPasswordChangeAndReset(t *testing.T) {
+	messages := make(chan map[string]string, 1)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		json.NewDecoder(r.Body).Decode(&payload)
+		messages <- payload
+	}))
+	defer gateway.Close()
+
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Notification.UserWebhooks = []string{gateway.URL}
+		cfg.Security.PasswordReset.URL = "https://example.com/reset"
+	})
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	login := func(password string) *httptest.ResponseRecorder {
+		return request(a, "POST", "/login", "", gin.H{"username": "alice", "password": password})
+	}
+	decode := func(w *httptest.ResponseRecorder) handlers.LoginResponse {
+		var resp handlers.LoginResponse
+		decodeData(t, w, &resp)
+		return resp
+	}
+	w = login("Str0ng!Pass")
+	require.Equal(t, http.StatusOK, w.Code)
+	oldToken := decode(w).AccessToken
+
+	// 修改密码需要旧密码，新密码需满足强度要求，其他会话被注销
+	w = request(a, "POST", "/password/change", oldToken, gin.H{"old_password": "wrong", "new_password": "N3w!Password"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = request(a, "POST", "/password/change", oldToken, gin.H{"old_password": "Str0ng!Pass", "new_password": "weak"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(a, "POST", "/password/change", oldToken, gin.H{"old_password": "Str0ng!Pass", "new_password": "N3w!Password"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	newToken := decode(w).AccessToken
+	assert.Equal(t, http.StatusUnauthorized, request(a, "POST", "/logout", oldToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, login("Str0ng!Pass").Code)
+
+	// 不存在的账号得到相同的响应且不发送消息
+	w = request(a, "POST", "/password/reset", "", gin.H{"username": "nobody"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	nobodyBody := decodeResponse(t, w)
+	w = request(a, "POST", "/password/reset", "", gin.H{"username": "alice"})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	aliceBody := decodeResponse(t, w)
+	aliceBody.RequestID = nobodyBody.RequestID
+	assert.Equal(t, nobodyBody, aliceBody)
+
+	var message map[string]string
+	select {
+	case message = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("password reset message not delivered")
+	}
+	assert.Equal(t, "alice", message["user"])
+	require.Contains(t, message["text"], "https://example.com/reset?token=")
+	resetToken := message["text"][strings.Index(message["text"], "token=")+len("token="):]
+
+	confirm := func(password string) *httptest.ResponseRecorder {
+		return request(a, "POST", "/password/reset/confirm", "", gin.H{"token": resetToken, "new_password": password})
+	}
+	// 不满足强度要求时不消耗 token
+	assert.Equal(t, http.StatusBadRequest, confirm("weak").Code)
+	w = confirm("R3set!Password")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusBadRequest, confirm("An0ther!Password").Code)
+
+	// 重置后所有会话失效，只能用新密码登录
+	assert.Equal(t, http.StatusUnauthorized, request(a, "POST", "/logout", newToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, login("N3w!Password").Code)
+	assert.Equal(t, http.StatusOK, login("R3set!Password").Code)
+}
+
+func TestShutdownWaitsForPasswordResetDelivery(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+	}))
+	defer gateway.Close()
+
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Notification.UserWebhooks = []string{gateway.URL}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- a.Serve(ctx, listener)
+	}()
+	require.Eventually(t, a.Ready, time.Second, 10*time.Millisecond)
+
+	w := request(a, "POST", "/api/v1/auth/password/reset", "", gin.H{"username": "other"})
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	<-received
+
+	// 关闭时等待已开始的发送完成，而不是直接中断
+	cancel()
+	select {
+	case <-served:
+		t.Fatal("shutdown finished before password reset was delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	require.NoError(t, <-served)
+}
+
+func TestPasswordChangeCountsWrongOldPassword(t *testing.T) {
+	a := newTestApp(t)
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request(a, "POST", "/login", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var session handlers.LoginResponse
+	decodeData(t, w, &session)
+
+	// 旧密码错误与登录失败共用计数，达到上限后即使旧密码正确也被拒绝
+	change := func(oldPassword string) *httptest.ResponseRecorder {
+		return request(a, "POST", "/api/v1/auth/password/change", session.AccessToken, gin.H{"old_password": oldPassword, "new_password": "N3w!Password"})
+	}
+	for i := 0; i < 3; i++ {
+		w = change("wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, response.ErrWrongPassword.Code, decodeResponse(t, w).Code)
+	}
+	w = change("Str0ng!Pass")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// 密码没有被修改，登录同样被锁定
+	assert.Equal(t, http.StatusTooManyRequests, request(a, "POST", "/login", "", gin.H{"username": "alice", "password": "Str0ng!Pass"}).Code)
+	var lockouts int64
+	require.NoError(t, a.DB.Model(&models.AuditLog{}).Where("action = ? AND target = ?", "auth.lockout", "user:alice").Count(&lockouts).Error)
+	assert.Equal(t, int64(1), lockouts)
+}
+
+func TestLoginUpgradesPasswordHash(t *testing.T) {
+	a := newTestApp(t)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Str0ng!Pass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, a.DB.Create(&models.User{Username: "legacy", Password: string(legacy)}).Error)
+
+	w := request(a, "POST", "/login", "", gin.H{"username": "legacy", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var user models.User
+	require.NoError(t, a.DB.Where("username = ?", "legacy").First(&user).Error)
+	assert.True(t, strings.HasPrefix(user.Password, "$argon2id$"), user.Password)
+	assert.False(t, security.NeedsRehash(user.Password))
+
+	// 升级后的哈希可以正常登录
+	w = request(a, "POST", "/login", "", gin.H{"username": "legacy", "password": "Str0ng!Pass"})
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestUserAdministration(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	ctx := context.Background()
+	admin, err := tokens.IssueTokens(ctx, roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+	victim, err := tokens.IssueTokens(ctx, otherUserID, auth.RoleUser)
+	require.NoError(t, err)
+	path := func(format string) string { return fmt.Sprintf(format, otherUserID) }
+
+	// 只有管理员能访问
+	operator, err := tokens.IssueTokens(ctx, roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, request(a, "GET", "/users", operator.AccessToken, nil).Code)
+
+	var list handlers.UserListResponse
+	w := request(a, "GET", "/users?q=o&page_size=2", admin.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decodeData(t, w, &list)
+	// operator、auditor、other
+	assert.Equal(t, int64(3), list.Total)
+	assert.Len(t, list.Users, 2)
+	assert.NotContains(t, w.Body.String(), "password")
+	assert.Equal(t, http.StatusBadRequest, request(a, "GET", "/users?page_size=1000", admin.AccessToken, nil).Code)
+	// LIKE 通配符按字面匹配
+	w = request(a, "GET", "/users?q=%25", admin.AccessToken, nil)
+	decodeData(t, w, &list)
+	assert.Zero(t, list.Total)
+
+	var detail handlers.UserDetailResponse
+	w = request(a, "GET", path("/users/%d"), admin.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	decodeData(t, w, &detail)
+	assert.Equal(t, "other", detail.User.Username)
+	require.Len(t, detail.Wallets, 1)
+	assert.Equal(t, uint(100), detail.Wallets[0].CurrencyNum)
+
+	// 禁用后已签发的 token 立即失效，启用后需重新登录
+	assert.Equal(t, http.StatusOK, request(a, "GET", path("/userCurrency/%d"), victim.AccessToken, nil).Code)
+	w = request(a, "POST", path("/users/%d/disable"), admin.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path("/userCurrency/%d"), victim.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusConflict, request(a, "POST", path("/users/%d/disable"), admin.AccessToken, nil).Code)
+	w = request(a, "GET", "/users?status=disabled", admin.AccessToken, nil)
+	decodeData(t, w, &list)
+	require.Len(t, list.Users, 1)
+	assert.Equal(t, uint(otherUserID), list.Users[0].ID)
+
+	require.Equal(t, http.StatusOK, request(a, "POST", path("/users/%d/enable"), admin.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path("/userCurrency/%d"), victim.AccessToken, nil).Code)
+	relogin, err := tokens.IssueTokens(ctx, otherUserID, auth.RoleUser)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(a, "GET", path("/userCurrency/%d"), relogin.AccessToken, nil).Code)
+
+	// 软删除后不出现在默认列表中，可以恢复
+	require.Equal(t, http.StatusOK, request(a, "DELETE", path("/users/%d"), admin.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path("/userCurrency/%d"), relogin.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusNotFound, request(a, "POST", path("/users/%d/disable"), admin.AccessToken, nil).Code)
+	w = request(a, "GET", "/users?status=deleted", admin.AccessToken, nil)
+	decodeData(t, w, &list)
+	require.Len(t, list.Users, 1)
+	assert.Equal(t, handlers.UserStatusDeleted, list.Users[0].Status)
+	w = request(a, "GET", path("/users/%d"), admin.AccessToken, nil)
+	decodeData(t, w, &detail)
+	assert.Equal(t, handlers.UserStatusDeleted, detail.User.Status)
+	// 已删除用户的用户名不能重新注册
+	w = request(a, "POST", "/register", "", gin.H{"username": "other", "password": "Str0ng!Pass"})
+	assert.Equal(t, response.ErrUsernameTaken.Code, decodeResponse(t, w).Code)
+
+	w = request(a, "POST", path("/users/%d/restore"), admin.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, http.StatusConflict, request(a, "POST", path("/users/%d/restore"), admin.AccessToken, nil).Code)
+	w = request(a, "GET", "/users", admin.AccessToken, nil)
+	decodeData(t, w, &list)
+	assert.Equal(t, int64(5), list.Total)
+
+	// 不能禁用或删除自己
+	self := fmt.Sprintf("/users/%d", roleUsers[auth.RoleAdmin])
+	assert.Equal(t, http.StatusBadRequest, request(a, "POST", self+"/disable", admin.AccessToken, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, request(a, "DELETE", self, admin.AccessToken, nil).Code)
+
+	// 每个操作都记录了执行的管理员
+	var entries []models.AuditLog
+	require.NoError(t, a.DB.Order("id").Find(&entries).Error)
+	var actions []string
+	for _, entry := range entries {
+		actions = append(actions, entry.Action)
+		assert.Equal(t, fmt.Sprintf("user:%d", roleUsers[auth.RoleAdmin]), entry.Actor)
+		assert.Equal(t, fmt.Sprintf("user:%d", otherUserID), entry.Target)
+	}
+	assert.Equal(t, []string{"user.disable", "user.enable", "user.delete", "user.restore"}, actions)
+}
+
+func TestDeleteUserFailsWhenSessionsCannotBeRevoked(t *testing.T) {
+	a := newTestApp(t)
+	admin, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+
+	// token 版本不是整数时 INCR 失败，会话无法注销，用户不能被删除
+	require.NoError(t, a.Redis.Set(context.Background(), fmt.Sprintf("user_token_version:%d", otherUserID), "x", 0).Err())
+	w := request(a, "DELETE", fmt.Sprintf("/api/v1/users/%d", otherUserID), admin.AccessToken, nil)
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	var user models.User
+	assert.NoError(t, a.DB.First(&user, otherUserID).Error)
+}
+
+func TestSetUserRole(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	ctx := context.Background()
+	admin, err := tokens.IssueTokens(ctx, roleUsers[auth.RoleAdmin], auth.RoleAdmin)
+	require.NoError(t, err)
+	operator, err := tokens.IssueTokens(ctx, roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	victim, err := tokens.IssueTokens(ctx, otherUserID, auth.RoleUser)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/api/v1/users/%d/role", otherUserID)
+
+	// 只有管理员能分配角色，旧路由没有该接口
+	assert.Equal(t, http.StatusForbidden, request(a, "PUT", path, operator.AccessToken, gin.H{"role": "admin"}).Code)
+	assert.Equal(t, http.StatusNotFound, request(a, "PUT", fmt.Sprintf("/users/%d/role", otherUserID), admin.AccessToken, gin.H{"role": "admin"}).Code)
+
+	w := request(a, "PUT", path, admin.AccessToken, gin.H{"role": "superuser"})
+	assert.Equal(t, response.ErrUnknownRole.Code, decodeResponse(t, w).Code)
+	assert.Equal(t, http.StatusBadRequest, request(a, "PUT", path, admin.AccessToken, gin.H{}).Code)
+	self := fmt.Sprintf("/api/v1/users/%d/role", roleUsers[auth.RoleAdmin])
+	w = request(a, "PUT", self, admin.AccessToken, gin.H{"role": "user"})
+	assert.Equal(t, response.ErrCannotModifySelf.Code, decodeResponse(t, w).Code)
+
+	w = request(a, "PUT", path, admin.AccessToken, gin.H{"role": "operator"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var summary handlers.UserSummary
+	decodeData(t, w, &summary)
+	assert.Equal(t, "operator", summary.Role)
+	var user models.User
+	require.NoError(t, a.DB.First(&user, otherUserID).Error)
+	assert.Equal(t, "operator", user.Role)
+
+	// 旧 token 中的角色不再有效
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets", otherUserID), victim.AccessToken, nil).Code)
+
+	var entry models.AuditLog
+	require.NoError(t, a.DB.Where("action = ?", "user.role_change").First(&entry).Error)
+	assert.Equal(t, fmt.Sprintf("user:%d", roleUsers[auth.RoleAdmin]), entry.Actor)
+	assert.Contains(t, entry.Before, `"role":"user"`)
+	assert.Contains(t, entry.After, `"role":"operator"`)
+
+	// 会话无法注销时角色不变
+	require.NoError(t, a.Redis.Set(ctx, fmt.Sprintf("user_token_version:%d", otherUserID), "x", 0).Err())
+	w = request(a, "PUT", path, admin.AccessToken, gin.H{"role": "auditor"})
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	require.NoError(t, a.DB.First(&user, otherUserID).Error)
+	assert.Equal(t, "operator", user.Role)
+}
+
+func TestEnsureBootstrapAdmin(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	cfg := pkg.BootstrapAdminConfig{Username: "root", Password: "Str0ng!Pass"}
+	countUsers := func(username string) int64 {
+		var n int64
+		require.NoError(t, a.DB.Model(&models.User{}).Where("username = ?", username).Count(&n).Error)
+		return n
+	}
+
+	// 已有管理员时不做修改
+	require.NoError(t, handlers.EnsureBootstrapAdmin(ctx, a.DB, cfg))
+	assert.Zero(t, countUsers("root"))
+
+	// 管理员被禁用后没有可用的管理员，密码不符合策略时启动失败
+	require.NoError(t, a.DB.Model(&models.User{}).Where("id = ?", roleUsers[auth.RoleAdmin]).Update("disabled_at", time.Now()).Error)
+	assert.Error(t, handlers.EnsureBootstrapAdmin(ctx, a.DB, pkg.BootstrapAdminConfig{Username: "root", Password: "weak"}))
+	assert.Zero(t, countUsers("root"))
+
+	require.NoError(t, handlers.EnsureBootstrapAdmin(ctx, a.DB, cfg))
+	var root models.User
+	require.NoError(t, a.DB.Where("username = ?", "root").First(&root).Error)
+	assert.Equal(t, string(auth.RoleAdmin), root.Role)
+	assert.True(t, security.CheckPasswordHash(cfg.Password, root.Password))
+	w := request(a, "POST", "/api/v1/auth/login", "", gin.H{"username": "root", "password": cfg.Password})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// 再次启动不会重复创建
+	require.NoError(t, handlers.EnsureBootstrapAdmin(ctx, a.DB, cfg))
+	assert.Equal(t, int64(1), countUsers("root"))
+
+	// 已存在的用户被提升为管理员，密码不变
+	require.NoError(t, a.DB.Model(&root).Update("disabled_at", time.Now()).Error)
+	require.NoError(t, handlers.EnsureBootstrapAdmin(ctx, a.DB, pkg.BootstrapAdminConfig{Username: "other"}))
+	var other models.User
+	require.NoError(t, a.DB.First(&other, otherUserID).Error)
+	assert.Equal(t, string(auth.RoleAdmin), other.Role)
+	assert.Equal(t, "x", other.Password)
+
+	var entries []models.AuditLog
+	require.NoError(t, a.DB.Where("action = ?", "user.bootstrap_admin").Order("id").Find(&entries).Error)
+	require.Len(t, entries, 2)
+	assert.Equal(t, audit.ActorSystem, entries[0].Actor)
+	assert.Equal(t, fmt.Sprintf("user:%d", root.ID), entries[0].Target)
+	assert.Equal(t, fmt.Sprintf("user:%d", otherUserID), entries[1].Target)
+}
+
+func TestLoginRejectsDisabledUser(t *testing.T) {
+	a := newTestApp(t)
+	w := request(a, "POST", "/register", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.NoError(t, a.DB.Model(&models.User{}).Where("username = ?", "alice").Update("disabled_at", time.Now()).Error)
+
+	w = request(a, "POST", "/login", "", gin.H{"username": "alice", "password": "Str0ng!Pass"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuditLogQueryAndExport(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	issue := func(role auth.Role) string {
+		pair, err := tokens.IssueTokens(context.Background(), roleUsers[role], role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	operator, auditor, admin := issue(auth.RoleOperator), issue(auth.RoleAuditor), issue(auth.RoleAdmin)
+
+	wallet := gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": 500}
+	require.Equal(t, http.StatusOK, request(a, "POST", "/updateUserCurrency", operator, wallet).Code)
+	wallet["currency_num"] = 20
+	require.Equal(t, http.StatusOK, request(a, "POST", "/subtractCurrencyNum", operator, wallet).Code)
+
+	// 只有审计员能查询，管理员的操作同样被审计
+	assert.Equal(t, http.StatusForbidden, request(a, "GET", "/audit", admin, nil).Code)
+	assert.Equal(t, http.StatusForbidden, request(a, "GET", "/audit/export", operator, nil).Code)
+
+	w := request(a, "GET", "/audit?action=wallet.update", auditor, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var list handlers.AuditLogListResponse
+	decodeData(t, w, &list)
+	require.Equal(t, int64(1), list.Total)
+	entry := list.Entries[0]
+	assert.Equal(t, fmt.Sprintf("user:%d", roleUsers[auth.RoleOperator]), entry.Actor)
+	assert.Equal(t, fmt.Sprintf("user:%d", otherUserID), entry.Target)
+	assert.JSONEq(t, `{"currency_id":1,"currency_num":100}`, entry.Before)
+	assert.JSONEq(t, `{"currency_id":1,"currency_num":500}`, entry.After)
+	assert.NotEmpty(t, entry.RequestID)
+	assert.NotEmpty(t, entry.Hash)
+
+	assert.Equal(t, http.StatusBadRequest, request(a, "GET", "/audit?from=yesterday", auditor, nil).Code)
+
+	w = request(a, "GET", "/audit/export?format=csv&target="+fmt.Sprintf("user:%d", otherUserID), auditor, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[1], "wallet.update")
+	assert.Contains(t, lines[2], "wallet.debit")
+
+	// 导出本身也被记录，并且整条链可以校验通过
+	w = request(a, "GET", "/audit/export", auditor, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var exported []models.AuditLog
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var log models.AuditLog
+		require.NoError(t, json.Unmarshal([]byte(line), &log))
+		exported = append(exported, log)
+	}
+	require.Len(t, exported, 4)
+	assert.Equal(t, "audit.export", exported[2].Action)
+	assert.Equal(t, exported[2].Hash, exported[3].PrevHash)
+
+	result, err := audit.Verify(context.Background(), a.DB)
+	require.NoError(t, err)
+	assert.True(t, result.OK(), result.Problems)
+	assert.Equal(t, exported[3].Hash, result.Head)
+}
+
+func TestAuditWriteFailureRollsBackChange(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	pair, err := tokens.IssueTokens(context.Background(), roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	operator := pair.AccessToken
+	balance := func() uint {
+		var wallet models.UserCurrency
+		require.NoError(t, a.DB.Where("user_id = ? AND currency_id = 1", otherUserID).First(&wallet).Error)
+		return wallet.CurrencyNum
+	}
+
+	// 审计日志写不进去时变更一起回滚，请求失败
+	require.NoError(t, a.DB.Migrator().DropTable(&models.AuditLog{}))
+
+	w := request(a, "POST", "/api/v1/transactions", operator, gin.H{"user_id": otherUserID, "currency_id": 1, "type": "credit", "amount": 5})
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	w = request(a, "POST", "/updateUserCurrency", operator, gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": 500})
+	assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+	assert.Equal(t, uint(100), balance())
+}
+
+func TestLegacyUpdateUserCurrency(t *testing.T) {
+	a := newTestApp(t)
+	pair, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), roleUsers[auth.RoleOperator], auth.RoleOperator)
+	require.NoError(t, err)
+	operator := pair.AccessToken
+
+	// 余额可以清零
+	w := request(a, "POST", "/updateUserCurrency", operator, gin.H{"user_id": otherUserID, "currency_id": 1, "currency_num": 0})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var wallet models.UserCurrency
+	require.NoError(t, a.DB.Where("user_id = ? AND currency_id = ?", otherUserID, 1).First(&wallet).Error)
+	assert.Equal(t, uint(0), wallet.CurrencyNum)
+
+	// 与 v1 接口相同，钱包不存在时返回 WALLET_NOT_FOUND，不写审计日志
+	w = request(a, "POST", "/updateUserCurrency", operator, gin.H{"user_id": otherUserID, "currency_id": 9, "currency_num": 5})
+	assert.Equal(t, response.ErrWalletNotFound.Code, decodeResponse(t, w).Code)
+
+	var logs []models.AuditLog
+	require.NoError(t, a.DB.Where("action = ?", "wallet.update").Find(&logs).Error)
+	require.Len(t, logs, 1)
+	assert.JSONEq(t, `{"currency_id":1,"currency_num":100}`, logs[0].Before)
+	assert.JSONEq(t, `{"currency_id":1,"currency_num":0}`, logs[0].After)
+}
+
+func TestRateLimitPerClientAndRoute(t *testing.T) {
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Security.RateLimit = pkg.RateLimitConfig{
+			RequestsPerSecond: 1,
+			Burst:             3,
+			Routes: []pkg.RouteRateLimit{
+				{Method: "POST", Path: "/login", RequestsPerSecond: 0.1, Burst: 2},
+			},
+			IP: pkg.IPRateLimit{RequestsPerSecond: 100, Burst: 100},
+		}
+	})
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	issue := func(userID uint) string {
+		pair, err := tokens.IssueTokens(context.Background(), userID, auth.RoleUser)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	self, other := issue(roleUsers[auth.RoleUser]), issue(otherUserID)
+	path := fmt.Sprintf("/userCurrency/%d", roleUsers[auth.RoleUser])
+
+	for i := 0; i < 3; i++ {
+		w := request(a, "GET", path, self, nil)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "3", w.Header().Get(middleware.RateLimitLimitHeader))
+		assert.Equal(t, fmt.Sprint(2-i), w.Header().Get(middleware.RateLimitRemainingHeader))
+	}
+	w := request(a, "GET", path, self, nil)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get(middleware.RetryAfterHeader))
+	assert.Equal(t, "3", w.Header().Get(middleware.RateLimitResetHeader))
+
+	// 同一 IP 的其他用户单独计数
+	w = request(a, "GET", fmt.Sprintf("/userCurrency/%d", otherUserID), other, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// 单独配置的路由按 IP 计数，不占用默认策略
+	login := gin.H{"username": "nobody", "password": "x"}
+	for i := 0; i < 2; i++ {
+		w = request(a, "POST", "/login", "", login)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "2", w.Header().Get(middleware.RateLimitLimitHeader))
+	}
+	w = request(a, "POST", "/login", "", login)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get(middleware.RetryAfterHeader))
+
+	// 健康检查不限流
+	assert.Empty(t, request(a, "GET", "/health", "", nil).Header().Get(middleware.RateLimitLimitHeader))
+}
+
+func TestRateLimitBeforeAuthentication(t *testing.T) {
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Security.RateLimit = pkg.RateLimitConfig{
+			RequestsPerSecond: 100,
+			Burst:             100,
+			Routes: []pkg.RouteRateLimit{
+				{Method: "POST", Path: "/api/v1/auth/login", RequestsPerSecond: 0.1, Burst: 2},
+			},
+			IP: pkg.IPRateLimit{RequestsPerSecond: 0.1, Burst: 3},
+		}
+	})
+	pair, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), roleUsers[auth.RoleUser], auth.RoleUser)
+	require.NoError(t, err)
+	path := fmt.Sprintf("/api/v1/users/%d/wallets", roleUsers[auth.RoleUser])
+
+	// 错误的 token 和 API key 同样计入 IP 的限额
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path, "invalid", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, apiKeyRequest(a, "GET", path, "dak_guess", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path, "", nil).Code)
+	w := request(a, "GET", path, "invalid", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "10", w.Header().Get(middleware.RetryAfterHeader))
+	assert.Equal(t, http.StatusTooManyRequests, request(a, "GET", path, pair.AccessToken, nil).Code)
+
+	// 不可信的 X-Forwarded-For 不会改变计数使用的 IP
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+pair.AccessToken)
+	header.Set("X-Forwarded-For", "203.0.113.7")
+	assert.Equal(t, http.StatusTooManyRequests, serve(a, "GET", path, header, nil).Code)
+
+	login := gin.H{"username": "nobody", "password": "x"}
+	for i := 0; i < 3; i++ {
+		header := http.Header{}
+		header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+		w = serve(a, "POST", "/api/v1/auth/login", header, login)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
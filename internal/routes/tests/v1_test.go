@@ -0,0 +1,414 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/docs"
+	"github.com/kakaluote000/demo-api/internal/handlers"
+	"github.com/kakaluote000/demo-api/internal/ledger"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/internal/routes"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV1WalletRoutes(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	tokenFor := func(role auth.Role) string {
+		pair, err := tokens.IssueTokens(context.Background(), roleUsers[role], role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	user, operator := tokenFor(auth.RoleUser), tokenFor(auth.RoleOperator)
+	self := roleUsers[auth.RoleUser]
+
+	var wallets []handlers.WalletResponse
+	w := request(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets", self), user, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decodeData(t, w, &wallets)
+	require.Len(t, wallets, 1)
+	assert.Equal(t, uint(100), wallets[0].CurrencyNum)
+
+	// 普通用户不能查看他人的钱包
+	w = request(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets/1", otherUserID), user, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(a, "GET", fmt.Sprintf("/api/v1/users/%d/wallets/2", self), user, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = request(a, "GET", "/api/v1/users/abc/wallets/1", user, nil)
+	assert.Equal(t, response.ErrValidationFailed.Code, decodeResponse(t, w).Code)
+
+	// 创建钱包仅限运营和管理员，同一币种只能有一个钱包
+	path := fmt.Sprintf("/api/v1/users/%d/wallets", self)
+	assert.Equal(t, http.StatusForbidden, request(a, "POST", path, user, gin.H{"currency_id": 2}).Code)
+	w = request(a, "POST", path, operator, gin.H{"currency_id": 2, "currency_num": 5})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var wallet handlers.WalletResponse
+	decodeData(t, w, &wallet)
+	assert.Equal(t, handlers.WalletResponse{UserID: self, CurrencyID: 2, CurrencyNum: 5, UpdatedAt: wallet.UpdatedAt}, wallet)
+	w = request(a, "POST", path, operator, gin.H{"currency_id": 2})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, response.ErrWalletExists.Code, decodeResponse(t, w).Code)
+	// 唯一索引保证并发创建时也只有一个钱包，旧路由同样返回冲突
+	err := a.DB.Create(&models.UserCurrency{UserID: self, CurrencyID: 2}).Error
+	assert.True(t, pkg.IsDuplicateKey(a.DB, err), "%v", err)
+	w = request(a, "POST", "/userCurrency", operator, gin.H{"user_id": self, "currency_id": 2})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, response.ErrWalletExists.Code, decodeResponse(t, w).Code)
+	w = request(a, "POST", "/api/v1/users/404/wallets", operator, gin.H{"currency_id": 1})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// 直接设置余额
+	w = request(a, "PUT", path+"/2", operator, gin.H{"currency_num": 42})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decodeData(t, w, &wallet)
+	assert.Equal(t, uint(42), wallet.CurrencyNum)
+	assert.Equal(t, http.StatusForbidden, request(a, "PUT", path+"/2", user, gin.H{"currency_num": 1}).Code)
+	assert.Equal(t, http.StatusNotFound, request(a, "PUT", path+"/3", operator, gin.H{"currency_num": 1}).Code)
+
+	w = request(a, "GET", path+"/2", user, nil)
+	decodeData(t, w, &wallet)
+	assert.Equal(t, uint(42), wallet.CurrencyNum)
+}
+
+func TestV1TransactionsAndTransfers(t *testing.T) {
+	a := newTestApp(t)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	tokenFor := func(role auth.Role) string {
+		pair, err := tokens.IssueTokens(context.Background(), roleUsers[role], role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	user, operator, auditor := tokenFor(auth.RoleUser), tokenFor(auth.RoleOperator), tokenFor(auth.RoleAuditor)
+	self := roleUsers[auth.RoleUser]
+	transaction := func(userID uint, txType string, amount uint) gin.H {
+		return gin.H{"user_id": userID, "currency_id": 1, "type": txType, "amount": amount}
+	}
+	balance := func(userID uint) uint {
+		var wallet models.UserCurrency
+		require.NoError(t, a.DB.Where("user_id = ? AND currency_id = 1", userID).First(&wallet).Error)
+		return wallet.CurrencyNum
+	}
+
+	// 普通用户只能扣减自己的钱包，不能发放
+	w := request(a, "POST", "/api/v1/transactions", user, transaction(self, "debit", 30))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var result handlers.TransactionResultResponse
+	decodeData(t, w, &result)
+	assert.Equal(t, handlers.TransactionDebit, result.Type)
+	assert.Equal(t, uint(30), result.Amount)
+	assert.Equal(t, uint(70), result.Balance)
+	assert.Equal(t, http.StatusForbidden, request(a, "POST", "/api/v1/transactions", user, transaction(self, "credit", 1)).Code)
+	assert.Equal(t, http.StatusForbidden, request(a, "POST", "/api/v1/transactions", user, transaction(otherUserID, "debit", 1)).Code)
+	assert.Equal(t, http.StatusForbidden, request(a, "POST", "/api/v1/transactions", auditor, transaction(self, "debit", 1)).Code)
+
+	w = request(a, "POST", "/api/v1/transactions", operator, transaction(self, "credit", 5))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	decodeData(t, w, &result)
+	assert.Equal(t, uint(75), result.Balance)
+
+	before := testutil.ToFloat64(metrics.InsufficientFunds.WithLabelValues("1"))
+	w = request(a, "POST", "/api/v1/transactions", user, transaction(self, "debit", 76))
+	assert.Equal(t, response.ErrInsufficientFunds.Code, decodeResponse(t, w).Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.InsufficientFunds.WithLabelValues("1")))
+	assert.Equal(t, uint(75), balance(self))
+
+	w = request(a, "POST", "/api/v1/transactions", user, gin.H{"user_id": self, "currency_id": 1, "type": "refund", "amount": 1})
+	assert.Equal(t, response.ErrValidationFailed.Code, decodeResponse(t, w).Code)
+
+	// 转账两边的余额一起变更
+	transfer := func(from, to, amount uint) gin.H {
+		return gin.H{"from_user_id": from, "to_user_id": to, "currency_id": 1, "amount": amount}
+	}
+	w = request(a, "POST", "/api/v1/transfers", user, transfer(self, otherUserID, 25))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var transferred handlers.TransferResponse
+	decodeData(t, w, &transferred)
+	assert.Equal(t, uint(50), transferred.Balance)
+	assert.Equal(t, uint(50), balance(self))
+	assert.Equal(t, uint(125), balance(otherUserID))
+
+	// 余额不足或收款方没有钱包时整笔回滚
+	w = request(a, "POST", "/api/v1/transfers", user, transfer(self, otherUserID, 51))
+	assert.Equal(t, response.ErrInsufficientFunds.Code, decodeResponse(t, w).Code)
+	require.NoError(t, a.DB.Where("user_id = ?", otherUserID).Delete(&models.UserCurrency{}).Error)
+	w = request(a, "POST", "/api/v1/transfers", user, transfer(self, otherUserID, 10))
+	assert.Equal(t, response.ErrWalletNotFound.Code, decodeResponse(t, w).Code)
+	assert.Equal(t, uint(50), balance(self))
+
+	assert.Equal(t, http.StatusForbidden, request(a, "POST", "/api/v1/transfers", user, transfer(otherUserID, self, 1)).Code)
+	w = request(a, "POST", "/api/v1/transfers", user, transfer(self, self, 1))
+	assert.Equal(t, response.ErrValidationFailed.Code, decodeResponse(t, w).Code)
+	w = request(a, "POST", "/api/v1/transfers", operator, transfer(self, 404, 1))
+	assert.Equal(t, response.ErrUserNotFound.Code, decodeResponse(t, w).Code)
+
+	var page handlers.TransactionListResponse
+	w = request(a, "GET", fmt.Sprintf("/api/v1/users/%d/transactions?page_size=2", self), user, nil)
+	decodeData(t, w, &page)
+	assert.Equal(t, int64(3), page.Total)
+	require.Len(t, page.Transactions, 2)
+	assert.Equal(t, handlers.TransactionDebit, page.Transactions[0].Type)
+	assert.Equal(t, uint(25), page.Transactions[0].Amount)
+	assert.Equal(t, handlers.TransactionCredit, page.Transactions[1].Type)
+	assert.Equal(t, http.StatusForbidden, request(a, "GET", fmt.Sprintf("/api/v1/users/%d/transactions", otherUserID), user, nil).Code)
+
+	var logs []models.AuditLog
+	require.NoError(t, a.DB.Where("action = ?", "wallet.transfer").Find(&logs).Error)
+	assert.Len(t, logs, 1)
+}
+
+func TestV1BalanceEventStream(t *testing.T) {
+	a := newTestApp(t, func(cfg *pkg.Config) {
+		cfg.Server.Events.Heartbeat = 50 * time.Millisecond
+	})
+	server := httptest.NewServer(a.Router)
+	t.Cleanup(server.Close)
+	tokens := auth.NewTokenStore(a.Redis, a.DB)
+	tokenFor := func(role auth.Role) string {
+		pair, err := tokens.IssueTokens(context.Background(), roleUsers[role], role)
+		require.NoError(t, err)
+		return pair.AccessToken
+	}
+	user, operator := tokenFor(auth.RoleUser), tokenFor(auth.RoleOperator)
+	self := roleUsers[auth.RoleUser]
+	path := fmt.Sprintf("/api/v1/users/%d/balance-events", self)
+	transaction := func(txType string, amount uint) {
+		w := request(a, "POST", "/api/v1/transactions", operator, gin.H{"user_id": self, "currency_id": 1, "type": txType, "amount": amount})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	// 普通用户不能订阅他人的钱包
+	w := request(a, "GET", fmt.Sprintf("/api/v1/users/%d/balance-events", otherUserID), user, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, request(a, "GET", path, "", nil).Code)
+
+	events, cancel := openEventStream(t, server.URL+path, user, "")
+	assert.Equal(t, "heartbeat", nextEvent(t, events).name)
+
+	// 其他请求产生的变更实时推送
+	transaction("credit", 5)
+	credit := nextBalanceEvent(t, events)
+	assert.NotEmpty(t, credit.id)
+	assert.Equal(t, "balance", credit.name)
+	assert.Equal(t, ledger.EventCredit, credit.event.Type)
+	assert.Equal(t, uint(5), credit.event.Amount)
+	assert.Equal(t, uint(105), credit.event.Balance)
+	assert.Equal(t, credit.id, credit.event.ID)
+	cancel()
+
+	// 断线期间的变更在重连时按 Last-Event-ID 补发
+	transaction("debit", 10)
+	transaction("credit", 1)
+	events, cancel = openEventStream(t, server.URL+path, user, credit.id)
+	debit := nextBalanceEvent(t, events)
+	assert.Equal(t, ledger.EventDebit, debit.event.Type)
+	assert.Equal(t, uint(95), debit.event.Balance)
+	assert.Equal(t, uint(96), nextBalanceEvent(t, events).event.Balance)
+	transaction("credit", 4)
+	assert.Equal(t, uint(100), nextBalanceEvent(t, events).event.Balance)
+	cancel()
+
+	// EventSource 首次连接时通过参数传入；历史中没有的事件 ID 无法恢复，需要重新查询余额
+	events, cancel = openEventStream(t, server.URL+path+"?last_event_id=1-0", user, "")
+	reset := nextBalanceEvent(t, events)
+	assert.Equal(t, ledger.EventReset, reset.name)
+	assert.Equal(t, self, reset.event.UserID)
+	cancel()
+}
+
+type sseEvent struct {
+	id, name, data string
+}
+
+type balanceStreamEvent struct {
+	sseEvent
+	event ledger.BalanceEvent
+}
+
+// openEventStream 打开 SSE 连接，收到 retry 字段时订阅已经生效
+func openEventStream(t *testing.T, url, token, lastEventID string) (<-chan sseEvent, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	t.Cleanup(cancel)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan sseEvent, 16)
+	ready := make(chan struct{})
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			field, value, _ := strings.Cut(scanner.Text(), ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "":
+				if event.name != "" {
+					events <- event
+				}
+				event = sseEvent{}
+			case "retry":
+				close(ready)
+			case "id":
+				event.id = value
+			case "event":
+				event.name = value
+			case "data":
+				event.data = value
+			}
+		}
+	}()
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+		t.Fatal("event stream not ready")
+	}
+	return events, cancel
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "event stream closed")
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return sseEvent{}
+	}
+}
+
+// nextBalanceEvent 跳过心跳，返回下一个余额事件
+func nextBalanceEvent(t *testing.T, events <-chan sseEvent) balanceStreamEvent {
+	t.Helper()
+	for {
+		event := nextEvent(t, events)
+		if event.name == "heartbeat" {
+			continue
+		}
+		result := balanceStreamEvent{sseEvent: event}
+		require.NoError(t, json.Unmarshal([]byte(event.data), &result.event), event.data)
+		return result
+	}
+}
+
+func TestLegacyRoutesAreDeprecated(t *testing.T) {
+	a := newTestApp(t)
+	pair, err := auth.NewTokenStore(a.Redis, a.DB).IssueTokens(context.Background(), roleUsers[auth.RoleUser], auth.RoleUser)
+	require.NoError(t, err)
+	sunset := routes.LegacySunset.Format(http.TimeFormat)
+
+	before := testutil.ToFloat64(metrics.DeprecatedRequests.WithLabelValues("GET", "/userCurrency/:id"))
+	w := request(a, "GET", "/userCurrency/1", pair.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Equal(t, sunset, w.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v1/users/1/wallets>; rel="successor-version"`, w.Header().Get("Link"))
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.DeprecatedRequests.WithLabelValues("GET", "/userCurrency/:id")))
+
+	// 认证失败的响应也带废弃标记
+	w = request(a, "POST", "/addCurrencyNum", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, sunset, w.Header().Get("Sunset"))
+	assert.Equal(t, `</api/v1/transactions>; rel="successor-version"`, w.Header().Get("Link"))
+
+	// 新路由的参数在请求体中时不返回 Link
+	w = request(a, "POST", "/updateUserCurrency", pair.AccessToken, nil)
+	assert.Equal(t, "true", w.Header().Get("Deprecation"))
+	assert.Empty(t, w.Header().Get("Link"))
+
+	for _, path := range []string{"/api/v1/users/1/wallets", "/health"} {
+		w = request(a, "GET", path, pair.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Empty(t, w.Header().Get("Deprecation"), path)
+		assert.Empty(t, w.Header().Get("Sunset"), path)
+	}
+}
+
+// 文档中的接口和实际注册的路由一致：除 /metrics 外的路由都有文档，文档中的接口都已注册。
+// 请求校验依赖文档，没有文档的路由不会被校验
+func TestSwaggerDocsMatchRoutes(t *testing.T) {
+	a := newTestApp(t)
+	// 这些路由不返回 JSON，不需要文档
+	exempt := map[string]bool{"GET /metrics": true}
+
+	var spec struct {
+		Paths map[string]map[string]struct {
+			Deprecated bool `json:"deprecated"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &spec))
+	documented := map[string]bool{}
+	for path, operations := range spec.Paths {
+		for method, operation := range operations {
+			key := strings.ToUpper(method) + " " + path
+			documented[key] = true
+			// 旧路由在文档中标记为废弃
+			if isLegacyPath(path) {
+				assert.True(t, operation.Deprecated, key)
+			}
+		}
+	}
+
+	registered := map[string]bool{}
+	var undocumented []string
+	for _, route := range a.Router.Routes() {
+		segments := strings.Split(route.Path, "/")
+		for i, segment := range segments {
+			if strings.HasPrefix(segment, ":") {
+				segments[i] = "{" + segment[1:] + "}"
+			}
+		}
+		key := route.Method + " " + strings.Join(segments, "/")
+		registered[key] = true
+		if !documented[key] && !exempt[key] {
+			undocumented = append(undocumented, key)
+		}
+	}
+	var unregistered []string
+	for key := range documented {
+		if !registered[key] {
+			unregistered = append(unregistered, key)
+		}
+	}
+	sort.Strings(undocumented)
+	sort.Strings(unregistered)
+	assert.Empty(t, undocumented, "routes missing from docs, run make swagger")
+	assert.Empty(t, unregistered, "documented operations without a route")
+}
+
+// isLegacyPath 判断文档中的路径是否为未带版本号的旧路由
+func isLegacyPath(path string) bool {
+	for _, prefix := range []string{"/api/v1/", "/monitoring/", "/.well-known/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	return path != "/health" && path != "/readiness"
+}
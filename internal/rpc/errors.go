@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorDomain ErrorInfo 中的 domain，reason 为 REST 响应中的错误码
+const ErrorDomain = "demo-api"
+
+// 与 HTTP 状态码对应不够准确的错误码单独指定
+var errorCodes = map[response.Code]codes.Code{
+	response.ErrInsufficientFunds.Code: codes.FailedPrecondition,
+	response.ErrResourceLocked.Code:    codes.Aborted,
+}
+
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+	http.StatusInternalServerError: codes.Internal,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// statusError 将业务错误转换为 gRPC status，消息按 accept-language 选择语言，
+// 字段校验错误放在 BadRequest 中，其他详细信息以 JSON 放在 ErrorInfo.Metadata["details"]。
+// 已经是 status 的错误原样返回
+func statusError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+
+	e := response.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		entry := pkg.LoggerFromContext(ctx).WithField("code", e.Code)
+		if cause := errors.Unwrap(e); cause != nil {
+			entry = entry.WithError(cause)
+		}
+		entry.Error("Request failed")
+	}
+
+	code, ok := errorCodes[e.Code]
+	if !ok {
+		if code, ok = statusCodes[e.Status]; !ok {
+			code = codes.Unknown
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	st := status.New(code, e.Message(response.ParseLang(firstValue(md, acceptLanguageKey))))
+	info := &errdetails.ErrorInfo{Reason: string(e.Code), Domain: ErrorDomain}
+	var violations []*errdetails.BadRequest_FieldViolation
+	if fields, ok := e.Details.([]response.FieldError); ok {
+		for _, f := range fields {
+			description := f.Rule
+			if f.Param != "" {
+				description += "=" + f.Param
+			}
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: f.Field, Description: description})
+		}
+	} else if e.Details != nil {
+		if data, err := json.Marshal(e.Details); err == nil {
+			info.Metadata = map[string]string{"details": string(data)}
+		}
+	}
+
+	if len(violations) > 0 {
+		st, err = st.WithDetails(info, &errdetails.BadRequest{FieldViolations: violations})
+	} else {
+		st, err = st.WithDetails(info)
+	}
+	if err != nil {
+		return status.Error(code, e.Message(response.DefaultLang))
+	}
+	return st.Err()
+}
@@ -0,0 +1,196 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/ledger"
+	"github.com/kakaluote000/demo-api/internal/middleware"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/kakaluote000/demo-api/pkg/ratelimit"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// 与 HTTP 请求头对应的 metadata 键，gRPC 要求小写
+const (
+	requestIDKey      = "x-request-id"
+	authorizationKey  = "authorization"
+	apiKeyKey         = "x-api-key"
+	acceptLanguageKey = "accept-language"
+	retryAfterKey     = "retry-after"
+)
+
+// 限流策略按 "GRPC <完整方法名>" 查找，未单独配置的方法使用 default
+const rateLimitMethod = "GRPC"
+
+// 不需要认证的服务，供负载均衡和编排系统探测
+var publicServices = []string{"/grpc.health.v1.Health/"}
+
+type callerKey struct{}
+
+// interceptor 为每次调用设置请求 ID 和日志，完成限流和认证，记录指标并将错误转换为 gRPC status
+type interceptor struct {
+	authenticator *auth.Authenticator
+	limiter       *middleware.RateLimiter
+}
+
+func newInterceptor(app *app.App) *interceptor {
+	return &interceptor{
+		authenticator: auth.NewAuthenticator(app.Redis, app.DB),
+		limiter:       middleware.NewRateLimiter(app.Redis, pkg.AppConfig.Security.RateLimit),
+	}
+}
+
+func (i *interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	ctx, err := i.begin(ctx, info.FullMethod)
+	var resp interface{}
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	return resp, i.finish(ctx, info.FullMethod, start, err)
+}
+
+func (i *interceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, err := i.begin(ss.Context(), info.FullMethod)
+	if err == nil {
+		err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	}
+	return i.finish(ctx, info.FullMethod, start, err)
+}
+
+// begin 设置请求 ID 和请求级日志，限流和认证通过后将 ledger.Caller 存入 ctx
+func (i *interceptor) begin(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstValue(md, requestIDKey)
+	if !middleware.ValidRequestID(requestID) {
+		requestID = middleware.NewRequestID()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+
+	entry := logrus.NewEntry(pkg.Log).WithFields(logrus.Fields{
+		"request_id": requestID,
+		"method":     method,
+	})
+	ctx = pkg.ContextWithLogger(ctx, entry)
+
+	// 健康检查与 HTTP 的 /health 一样不限流
+	for _, prefix := range publicServices {
+		if strings.HasPrefix(method, prefix) {
+			return ctx, nil
+		}
+	}
+
+	// 与 HTTP 一样，认证之前按 IP 限流，错误的凭证同样计数；认证之后按用户或 API key 计数
+	clientIP := peerIP(ctx)
+	if err := rateLimited(ctx, method, i.limiter.AllowIP(ctx, clientIP)); err != nil {
+		return ctx, err
+	}
+
+	principal, err := i.authenticator.Authenticate(ctx, auth.Credentials{
+		Authorization: firstValue(md, authorizationKey),
+		APIKey:        firstValue(md, apiKeyKey),
+		ClientIP:      clientIP,
+	})
+	if err != nil {
+		if errors.Is(err, auth.ErrAPIKeyIPNotAllowed) {
+			entry.Warnf("API key used from disallowed ip %s", clientIP)
+		}
+		return ctx, err
+	}
+
+	entry = entry.WithField("principal", principal.String())
+	if principal.Type == auth.PrincipalUser {
+		entry = entry.WithField("user_id", principal.UserID)
+	}
+	ctx = pkg.ContextWithLogger(ctx, entry)
+	if err := rateLimited(ctx, method, i.limiter.Allow(ctx, principal.String(), rateLimitMethod, method)); err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, callerKey{}, ledger.Caller{Principal: principal, RequestID: requestID, IP: clientIP}), nil
+}
+
+// finish 转换错误并输出访问日志和指标
+func (i *interceptor) finish(ctx context.Context, method string, start time.Time, err error) error {
+	err = statusError(ctx, err)
+	code := status.Code(err)
+	duration := time.Since(start)
+
+	metrics.GRPCRequests.WithLabelValues(method, code.String()).Inc()
+	metrics.GRPCRequestDuration.WithLabelValues(method).Observe(duration.Seconds())
+
+	level := logrus.InfoLevel
+	switch code {
+	case codes.OK, codes.Canceled:
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unavailable:
+		level = logrus.ErrorLevel
+	default:
+		level = logrus.WarnLevel
+	}
+	pkg.LoggerFromContext(ctx).WithFields(logrus.Fields{
+		"code":       code.String(),
+		"latency_ms": float64(duration.Microseconds()) / 1000,
+		"client_ip":  peerIP(ctx),
+	}).Log(level, "gRPC request")
+	return err
+}
+
+// rateLimited 在调用被限流时通过 retry-after 告知需要等待的秒数，并返回 ErrRateLimited
+func rateLimited(ctx context.Context, method string, result ratelimit.Result) error {
+	if result.Allowed {
+		return nil
+	}
+	metrics.RateLimitRejections.WithLabelValues(rateLimitMethod, method).Inc()
+	grpc.SetHeader(ctx, metadata.Pairs(retryAfterKey, strconv.FormatInt(int64(math.Ceil(result.RetryAfter.Seconds())), 10)))
+	return response.ErrRateLimited
+}
+
+// callerFromContext 返回拦截器认证得到的调用方
+func callerFromContext(ctx context.Context) ledger.Caller {
+	caller, _ := ctx.Value(callerKey{}).(ledger.Caller)
+	return caller
+}
+
+// serverStream 替换流的 ctx，使处理器能取得调用方和请求日志
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
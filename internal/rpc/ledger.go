@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+
+	ledgerv1 "github.com/kakaluote000/demo-api/api/ledger/v1"
+	"github.com/kakaluote000/demo-api/internal/ledger"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ledgerServer 只负责 protobuf 与 ledger.Service 之间的转换，业务逻辑和权限检查都在 ledger.Service 中
+type ledgerServer struct {
+	ledgerv1.UnimplementedLedgerServiceServer
+	svc      *ledger.Service
+	stopping <-chan struct{}
+}
+
+func (s *ledgerServer) ListWallets(ctx context.Context, req *ledgerv1.ListWalletsRequest) (*ledgerv1.ListWalletsResponse, error) {
+	wallets, err := s.svc.ListWallets(ctx, callerFromContext(ctx), uint(req.UserId))
+	if err != nil {
+		return nil, err
+	}
+	resp := &ledgerv1.ListWalletsResponse{Wallets: make([]*ledgerv1.Wallet, len(wallets))}
+	for i := range wallets {
+		resp.Wallets[i] = newWallet(&wallets[i])
+	}
+	return resp, nil
+}
+
+func (s *ledgerServer) GetWallet(ctx context.Context, req *ledgerv1.GetWalletRequest) (*ledgerv1.GetWalletResponse, error) {
+	wallet, err := s.svc.GetWallet(ctx, callerFromContext(ctx), uint(req.UserId), uint(req.CurrencyId))
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerv1.GetWalletResponse{Wallet: newWallet(wallet)}, nil
+}
+
+func (s *ledgerServer) Credit(ctx context.Context, req *ledgerv1.CreditRequest) (*ledgerv1.CreditResponse, error) {
+	change, err := s.svc.Apply(ctx, callerFromContext(ctx), ledger.Transaction{
+		UserID:     uint(req.UserId),
+		CurrencyID: uint(req.CurrencyId),
+		Type:       ledger.TypeCredit,
+		Amount:     uint(req.Amount),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerv1.CreditResponse{Transaction: newTransaction(&change.Record), Balance: uint64(change.After)}, nil
+}
+
+func (s *ledgerServer) Debit(ctx context.Context, req *ledgerv1.DebitRequest) (*ledgerv1.DebitResponse, error) {
+	change, err := s.svc.Apply(ctx, callerFromContext(ctx), ledger.Transaction{
+		UserID:     uint(req.UserId),
+		CurrencyID: uint(req.CurrencyId),
+		Type:       ledger.TypeDebit,
+		Amount:     uint(req.Amount),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerv1.DebitResponse{Transaction: newTransaction(&change.Record), Balance: uint64(change.After)}, nil
+}
+
+func (s *ledgerServer) Transfer(ctx context.Context, req *ledgerv1.TransferRequest) (*ledgerv1.TransferResponse, error) {
+	result, err := s.svc.Transfer(ctx, callerFromContext(ctx), ledger.Transfer{
+		FromUserID: uint(req.FromUserId),
+		ToUserID:   uint(req.ToUserId),
+		CurrencyID: uint(req.CurrencyId),
+		Amount:     uint(req.Amount),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerv1.TransferResponse{
+		FromUserId: req.FromUserId,
+		ToUserId:   req.ToUserId,
+		CurrencyId: req.CurrencyId,
+		Amount:     req.Amount,
+		Balance:    uint64(result.Debit.After),
+	}, nil
+}
+
+func (s *ledgerServer) ListTransactions(ctx context.Context, req *ledgerv1.ListTransactionsRequest) (*ledgerv1.ListTransactionsResponse, error) {
+	page, err := s.svc.ListTransactions(ctx, callerFromContext(ctx), ledger.TransactionQuery{
+		UserID:     uint(req.UserId),
+		CurrencyID: uint(req.CurrencyId),
+		Page:       int(req.Page),
+		PageSize:   int(req.PageSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := &ledgerv1.ListTransactionsResponse{
+		Transactions: make([]*ledgerv1.Transaction, len(page.Records)),
+		Total:        page.Total,
+		Page:         uint32(page.Page),
+		PageSize:     uint32(page.PageSize),
+	}
+	for i := range page.Records {
+		resp.Transactions[i] = newTransaction(&page.Records[i])
+	}
+	return resp, nil
+}
+
+// SubscribeBalanceChanges 持续推送余额变更，直到客户端取消或服务关闭。
+// 服务端结束订阅时返回 Unavailable，客户端使用最后收到的 event_id 重新订阅
+func (s *ledgerServer) SubscribeBalanceChanges(req *ledgerv1.SubscribeBalanceChangesRequest, stream ledgerv1.LedgerService_SubscribeBalanceChangesServer) error {
+	ctx := stream.Context()
+	sub, err := s.svc.Subscribe(ctx, callerFromContext(ctx), uint(req.UserId), req.LastEventId)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	// 订阅生效后立即发送响应头，客户端据此确认之后的变更都会收到
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	subscribers := metrics.BalanceSubscribers.WithLabelValues("grpc")
+	subscribers.Inc()
+	defer subscribers.Dec()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				return status.Error(codes.Unavailable, "balance change subscription closed")
+			}
+			if err := stream.Send(newBalanceChange(&event)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.stopping:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func newWallet(wallet *models.UserCurrency) *ledgerv1.Wallet {
+	return &ledgerv1.Wallet{
+		UserId:     uint64(wallet.UserID),
+		CurrencyId: uint64(wallet.CurrencyID),
+		Balance:    uint64(wallet.CurrencyNum),
+		UpdatedAt:  timestamppb.New(wallet.UpdatedAt),
+	}
+}
+
+var transactionTypes = map[string]ledgerv1.TransactionType{
+	ledger.TypeCredit: ledgerv1.TransactionType_TRANSACTION_TYPE_CREDIT,
+	ledger.TypeDebit:  ledgerv1.TransactionType_TRANSACTION_TYPE_DEBIT,
+}
+
+func newTransaction(record *models.CurrencyTransaction) *ledgerv1.Transaction {
+	return &ledgerv1.Transaction{
+		Id:         uint64(record.ID),
+		UserId:     uint64(record.UserID),
+		CurrencyId: uint64(record.CurrencyID),
+		Type:       transactionTypes[ledger.RecordType(record)],
+		Amount:     uint64(record.Amount),
+		CreatedAt:  timestamppb.New(record.TransactionTime),
+	}
+}
+
+var balanceChangeTypes = map[string]ledgerv1.BalanceChangeType{
+	ledger.EventCredit: ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_CREDIT,
+	ledger.EventDebit:  ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_DEBIT,
+	ledger.EventSet:    ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_SET,
+	ledger.EventReset:  ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_RESET,
+}
+
+func newBalanceChange(event *ledger.BalanceEvent) *ledgerv1.BalanceChange {
+	return &ledgerv1.BalanceChange{
+		UserId:        uint64(event.UserID),
+		CurrencyId:    uint64(event.CurrencyID),
+		Type:          balanceChangeTypes[event.Type],
+		Amount:        uint64(event.Amount),
+		Balance:       uint64(event.Balance),
+		TransactionId: uint64(event.TransactionID),
+		Time:          timestamppb.New(event.Time),
+		EventId:       event.ID,
+	}
+}
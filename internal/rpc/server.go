@@ -0,0 +1,116 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ledgerv1 "github.com/kakaluote000/demo-api/api/ledger/v1"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/ledger"
+	"github.com/kakaluote000/demo-api/pkg"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	defaultPort            = 9091
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// Server 账本 gRPC 服务，与 REST 接口共用 ledger.Service 和认证规则
+type Server struct {
+	grpc   *grpc.Server
+	health *health.Server
+	// 关闭时通知订阅流结束，否则 GracefulStop 会一直等待
+	stopping chan struct{}
+	stopOnce sync.Once
+}
+
+// NewServer 创建 gRPC 服务，认证、请求日志、指标和错误转换由拦截器统一处理
+func NewServer(app *app.App, opts ...grpc.ServerOption) *Server {
+	interceptor := newInterceptor(app)
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(interceptor.unary),
+		grpc.ChainStreamInterceptor(interceptor.stream),
+	)
+
+	s := &Server{
+		grpc:     grpc.NewServer(opts...),
+		health:   health.NewServer(),
+		stopping: make(chan struct{}),
+	}
+	ledgerv1.RegisterLedgerServiceServer(s.grpc, &ledgerServer{svc: ledger.NewService(app), stopping: s.stopping})
+	healthpb.RegisterHealthServer(s.grpc, s.health)
+	return s
+}
+
+// Serve 在 listener 上提供服务，直到 Shutdown 被调用
+func (s *Server) Serve(listener net.Listener) error {
+	return s.grpc.Serve(listener)
+}
+
+// Shutdown 将健康检查置为 NOT_SERVING，结束所有订阅流并等待进行中的调用完成，ctx 到期后强制关闭连接
+func (s *Server) Shutdown(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		s.health.Shutdown()
+		close(s.stopping)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// Start 监听配置的端口，并注册为 App 的后台任务：随 HTTP 服务启动，关闭时优雅停止。
+// HTTP 启用了 TLS 时使用同一份证书
+func Start(app *app.App, cfg pkg.GRPCConfig) error {
+	port := cfg.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+
+	app.AddWorker("grpc", func(ctx context.Context) {
+		// TLS 在 App.Run 中启用，需要在任务启动时读取
+		var opts []grpc.ServerOption
+		if tlsConfig := app.TLSConfig(); tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		server := NewServer(app, opts...)
+
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- server.Serve(listener)
+		}()
+		app.Log.Infof("gRPC server listening on %s", listener.Addr())
+
+		select {
+		case err := <-serveErr:
+			app.Log.WithError(err).Error("gRPC server stopped unexpectedly")
+		case <-ctx.Done():
+			timeout := pkg.AppConfig.Server.ShutdownTimeout
+			if timeout <= 0 {
+				timeout = defaultShutdownTimeout
+			}
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,374 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redsync/redsync/v4"
+	"github.com/go-redsync/redsync/v4/redis/goredis/v8"
+	ledgerv1 "github.com/kakaluote000/demo-api/api/ledger/v1"
+	"github.com/kakaluote000/demo-api/cmd/app"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/internal/routes"
+	"github.com/kakaluote000/demo-api/internal/rpc"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gorm.io/gorm"
+)
+
+const (
+	userID     = 1
+	operatorID = 2
+	otherID    = 3
+)
+
+type testServer struct {
+	app    *app.App
+	client ledgerv1.LedgerServiceClient
+	conn   *grpc.ClientConn
+	tokens map[uint]string
+}
+
+// opts 在服务创建前修改配置
+func newTestServer(t *testing.T, opts ...func(*pkg.Config)) *testServer {
+	gin.SetMode(gin.TestMode)
+	pkg.AppConfig = pkg.Config{}
+	pkg.AppConfig.Security.RateLimit = pkg.RateLimitConfig{RequestsPerSecond: 1000, Burst: 1000}
+	for _, opt := range opts {
+		opt(&pkg.AppConfig)
+	}
+	auth.Init(pkg.JWTConfig{Secret: "test-secret", Expiry: time.Hour})
+
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	// 关闭全部连接后内存数据库被删除，-count 重复运行时不会残留数据
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, pkg.AutoMigrate(db))
+	users := map[uint]auth.Role{userID: auth.RoleUser, operatorID: auth.RoleOperator, otherID: auth.RoleUser}
+	for id, role := range users {
+		require.NoError(t, db.Create(&models.User{Model: gorm.Model{ID: id}, Username: fmt.Sprintf("user%d", id), Password: "x", Role: string(role)}).Error)
+		require.NoError(t, db.Create(&models.UserCurrency{UserID: id, CurrencyID: 1, CurrencyNum: 100}).Error)
+	}
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	a := app.NewAppWith(context.Background(), db, rdb, redsync.New(goredis.NewPool(rdb)))
+	routes.SetupRoutes(a)
+
+	listener := bufconn.Listen(1 << 20)
+	server := rpc.NewServer(a)
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	})
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	s := &testServer{app: a, client: ledgerv1.NewLedgerServiceClient(conn), conn: conn, tokens: map[uint]string{}}
+	store := auth.NewTokenStore(rdb, db)
+	for id, role := range users {
+		pair, err := store.IssueTokens(context.Background(), id, role)
+		require.NoError(t, err)
+		s.tokens[id] = pair.AccessToken
+	}
+	return s
+}
+
+// as 返回携带用户 access token 的 ctx
+func (s *testServer) as(id uint) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+s.tokens[id])
+}
+
+// requireStatus 检查 gRPC 错误码和 ErrorInfo 中的业务错误码
+func requireStatus(t *testing.T, err error, code codes.Code, reason *response.Error) *status.Status {
+	t.Helper()
+	st, ok := status.FromError(err)
+	require.True(t, ok, "%v", err)
+	require.Equal(t, code, st.Code(), st.Message())
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, string(reason.Code), info.Reason)
+			assert.Equal(t, rpc.ErrorDomain, info.Domain)
+			return st
+		}
+	}
+	t.Fatalf("status %v has no ErrorInfo", st)
+	return st
+}
+
+func TestLedgerAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	req := &ledgerv1.ListWalletsRequest{UserId: userID}
+
+	_, err := s.client.ListWallets(context.Background(), req)
+	requireStatus(t, err, codes.Unauthenticated, response.ErrUnauthorized)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+	_, err = s.client.ListWallets(ctx, req)
+	requireStatus(t, err, codes.Unauthenticated, response.ErrInvalidToken)
+
+	// 注销后的 token 立即失效
+	ctx = s.as(userID)
+	var header metadata.MD
+	resp, err := s.client.ListWallets(ctx, req, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Len(t, resp.Wallets, 1)
+	assert.Equal(t, uint64(100), resp.Wallets[0].Balance)
+	assert.NotEmpty(t, header.Get("x-request-id"))
+
+	claims, err := auth.ParseToken(s.tokens[userID])
+	require.NoError(t, err)
+	require.NoError(t, auth.NewTokenStore(s.app.Redis, s.app.DB).Revoke(context.Background(), claims))
+	_, err = s.client.ListWallets(ctx, req)
+	requireStatus(t, err, codes.Unauthenticated, response.ErrTokenRevoked)
+
+	// API key 通过 x-api-key 或 authorization: ApiKey 传递，权限由授权范围决定
+	key, _, err := auth.NewAPIKeyStore(s.app.DB).Create(context.Background(), auth.NewAPIKey{Name: "svc", Scopes: []auth.Scope{auth.ScopeWalletRead}})
+	require.NoError(t, err)
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	_, err = s.client.ListWallets(ctx, &ledgerv1.ListWalletsRequest{UserId: otherID})
+	require.NoError(t, err)
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "ApiKey "+key)
+	_, err = s.client.Credit(ctx, &ledgerv1.CreditRequest{UserId: otherID, CurrencyId: 1, Amount: 1})
+	requireStatus(t, err, codes.PermissionDenied, response.ErrPermissionDenied)
+
+	// 健康检查不需要认证
+	health, err := healthpb.NewHealthClient(s.conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+}
+
+func TestLedgerRateLimit(t *testing.T) {
+	s := newTestServer(t, func(cfg *pkg.Config) {
+		cfg.Security.RateLimit.Routes = []pkg.RouteRateLimit{
+			{Method: "GRPC", Path: "/ledger.v1.LedgerService/ListWallets", RequestsPerSecond: 0.1, Burst: 2},
+		}
+		cfg.Security.RateLimit.IP = pkg.IPRateLimit{RequestsPerSecond: 0.1, Burst: 6}
+	})
+	req := &ledgerv1.ListWalletsRequest{UserId: userID}
+
+	// 按用户和方法计数
+	for i := 0; i < 2; i++ {
+		_, err := s.client.ListWallets(s.as(userID), req)
+		require.NoError(t, err)
+	}
+	var header metadata.MD
+	_, err := s.client.ListWallets(s.as(userID), req, grpc.Header(&header))
+	requireStatus(t, err, codes.ResourceExhausted, response.ErrRateLimited)
+	assert.Equal(t, []string{"10"}, header.Get("retry-after"))
+
+	// 其他用户单独计数
+	_, err = s.client.ListWallets(s.as(otherID), &ledgerv1.ListWalletsRequest{UserId: otherID})
+	require.NoError(t, err)
+
+	// 认证之前按 IP 计数，错误的 token 同样计数，超出后有效的 token 也被拒绝
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer invalid")
+	for i := 0; i < 2; i++ {
+		_, err = s.client.ListWallets(ctx, req)
+		requireStatus(t, err, codes.Unauthenticated, response.ErrInvalidToken)
+	}
+	_, err = s.client.ListWallets(ctx, req)
+	requireStatus(t, err, codes.ResourceExhausted, response.ErrRateLimited)
+	_, err = s.client.GetWallet(s.as(operatorID), &ledgerv1.GetWalletRequest{UserId: operatorID, CurrencyId: 1})
+	requireStatus(t, err, codes.ResourceExhausted, response.ErrRateLimited)
+
+	// 健康检查不限流
+	resp, err := healthpb.NewHealthClient(s.conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+}
+
+func TestLedgerOperations(t *testing.T) {
+	s := newTestServer(t)
+
+	// 与 REST 接口相同的归属检查：普通用户只能查看和扣减自己的钱包
+	_, err := s.client.GetWallet(s.as(userID), &ledgerv1.GetWalletRequest{UserId: otherID, CurrencyId: 1})
+	requireStatus(t, err, codes.PermissionDenied, response.ErrPermissionDenied)
+	_, err = s.client.Debit(s.as(userID), &ledgerv1.DebitRequest{UserId: otherID, CurrencyId: 1, Amount: 1})
+	requireStatus(t, err, codes.PermissionDenied, response.ErrPermissionDenied)
+	_, err = s.client.Credit(s.as(userID), &ledgerv1.CreditRequest{UserId: userID, CurrencyId: 1, Amount: 1})
+	requireStatus(t, err, codes.PermissionDenied, response.ErrPermissionDenied)
+
+	credit, err := s.client.Credit(s.as(operatorID), &ledgerv1.CreditRequest{UserId: userID, CurrencyId: 1, Amount: 50})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(150), credit.Balance)
+	assert.Equal(t, ledgerv1.TransactionType_TRANSACTION_TYPE_CREDIT, credit.Transaction.Type)
+
+	debit, err := s.client.Debit(s.as(userID), &ledgerv1.DebitRequest{UserId: userID, CurrencyId: 1, Amount: 30})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(120), debit.Balance)
+
+	_, err = s.client.Debit(s.as(userID), &ledgerv1.DebitRequest{UserId: userID, CurrencyId: 1, Amount: 1000})
+	requireStatus(t, err, codes.FailedPrecondition, response.ErrInsufficientFunds)
+	_, err = s.client.Debit(s.as(userID), &ledgerv1.DebitRequest{UserId: userID, CurrencyId: 2, Amount: 1})
+	requireStatus(t, err, codes.NotFound, response.ErrWalletNotFound)
+
+	// 参数校验与请求体的 binding 规则一致，未通过的字段放在 BadRequest 中
+	_, err = s.client.Transfer(s.as(userID), &ledgerv1.TransferRequest{FromUserId: userID, ToUserId: userID, CurrencyId: 1, Amount: 2000000000000})
+	st := requireStatus(t, err, codes.InvalidArgument, response.ErrValidationFailed)
+	var violations []string
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				violations = append(violations, v.Field+":"+v.Description)
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"amount:lte=1000000000000", "to_user_id:nefield=FromUserID"}, violations)
+
+	transfer, err := s.client.Transfer(s.as(userID), &ledgerv1.TransferRequest{FromUserId: userID, ToUserId: otherID, CurrencyId: 1, Amount: 20})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(100), transfer.Balance)
+	other, err := s.client.GetWallet(s.as(otherID), &ledgerv1.GetWalletRequest{UserId: otherID, CurrencyId: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(120), other.Wallet.Balance)
+
+	page, err := s.client.ListTransactions(s.as(userID), &ledgerv1.ListTransactionsRequest{UserId: userID, PageSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), page.Total)
+	assert.Equal(t, uint32(1), page.Page)
+	require.Len(t, page.Transactions, 2)
+	assert.Equal(t, ledgerv1.TransactionType_TRANSACTION_TYPE_DEBIT, page.Transactions[0].Type)
+	assert.Equal(t, uint64(20), page.Transactions[0].Amount)
+
+	_, err = s.client.ListTransactions(s.as(userID), &ledgerv1.ListTransactionsRequest{UserId: userID, PageSize: 101})
+	requireStatus(t, err, codes.InvalidArgument, response.ErrValidationFailed)
+}
+
+func TestSubscribeBalanceChanges(t *testing.T) {
+	s := newTestServer(t)
+
+	_, err := recvWithTimeout(t, s.subscribe(t, userID, otherID))
+	requireStatus(t, err, codes.PermissionDenied, response.ErrPermissionDenied)
+
+	stream := s.subscribe(t, userID, userID)
+	// 订阅生效前的变更不会推送，服务端在订阅生效后发送响应头
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	// REST 接口产生的变更同样推送给 gRPC 订阅者
+	body, _ := json.Marshal(gin.H{"user_id": userID, "currency_id": 1, "type": "credit", "amount": 5})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/transactions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.tokens[operatorID])
+	w := httptest.NewRecorder()
+	s.app.Router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	change, err := recvWithTimeout(t, stream)
+	require.NoError(t, err)
+	assert.Equal(t, ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_CREDIT, change.Type)
+	assert.Equal(t, uint64(5), change.Amount)
+	assert.Equal(t, uint64(105), change.Balance)
+	assert.NotZero(t, change.TransactionId)
+
+	_, err = s.client.Transfer(s.as(userID), &ledgerv1.TransferRequest{FromUserId: userID, ToUserId: otherID, CurrencyId: 1, Amount: 10})
+	require.NoError(t, err)
+	change, err = recvWithTimeout(t, stream)
+	require.NoError(t, err)
+	assert.Equal(t, ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_DEBIT, change.Type)
+	assert.Equal(t, uint64(95), change.Balance)
+	assert.NotEmpty(t, change.EventId)
+}
+
+func TestSubscribeBalanceChangesResume(t *testing.T) {
+	s := newTestServer(t)
+	credit := func(amount uint64) {
+		_, err := s.client.Credit(s.as(operatorID), &ledgerv1.CreditRequest{UserId: userID, CurrencyId: 1, Amount: amount})
+		require.NoError(t, err)
+	}
+	recv := func(stream ledgerv1.LedgerService_SubscribeBalanceChangesClient) *ledgerv1.BalanceChange {
+		change, err := recvWithTimeout(t, stream)
+		require.NoError(t, err)
+		return change
+	}
+
+	stream := s.subscribe(t, userID, userID)
+	_, err := stream.Header()
+	require.NoError(t, err)
+	credit(1)
+	first := recv(stream)
+	assert.Equal(t, uint64(101), first.Balance)
+
+	// 断线期间的变更在重新订阅时补发，之后的实时变更不重复
+	credit(2)
+	credit(3)
+	resumed := s.subscribe(t, userID, userID, first.EventId)
+	assert.Equal(t, uint64(103), recv(resumed).Balance)
+	assert.Equal(t, uint64(106), recv(resumed).Balance)
+	credit(4)
+	assert.Equal(t, uint64(110), recv(resumed).Balance)
+
+	// 历史过期或 ID 无效时先通知客户端重新查询余额，之后继续推送实时变更
+	for _, lastEventID := range []string{"0-1", "not-an-id"} {
+		stream := s.subscribe(t, userID, userID, lastEventID)
+		change, err := recvWithTimeout(t, stream)
+		require.NoError(t, err)
+		assert.Equal(t, ledgerv1.BalanceChangeType_BALANCE_CHANGE_TYPE_RESET, change.Type, lastEventID)
+		assert.Equal(t, uint64(userID), change.UserId)
+	}
+}
+
+// subscribe 订阅用户的余额变更，lastEventID 可选
+func (s *testServer) subscribe(t *testing.T, caller, userID uint, lastEventID ...string) ledgerv1.LedgerService_SubscribeBalanceChangesClient {
+	ctx, cancel := context.WithCancel(s.as(caller))
+	t.Cleanup(cancel)
+	req := &ledgerv1.SubscribeBalanceChangesRequest{UserId: uint64(userID)}
+	if len(lastEventID) > 0 {
+		req.LastEventId = lastEventID[0]
+	}
+	stream, err := s.client.SubscribeBalanceChanges(ctx, req)
+	require.NoError(t, err)
+	return stream
+}
+
+func recvWithTimeout(t *testing.T, stream ledgerv1.LedgerService_SubscribeBalanceChangesClient) (*ledgerv1.BalanceChange, error) {
+	t.Helper()
+	type result struct {
+		change *ledgerv1.BalanceChange
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		change, err := stream.Recv()
+		ch <- result{change, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.change, r.err
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for balance change")
+		return nil, nil
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	"github.com/kakaluote000/demo-api/cmd/app"
 	_ "github.com/kakaluote000/demo-api/docs"
+	"github.com/kakaluote000/demo-api/internal/handlers"
 	"github.com/kakaluote000/demo-api/internal/routes"
+	"github.com/kakaluote000/demo-api/internal/rpc"
 	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -21,7 +27,34 @@ import (
 func main() {
 	pkg.InitConfig()
 	app := app.NewApp()
+	if err := handlers.EnsureBootstrapAdmin(app.Ctx, app.DB, pkg.AppConfig.Security.BootstrapAdmin); err != nil {
+		pkg.Log.Fatalf("failed to bootstrap admin: %v", err)
+	}
 	routes.SetupRoutes(app)
+	app.AddWorker("config-watcher", func(ctx context.Context) {
+		pkg.WatchConfig(ctx)
+		<-ctx.Done()
+	})
+	app.AddWorker("supply-metrics", func(ctx context.Context) {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			if err := metrics.UpdateSupply(ctx, app.DB); err != nil && ctx.Err() == nil {
+				pkg.Log.WithError(err).Warn("Failed to update circulating supply metrics")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+
+	if cfg := pkg.AppConfig.Server.GRPC; cfg.Enabled {
+		if err := rpc.Start(app, cfg); err != nil {
+			pkg.Log.Fatalf("failed to start grpc server: %v", err)
+		}
+	}
 
 	// 添加 Swagger 路由
 	app.Router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
 	"github.com/kakaluote000/demo-api/pkg/notification"
 	"gorm.io/gorm"
 )
@@ -34,7 +35,7 @@ func (p *AlertProcessor) ProcessAlert(alert *models.AlertHistory) error {
 		return p.handleAlertAutomatically(alert, rule)
 	}
 
-	if p.shouldEscalate(alert, rule) {
+	if pkg.CurrentConfig().Alert.EscalationEnabled && p.shouldEscalate(alert, rule) {
 		return p.escalateAlert(alert, rule)
 	}
 
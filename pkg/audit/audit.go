@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kakaluote000/demo-api/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ActorSystem    = "system"
+	ActorAnonymous = "anonymous"
+)
+
+// 多个实例同时追加时序号冲突的重试次数
+const maxAppendAttempts = 5
+
+// 同一进程内串行追加，减少序号冲突；跨实例由 seq 唯一索引保证。
+// 在事务中调用 Record 时链尾被锁定到事务结束，一个事务只应写入一条，否则可能与其他请求互相等待
+var appendMu sync.Mutex
+
+// Entry 描述一次需要审计的操作，Before/After 会被序列化为 JSON
+type Entry struct {
+	Actor     string
+	Action    string
+	Target    string
+	Before    interface{}
+	After     interface{}
+	RequestID string
+	IP        string
+}
+
+// Recorder 将审计日志写入数据库
+type Recorder struct {
+	db *gorm.DB
+}
+
+func NewRecorder(db *gorm.DB) *Recorder {
+	return &Recorder{db: db}
+}
+
+// Record 在哈希链末尾追加一条审计日志。传入事务创建的 Recorder 时与事务一起提交或回滚
+func (r *Recorder) Record(ctx context.Context, entry Entry) error {
+	before, err := marshal(entry.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshal(entry.After)
+	if err != nil {
+		return err
+	}
+
+	actor := entry.Actor
+	if actor == "" {
+		actor = ActorAnonymous
+	}
+	log := models.AuditLog{
+		Actor:     actor,
+		Action:    entry.Action,
+		Target:    entry.Target,
+		Before:    before,
+		After:     after,
+		RequestID: entry.RequestID,
+		IP:        entry.IP,
+	}
+
+	appendMu.Lock()
+	defer appendMu.Unlock()
+
+	db := r.db.WithContext(ctx)
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		var last models.AuditLog
+		// 加锁读取链尾，在事务中也能读到其他事务已提交的最新记录
+		if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).Where("seq IS NOT NULL").Order("seq DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		var seq uint64 = 1
+		if last.Seq != nil {
+			seq = *last.Seq + 1
+		}
+
+		log.ID = 0
+		log.Seq = &seq
+		log.PrevHash = last.Hash
+		// 截断到毫秒，与 MySQL datetime(3) 的精度一致，读回后哈希不变
+		log.CreatedAt = time.Now().UTC().Truncate(time.Millisecond)
+		log.Hash = ComputeHash(&log)
+
+		err := db.Create(&log).Error
+		if err == nil {
+			return nil
+		}
+		// 其他实例抢先写入了同一序号，重新读取链尾后重试
+		var taken int64
+		if countErr := db.Model(&models.AuditLog{}).Where("seq = ?", seq).Count(&taken).Error; countErr != nil || taken == 0 {
+			return err
+		}
+	}
+	return fmt.Errorf("audit: failed to append entry after %d attempts", maxAppendAttempts)
+}
+
+func marshal(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
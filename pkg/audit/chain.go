@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kakaluote000/demo-api/internal/models"
+	"gorm.io/gorm"
+)
+
+// 校验时每批读取的记录数
+const verifyBatchSize = 500
+
+// 最多报告的问题数，链断开后通常后续每条都会报错
+const maxProblems = 100
+
+// hashedFields 参与哈希计算的字段，字段顺序固定，修改会使已有的链全部失效
+type hashedFields struct {
+	Seq       uint64 `json:"seq"`
+	Actor     string `json:"actor"`
+	Action    string `json:"action"`
+	Target    string `json:"target"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	RequestID string `json:"request_id"`
+	IP        string `json:"ip"`
+	CreatedAt string `json:"created_at"`
+	PrevHash  string `json:"prev_hash"`
+}
+
+// ComputeHash 计算审计日志的哈希：SHA-256(内容的 JSON)，内容包含上一条记录的哈希
+func ComputeHash(log *models.AuditLog) string {
+	fields := hashedFields{
+		Actor:     log.Actor,
+		Action:    log.Action,
+		Target:    log.Target,
+		Before:    log.Before,
+		After:     log.After,
+		RequestID: log.RequestID,
+		IP:        log.IP,
+		CreatedAt: log.CreatedAt.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano),
+		PrevHash:  log.PrevHash,
+	}
+	if log.Seq != nil {
+		fields.Seq = *log.Seq
+	}
+	// 只包含字符串和整数，不会出错
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Problem 描述链上的一处异常
+type Problem struct {
+	Seq     uint64 `json:"seq"`
+	ID      uint   `json:"id,omitempty"`
+	Message string `json:"message"`
+}
+
+// VerifyResult 校验结果。Head 是链尾的哈希，应当保存在数据库之外，
+// 之后校验时确认该记录仍在链上，才能发现链从末尾被截断
+type VerifyResult struct {
+	Entries  int64     `json:"entries"`
+	Legacy   int64     `json:"legacy"`
+	LastSeq  uint64    `json:"last_seq"`
+	Head     string    `json:"head"`
+	Problems []Problem `json:"problems"`
+}
+
+// OK 判断链是否完整
+func (r *VerifyResult) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyResult) report(seq uint64, id uint, format string, args ...interface{}) {
+	if len(r.Problems) < maxProblems {
+		r.Problems = append(r.Problems, Problem{Seq: seq, ID: id, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// Verify 按序号遍历整条哈希链，检查序号是否连续、每条记录是否指向上一条、
+// 内容是否与哈希一致。返回的 error 只表示读取失败，链上的问题记录在 Problems 中
+func Verify(ctx context.Context, db *gorm.DB) (*VerifyResult, error) {
+	db = db.WithContext(ctx)
+	result := &VerifyResult{}
+	if err := db.Model(&models.AuditLog{}).Where("seq IS NULL").Count(&result.Legacy).Error; err != nil {
+		return nil, err
+	}
+
+	var prevSeq uint64
+	var prevHash string
+	for {
+		var batch []models.AuditLog
+		if err := db.Where("seq > ?", prevSeq).Order("seq").Limit(verifyBatchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			log := &batch[i]
+			seq := *log.Seq
+			if seq != prevSeq+1 {
+				result.report(seq, log.ID, "entries %d-%d are missing", prevSeq+1, seq-1)
+			}
+			if log.PrevHash != prevHash {
+				result.report(seq, log.ID, "prev_hash does not match the hash of entry %d", prevSeq)
+			}
+			if ComputeHash(log) != log.Hash {
+				result.report(seq, log.ID, "content does not match its hash")
+			}
+			result.Entries++
+			prevSeq, prevHash = seq, log.Hash
+		}
+		if len(batch) < verifyBatchSize {
+			break
+		}
+	}
+	result.LastSeq, result.Head = prevSeq, prevHash
+	return result, nil
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newRecorder(t *testing.T, entries int) (*audit.Recorder, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pkg.AutoMigrate(db))
+
+	recorder := audit.NewRecorder(db)
+	for i := 0; i < entries; i++ {
+		require.NoError(t, recorder.Record(context.Background(), audit.Entry{
+			Actor:  "user:1",
+			Action: "wallet.update",
+			Target: fmt.Sprintf("user:%d", i),
+			Before: map[string]int{"currency_num": i},
+			After:  map[string]int{"currency_num": i + 1},
+		}))
+	}
+	return recorder, db
+}
+
+func TestRecordBuildsHashChain(t *testing.T) {
+	_, db := newRecorder(t, 3)
+
+	var logs []models.AuditLog
+	require.NoError(t, db.Order("seq").Find(&logs).Error)
+	require.Len(t, logs, 3)
+	assert.Empty(t, logs[0].PrevHash)
+	for i, log := range logs {
+		assert.Equal(t, uint64(i+1), *log.Seq)
+		assert.Equal(t, audit.ComputeHash(&log), log.Hash)
+		if i > 0 {
+			assert.Equal(t, logs[i-1].Hash, log.PrevHash)
+		}
+	}
+
+	result, err := audit.Verify(context.Background(), db)
+	require.NoError(t, err)
+	assert.True(t, result.OK(), result.Problems)
+	assert.Equal(t, int64(3), result.Entries)
+	assert.Equal(t, uint64(3), result.LastSeq)
+	assert.Equal(t, logs[2].Hash, result.Head)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	t.Run("modified", func(t *testing.T) {
+		_, db := newRecorder(t, 3)
+		require.NoError(t, db.Model(&models.AuditLog{}).Where("seq = ?", 2).Update("after", `{"currency_num":1000}`).Error)
+
+		result, err := audit.Verify(context.Background(), db)
+		require.NoError(t, err)
+		require.Len(t, result.Problems, 1)
+		assert.Equal(t, uint64(2), result.Problems[0].Seq)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		_, db := newRecorder(t, 3)
+		require.NoError(t, db.Where("seq = ?", 2).Delete(&models.AuditLog{}).Error)
+
+		result, err := audit.Verify(context.Background(), db)
+		require.NoError(t, err)
+		assert.False(t, result.OK())
+		for _, p := range result.Problems {
+			assert.Equal(t, uint64(3), p.Seq)
+		}
+	})
+
+	t.Run("rehashed", func(t *testing.T) {
+		// 修改内容后重新计算自身哈希，下一条的 prev_hash 仍然对不上
+		_, db := newRecorder(t, 3)
+		var log models.AuditLog
+		require.NoError(t, db.Where("seq = ?", 2).First(&log).Error)
+		log.Actor = "user:2"
+		log.Hash = audit.ComputeHash(&log)
+		require.NoError(t, db.Save(&log).Error)
+
+		result, err := audit.Verify(context.Background(), db)
+		require.NoError(t, err)
+		require.Len(t, result.Problems, 1)
+		assert.Equal(t, uint64(3), result.Problems[0].Seq)
+	})
+}
+
+func TestVerifyCountsLegacyEntries(t *testing.T) {
+	_, db := newRecorder(t, 0)
+	require.NoError(t, db.Create(&models.AuditLog{Actor: "system", Action: "auth.lockout"}).Error)
+	recorder := audit.NewRecorder(db)
+	require.NoError(t, recorder.Record(context.Background(), audit.Entry{Action: "auth.unlock"}))
+
+	result, err := audit.Verify(context.Background(), db)
+	require.NoError(t, err)
+	assert.True(t, result.OK(), result.Problems)
+	assert.Equal(t, int64(1), result.Legacy)
+	assert.Equal(t, int64(1), result.Entries)
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	recorder, db := newRecorder(t, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, recorder.Record(context.Background(), audit.Entry{Action: "wallet.debit", Target: fmt.Sprintf("user:%d", i)}))
+		}(i)
+	}
+	wg.Wait()
+
+	result, err := audit.Verify(context.Background(), db)
+	require.NoError(t, err)
+	assert.True(t, result.OK(), result.Problems)
+	assert.Equal(t, uint64(20), result.LastSeq)
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/kakaluote000/demo-api/internal/models"
+	"gorm.io/gorm"
+)
+
+const (
+	apiKeyPrefix    = "dak_"
+	apiKeyPrefixLen = len(apiKeyPrefix) + 8
+)
+
+var (
+	ErrAPIKeyInvalid      = errors.New("invalid api key")
+	ErrAPIKeyExpired      = errors.New("api key has expired")
+	ErrAPIKeyIPNotAllowed = errors.New("client ip is not allowed for this api key")
+)
+
+// APIKeyStore 管理 API key 的创建、校验和吊销
+type APIKeyStore struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyStore(db *gorm.DB) *APIKeyStore {
+	return &APIKeyStore{db: db}
+}
+
+// NewAPIKey 描述要创建的 API key
+type NewAPIKey struct {
+	Name       string
+	Scopes     []Scope
+	AllowedIPs []string
+	ExpiresAt  *time.Time
+	CreatedBy  uint
+}
+
+// Create 创建 API key，返回的明文只有这一次机会获取
+func (s *APIKeyStore) Create(ctx context.Context, req NewAPIKey) (string, *models.APIKey, error) {
+	if len(req.Scopes) == 0 {
+		return "", nil, errors.New("at least one scope is required")
+	}
+	for _, entry := range req.AllowedIPs {
+		if _, err := parseIPNet(entry); err != nil {
+			return "", nil, err
+		}
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(b)
+
+	scopes := make([]string, len(req.Scopes))
+	for i, scope := range req.Scopes {
+		scopes[i] = string(scope)
+	}
+	record := &models.APIKey{
+		Name:       req.Name,
+		Prefix:     key[:apiKeyPrefixLen],
+		KeyHash:    hashAPIKey(key),
+		Scopes:     strings.Join(scopes, ","),
+		AllowedIPs: strings.Join(req.AllowedIPs, ","),
+		ExpiresAt:  req.ExpiresAt,
+		CreatedBy:  req.CreatedBy,
+	}
+	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
+		return "", nil, err
+	}
+	return key, record, nil
+}
+
+// Authenticate 校验 API key 的有效期和来源 IP，成功后记录最后使用时间
+func (s *APIKeyStore) Authenticate(ctx context.Context, key, clientIP string) (*Principal, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+
+	db := s.db.WithContext(ctx)
+	var record models.APIKey
+	if err := db.Where("key_hash = ?", hashAPIKey(key)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.ExpiresAt != nil && now.After(*record.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+	if !ipAllowed(record.AllowedIPs, clientIP) {
+		return nil, ErrAPIKeyIPNotAllowed
+	}
+
+	if err := db.Model(&record).UpdateColumn("last_used_at", now).Error; err != nil {
+		return nil, err
+	}
+
+	var scopes []Scope
+	for _, scope := range strings.Split(record.Scopes, ",") {
+		if scope != "" {
+			scopes = append(scopes, Scope(scope))
+		}
+	}
+	return &Principal{
+		Type:     PrincipalAPIKey,
+		APIKeyID: record.ID,
+		Name:     record.Name,
+		Scopes:   scopes,
+	}, nil
+}
+
+// List 返回未吊销的 API key
+func (s *APIKeyStore) List(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.WithContext(ctx).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke 吊销 API key，吊销后立即不可用
+func (s *APIKeyStore) Revoke(ctx context.Context, id uint) error {
+	result := s.db.WithContext(ctx).Delete(&models.APIKey{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	// key 本身是高熵随机串，SHA-256 即可，无需慢哈希
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseIPNet(entry string) (*net.IPNet, error) {
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip %q", entry)
+		}
+		if ip.To4() != nil {
+			entry += "/32"
+		} else {
+			entry += "/128"
+		}
+	}
+	_, ipNet, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cidr %q", entry)
+	}
+	return ipNet, nil
+}
+
+func ipAllowed(allowList, clientIP string) bool {
+	if allowList == "" {
+		return true
+	}
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, entry := range strings.Split(allowList, ",") {
+		if ipNet, err := parseIPNet(entry); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
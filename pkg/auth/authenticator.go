@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCredentialsMissing = errors.New("no credentials provided")
+	ErrAccessTokenInvalid = errors.New("invalid access token")
+	// ErrAuthUnavailable 无法查询 API key 或 token 吊销状态，此时拒绝请求
+	ErrAuthUnavailable = errors.New("authentication backend unavailable")
+)
+
+// Credentials 调用方携带的凭证，HTTP 取自请求头，gRPC 取自 metadata
+type Credentials struct {
+	// Authorization 为 "Bearer <token>"、"ApiKey <key>" 或不带前缀的 token
+	Authorization string
+	APIKey        string
+	ClientIP      string
+}
+
+func (c Credentials) apiKey() string {
+	if c.APIKey != "" {
+		return c.APIKey
+	}
+	if strings.HasPrefix(c.Authorization, "ApiKey ") {
+		return strings.TrimPrefix(c.Authorization, "ApiKey ")
+	}
+	return ""
+}
+
+// Authenticator 校验 JWT access token 或 API key，REST 和 gRPC 使用同一套规则
+type Authenticator struct {
+	tokens  *TokenStore
+	apiKeys *APIKeyStore
+}
+
+func NewAuthenticator(rdb *redis.Client, db *gorm.DB) *Authenticator {
+	return &Authenticator{tokens: NewTokenStore(rdb, db), apiKeys: NewAPIKeyStore(db)}
+}
+
+// Authenticate 优先使用 API key，否则校验 access token 及其在 Redis 中的吊销状态
+func (a *Authenticator) Authenticate(ctx context.Context, cred Credentials) (*Principal, error) {
+	if key := cred.apiKey(); key != "" {
+		principal, err := a.apiKeys.Authenticate(ctx, key, cred.ClientIP)
+		if err != nil && !errors.Is(err, ErrAPIKeyInvalid) && !errors.Is(err, ErrAPIKeyExpired) && !errors.Is(err, ErrAPIKeyIPNotAllowed) {
+			return nil, fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+		}
+		return principal, err
+	}
+
+	if cred.Authorization == "" {
+		return nil, ErrCredentialsMissing
+	}
+	token := cred.Authorization
+	if len(token) > 7 && strings.HasPrefix(token, "Bearer ") {
+		token = token[7:]
+	}
+	claims, err := ParseToken(token)
+	if err != nil || !claims.IsAccess() {
+		return nil, ErrAccessTokenInvalid
+	}
+
+	revoked, err := a.tokens.IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthUnavailable, err)
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return PrincipalFromClaims(claims), nil
+}
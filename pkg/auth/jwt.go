@@ -1,33 +1,170 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/kakaluote000/demo-api/pkg"
 )
 
-var jwtSecret = []byte("your-secret-key")
+const (
+	defaultExpiry        = 15 * time.Minute
+	defaultRefreshExpiry = 7 * 24 * time.Hour
+
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+	// 两步登录中间状态的 token，只能用于完成两步验证或绑定验证器
+	TokenTypeMFA       = "mfa"
+	TokenTypeMFAEnroll = "mfa_enroll"
+)
+
+var ErrSecretNotConfigured = errors.New("jwt secret is not configured")
+
+var (
+	jwtSecret     []byte
+	keyRing       atomic.Pointer[KeyRing]
+	tokenExpiry   atomic.Int64
+	refreshExpiry atomic.Int64
+)
 
 type Claims struct {
 	UserID uint
+	// 为空时视为普通用户
+	Role string `json:"role,omitempty"`
+	// 为空时视为 access token，兼容旧版本签发的 token
+	TokenType string `json:"typ,omitempty"`
+	// 同一次登录产生的 access/refresh token 共享 SessionID，用于注销单个会话
+	SessionID string `json:"sid,omitempty"`
+	// 用户 token 版本，"注销所有会话" 时递增
+	Version int64 `json:"ver,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IsRefresh 判断是否为 refresh token
+func (c *Claims) IsRefresh() bool {
+	return c.TokenType == TokenTypeRefresh
+}
+
+// IsAccess 判断是否为可访问业务接口的 access token
+func (c *Claims) IsAccess() bool {
+	return c.TokenType == "" || c.TokenType == TokenTypeAccess
+}
+
+// Init 根据安全配置设置签名密钥和过期时间，只应在启动时调用。
+// 配置了非对称密钥时使用 RS256/EdDSA 签名，否则沿用 HS256。
+func Init(cfg pkg.JWTConfig) error {
+	jwtSecret = []byte(cfg.Secret)
+	SetExpiry(cfg.Expiry, cfg.RefreshExpiry)
+	return SetKeys(cfg.Keys)
+}
+
+// SetKeys 重新加载非对称签名密钥，可在配置热更新时调用以完成密钥轮换；
+// 加载失败时保留原有密钥
+func SetKeys(cfgs []pkg.JWTKeyConfig) error {
+	ring, err := LoadKeyRing(cfgs)
+	if err != nil {
+		return err
+	}
+	keyRing.Store(ring)
+	return nil
+}
+
+// CurrentJWKS 返回当前用于校验的公钥集合
+func CurrentJWKS() JWKS {
+	ring := keyRing.Load()
+	if ring == nil {
+		return JWKS{Keys: []JWK{}}
+	}
+	return ring.JWKS(time.Now())
+}
+
+// SetExpiry 更新新签发 token 的有效期，可在配置热更新时调用
+func SetExpiry(access, refresh time.Duration) {
+	if access <= 0 {
+		access = defaultExpiry
+	}
+	if refresh <= 0 {
+		refresh = defaultRefreshExpiry
+	}
+	tokenExpiry.Store(int64(access))
+	refreshExpiry.Store(int64(refresh))
+}
+
+// GenerateToken 签发不属于任何会话的普通用户 access token
 func GenerateToken(userID uint) (string, error) {
-	claims := Claims{
-		UserID: userID,
+	token, _, err := newToken(userID, RoleUser, TokenTypeAccess, "", 0, TokenExpiry())
+	return token, err
+}
+
+func newToken(userID uint, role Role, tokenType, sessionID string, version int64, expiry time.Duration) (string, *Claims, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:    userID,
+		Role:      string(role),
+		TokenType: tokenType,
+		SessionID: sessionID,
+		Version:   version,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        newID(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token, err := signToken(claims, now)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, claims, nil
 }
 
+func signToken(claims *Claims, now time.Time) (string, error) {
+	if ring := keyRing.Load(); ring != nil && len(ring.keys) > 0 {
+		key := ring.signingKey(now)
+		if key == nil {
+			return "", ErrNoSigningKey
+		}
+		token := jwt.NewWithClaims(key.method, claims)
+		token.Header["kid"] = key.kid
+		return token.SignedString(key.private)
+	}
+
+	if len(jwtSecret) == 0 {
+		return "", ErrSecretNotConfigured
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+}
+
+// ParseToken 校验 token：带 kid 的按非对称公钥校验，不带 kid 的按 HS256 密钥校验（兼容旧 token）
 func ParseToken(tokenString string) (*Claims, error) {
+	ring := keyRing.Load()
+	if len(jwtSecret) == 0 && (ring == nil || len(ring.keys) == 0) {
+		return nil, ErrSecretNotConfigured
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if kid, ok := token.Header["kid"].(string); ok {
+			if ring == nil {
+				return nil, ErrUnknownKey
+			}
+			key := ring.verificationKey(kid, time.Now())
+			if key == nil {
+				return nil, ErrUnknownKey
+			}
+			// 算法必须与密钥类型一致，防止算法混淆攻击
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return key.public, nil
+		}
+
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || len(jwtSecret) == 0 {
+			return nil, jwt.ErrSignatureInvalid
+		}
 		return jwtSecret, nil
 	})
 
@@ -40,4 +177,28 @@ func ParseToken(tokenString string) (*Claims, error) {
 	}
 
 	return nil, jwt.ErrSignatureInvalid
-}
\ No newline at end of file
+}
+
+// TokenExpiry 返回当前配置的 access token 有效期
+func TokenExpiry() time.Duration {
+	if expiry := tokenExpiry.Load(); expiry > 0 {
+		return time.Duration(expiry)
+	}
+	return defaultExpiry
+}
+
+// RefreshTokenExpiry 返回当前配置的 refresh token 有效期
+func RefreshTokenExpiry() time.Duration {
+	if expiry := refreshExpiry.Load(); expiry > 0 {
+		return time.Duration(expiry)
+	}
+	return defaultRefreshExpiry
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kakaluote000/demo-api/pkg"
+)
+
+var (
+	ErrNoSigningKey = errors.New("no active jwt signing key")
+	ErrUnknownKey   = errors.New("unknown jwt key id")
+)
+
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// 只用于校验的旧密钥为 nil
+	private    interface{}
+	public     interface{}
+	activeFrom time.Time
+	expiresAt  time.Time
+}
+
+func (k *signingKey) expired(now time.Time) bool {
+	return !k.expiresAt.IsZero() && !now.Before(k.expiresAt)
+}
+
+// KeyRing 保存所有非对称签名密钥，签名时按时间选择当前密钥，校验时按 kid 查找
+type KeyRing struct {
+	// 按 activeFrom 升序
+	keys []*signingKey
+}
+
+// LoadKeyRing 从文件加载密钥，要求当前至少有一把可用于签名的密钥
+func LoadKeyRing(cfgs []pkg.JWTKeyConfig) (*KeyRing, error) {
+	ring := &KeyRing{}
+	for _, cfg := range cfgs {
+		key, err := loadKey(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %q: %w", cfg.KID, err)
+		}
+		ring.keys = append(ring.keys, key)
+	}
+	sort.SliceStable(ring.keys, func(i, j int) bool {
+		return ring.keys[i].activeFrom.Before(ring.keys[j].activeFrom)
+	})
+
+	if len(ring.keys) > 0 && ring.signingKey(time.Now()) == nil {
+		return nil, ErrNoSigningKey
+	}
+	return ring, nil
+}
+
+// signingKey 返回已生效且未过期的密钥中 activeFrom 最晚的一把
+func (r *KeyRing) signingKey(now time.Time) *signingKey {
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		key := r.keys[i]
+		if key.private != nil && !now.Before(key.activeFrom) && !key.expired(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (r *KeyRing) verificationKey(kid string, now time.Time) *signingKey {
+	for _, key := range r.keys {
+		if key.kid == kid && !key.expired(now) {
+			return key
+		}
+	}
+	return nil
+}
+
+// JWK 是 RFC 7517 中的单个公钥
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS 返回所有未过期的公钥，包括尚未生效的密钥，便于校验方提前缓存
+func (r *KeyRing) JWKS(now time.Time) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if key.expired(now) {
+			continue
+		}
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+func loadKey(cfg pkg.JWTKeyConfig) (*signingKey, error) {
+	key := &signingKey{
+		kid:        cfg.KID,
+		activeFrom: cfg.ActiveFrom,
+		expiresAt:  cfg.ExpiresAt,
+	}
+
+	if cfg.PrivateKeyFile != "" {
+		block, err := readPEM(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		private, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			key.public = &private.PublicKey
+		case ed25519.PrivateKey:
+			key.public = private.Public()
+		}
+	}
+
+	if cfg.PublicKeyFile != "" {
+		block, err := readPEM(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		if key.private == nil {
+			key.public = public
+		}
+	}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		if public.N.BitLen() < 2048 {
+			return nil, errors.New("rsa key must be at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, only RSA and Ed25519 are supported", key.public)
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	return private, nil
+}
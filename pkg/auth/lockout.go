@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/pkg"
+)
+
+const (
+	LockoutScopeUser = "user"
+	LockoutScopeIP   = "ip"
+)
+
+var defaultLockoutConfig = pkg.LockoutConfig{
+	MaxFailures:   5,
+	IPMaxFailures: 50,
+	Window:        15 * time.Minute,
+	Duration:      15 * time.Minute,
+	DelayAfter:    3,
+	BaseDelay:     time.Second,
+	MaxDelay:      30 * time.Second,
+}
+
+// Lockout 描述一次因连续失败触发的锁定
+type Lockout struct {
+	Scope    string        `json:"scope"`
+	Subject  string        `json:"subject"`
+	Failures int64         `json:"failures"`
+	Duration time.Duration `json:"duration"`
+}
+
+// LoginGuard 基于 Redis 按用户名和 IP 统计登录失败，实现递增延迟和临时锁定。
+// 计数与用户是否存在无关，避免通过响应差异探测账号。
+type LoginGuard struct {
+	rdb *redis.Client
+	cfg atomic.Pointer[pkg.LockoutConfig]
+}
+
+func NewLoginGuard(rdb *redis.Client, cfg pkg.LockoutConfig) *LoginGuard {
+	g := &LoginGuard{rdb: rdb}
+	g.SetConfig(cfg)
+	return g
+}
+
+// SetConfig 更新锁定策略，未配置的字段使用默认值
+func (g *LoginGuard) SetConfig(cfg pkg.LockoutConfig) {
+	d := defaultLockoutConfig
+	if cfg.MaxFailures > 0 {
+		d.MaxFailures = cfg.MaxFailures
+	}
+	if cfg.IPMaxFailures > 0 {
+		d.IPMaxFailures = cfg.IPMaxFailures
+	}
+	if cfg.Window > 0 {
+		d.Window = cfg.Window
+	}
+	if cfg.Duration > 0 {
+		d.Duration = cfg.Duration
+	}
+	if cfg.DelayAfter > 0 {
+		d.DelayAfter = cfg.DelayAfter
+	}
+	if cfg.BaseDelay > 0 {
+		d.BaseDelay = cfg.BaseDelay
+	}
+	if cfg.MaxDelay > 0 {
+		d.MaxDelay = cfg.MaxDelay
+	}
+	g.cfg.Store(&d)
+}
+
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+func loginFailuresKey(scope, subject string) string { return "login_failures:" + scope + ":" + subject }
+func loginLockKey(scope, subject string) string     { return "login_lock:" + scope + ":" + subject }
+func loginDelayKey(username string) string          { return "login_delay:" + username }
+
+// Check 返回本次登录前需要等待的时间，0 表示可以尝试
+func (g *LoginGuard) Check(ctx context.Context, username, ip string) (time.Duration, error) {
+	username = normalizeUsername(username)
+	pipe := g.rdb.Pipeline()
+	ttls := []*redis.DurationCmd{
+		pipe.PTTL(ctx, loginLockKey(LockoutScopeUser, username)),
+		pipe.PTTL(ctx, loginLockKey(LockoutScopeIP, ip)),
+		pipe.PTTL(ctx, loginDelayKey(username)),
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, ttl := range ttls {
+		if ttl.Val() > wait {
+			wait = ttl.Val()
+		}
+	}
+	return wait, nil
+}
+
+// RecordFailure 记录一次失败，返回本次触发的锁定（可能为空）
+func (g *LoginGuard) RecordFailure(ctx context.Context, username, ip string) ([]Lockout, error) {
+	cfg := g.cfg.Load()
+	username = normalizeUsername(username)
+
+	var lockouts []Lockout
+	userFailures, err := g.incrFailures(ctx, LockoutScopeUser, username, cfg.Window)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case userFailures >= int64(cfg.MaxFailures):
+		if err := g.lock(ctx, LockoutScopeUser, username, cfg.Duration); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, Lockout{Scope: LockoutScopeUser, Subject: username, Failures: userFailures, Duration: cfg.Duration})
+	case userFailures >= int64(cfg.DelayAfter):
+		delay := cfg.BaseDelay << (userFailures - int64(cfg.DelayAfter))
+		if delay <= 0 || delay > cfg.MaxDelay {
+			delay = cfg.MaxDelay
+		}
+		if err := g.rdb.Set(ctx, loginDelayKey(username), 1, delay).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	ipFailures, err := g.incrFailures(ctx, LockoutScopeIP, ip, cfg.Window)
+	if err != nil {
+		return nil, err
+	}
+	if ipFailures >= int64(cfg.IPMaxFailures) {
+		if err := g.lock(ctx, LockoutScopeIP, ip, cfg.Duration); err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, Lockout{Scope: LockoutScopeIP, Subject: ip, Failures: ipFailures, Duration: cfg.Duration})
+	}
+	return lockouts, nil
+}
+
+// RecordSuccess 登录成功后清除该用户名的失败计数，IP 计数保留
+func (g *LoginGuard) RecordSuccess(ctx context.Context, username string) error {
+	username = normalizeUsername(username)
+	return g.rdb.Del(ctx, loginFailuresKey(LockoutScopeUser, username), loginDelayKey(username)).Err()
+}
+
+// Unlock 解除用户名的锁定并清除失败计数，返回之前是否处于锁定状态
+func (g *LoginGuard) Unlock(ctx context.Context, username string) (bool, error) {
+	username = normalizeUsername(username)
+	n, err := g.rdb.Del(ctx, loginLockKey(LockoutScopeUser, username), loginFailuresKey(LockoutScopeUser, username), loginDelayKey(username)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// incrFailuresScript 增加失败计数，窗口从第一次失败开始计算。
+// INCR 和 PEXPIRE 在同一脚本中执行，不会因为中途失败留下没有过期时间的计数
+var incrFailuresScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n
+`)
+
+func (g *LoginGuard) incrFailures(ctx context.Context, scope, subject string, window time.Duration) (int64, error) {
+	return incrFailuresScript.Run(ctx, g.rdb, []string{loginFailuresKey(scope, subject)}, window.Milliseconds()).Int64()
+}
+
+func (g *LoginGuard) lock(ctx context.Context, scope, subject string, duration time.Duration) error {
+	pipe := g.rdb.TxPipeline()
+	pipe.Set(ctx, loginLockKey(scope, subject), 1, duration)
+	pipe.Del(ctx, loginFailuresKey(scope, subject))
+	if scope == LockoutScopeUser {
+		pipe.Del(ctx, loginDelayKey(subject))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const defaultMFAChallengeExpiry = 5 * time.Minute
+
+var ErrMFAChallengeInvalid = errors.New("invalid mfa challenge")
+
+func usedTOTPKey(userID uint, counter int64) string {
+	return fmt.Sprintf("totp_used:%d:%d", userID, counter)
+}
+
+// RequiresMFA 判断角色是否必须启用两步验证
+func RequiresMFA(role Role, requiredRoles []string) bool {
+	for _, r := range requiredRoles {
+		if Role(r) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IssueMFAChallenge 密码校验通过后签发短期的两步验证凭证，
+// tokenType 为 TokenTypeMFA（提交验证码）或 TokenTypeMFAEnroll（先绑定验证器）
+func (s *TokenStore) IssueMFAChallenge(ctx context.Context, userID uint, role Role, tokenType string, expiry time.Duration) (string, *Claims, error) {
+	if expiry <= 0 {
+		expiry = defaultMFAChallengeExpiry
+	}
+	version, err := s.tokenVersion(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	return newToken(userID, role, tokenType, "", version, expiry)
+}
+
+// ParseMFAChallenge 校验两步验证凭证的类型和吊销状态
+func (s *TokenStore) ParseMFAChallenge(ctx context.Context, token, tokenType string) (*Claims, error) {
+	claims, err := ParseToken(token)
+	if err != nil || claims.TokenType != tokenType {
+		return nil, ErrMFAChallengeInvalid
+	}
+	revoked, err := s.IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrMFAChallengeInvalid
+	}
+	return claims, nil
+}
+
+// MarkTOTPUsed 记录已使用的 TOTP 时间步，同一验证码只能使用一次。返回 false 表示已被使用
+func (s *TokenStore) MarkTOTPUsed(ctx context.Context, userID uint, counter int64) (bool, error) {
+	// 时间步加上允许的偏差后验证码不再有效，保留 2 分钟足够
+	return s.rdb.SetNX(ctx, usedTOTPKey(userID, counter), 1, 2*time.Minute).Result()
+}
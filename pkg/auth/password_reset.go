@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const defaultPasswordResetExpiry = 30 * time.Minute
+
+var ErrResetTokenInvalid = errors.New("invalid or expired password reset token")
+
+// PasswordResetStore 基于 Redis 保存一次性的密码重置 token，只保存 token 的哈希
+type PasswordResetStore struct {
+	rdb *redis.Client
+}
+
+func NewPasswordResetStore(rdb *redis.Client) *PasswordResetStore {
+	return &PasswordResetStore{rdb: rdb}
+}
+
+func passwordResetKey(hash string) string     { return "password_reset:" + hash }
+func passwordResetUserKey(userID uint) string { return fmt.Sprintf("password_reset_user:%d", userID) }
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue 为用户签发新的重置 token，之前未使用的 token 随即失效
+func (s *PasswordResetStore) Issue(ctx context.Context, userID uint, expiry time.Duration) (string, error) {
+	if expiry <= 0 {
+		expiry = defaultPasswordResetExpiry
+	}
+	token := newID() + newID()
+	hash := hashResetToken(token)
+
+	previous, err := s.rdb.GetSet(ctx, passwordResetUserKey(userID), hash).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+	pipe := s.rdb.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, passwordResetKey(previous))
+	}
+	pipe.Expire(ctx, passwordResetUserKey(userID), expiry)
+	pipe.Set(ctx, passwordResetKey(hash), userID, expiry)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Lookup 返回 token 对应的用户但不作废 token，用于在消耗 token 前校验新密码
+func (s *PasswordResetStore) Lookup(ctx context.Context, token string) (uint, error) {
+	return parseResetUserID(s.rdb.Get(ctx, passwordResetKey(hashResetToken(token))).Result())
+}
+
+// Consume 校验并作废 token，返回对应的用户
+func (s *PasswordResetStore) Consume(ctx context.Context, token string) (uint, error) {
+	userID, err := parseResetUserID(s.rdb.GetDel(ctx, passwordResetKey(hashResetToken(token))).Result())
+	if err != nil {
+		return 0, err
+	}
+	s.rdb.Del(ctx, passwordResetUserKey(userID))
+	return userID, nil
+}
+
+func parseResetUserID(value string, err error) (uint, error) {
+	if err == redis.Nil {
+		return 0, ErrResetTokenInvalid
+	}
+	if err != nil {
+		return 0, err
+	}
+	userID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, ErrResetTokenInvalid
+	}
+	return uint(userID), nil
+}
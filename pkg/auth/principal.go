@@ -0,0 +1,68 @@
+package auth
+
+import "fmt"
+
+type PrincipalType string
+
+const (
+	PrincipalUser   PrincipalType = "user"
+	PrincipalAPIKey PrincipalType = "api_key"
+)
+
+// Principal 是通过认证的调用方，JWT 用户和 API key 统一用它做授权判断
+type Principal struct {
+	Type PrincipalType
+	// 用户 ID，API key 为 0
+	UserID uint
+	Role   Role
+	// API key ID 和名称，仅 API key 有值
+	APIKeyID uint
+	Name     string
+	Scopes   []Scope
+	// 仅 JWT 有值，注销会话时使用
+	Claims *Claims
+}
+
+// PrincipalFromClaims 由 access token 构造用户身份，未知角色按无权限处理
+func PrincipalFromClaims(claims *Claims) *Principal {
+	role, ok := ParseRole(claims.Role)
+	if !ok {
+		role = Role(claims.Role)
+	}
+	return &Principal{
+		Type:   PrincipalUser,
+		UserID: claims.UserID,
+		Role:   role,
+		Claims: claims,
+	}
+}
+
+// Can 判断调用方是否拥有权限
+func (p *Principal) Can(perm Permission) bool {
+	if p.Type == PrincipalAPIKey {
+		for _, scope := range p.Scopes {
+			if scope.HasPermission(perm) {
+				return true
+			}
+		}
+		return false
+	}
+	return p.Role.HasPermission(perm)
+}
+
+// CanAccessUser 判断调用方能否操作 userID 的资源：
+// 本人需要 ownPerm，操作他人需要 anyPerm
+func (p *Principal) CanAccessUser(userID uint, ownPerm, anyPerm Permission) bool {
+	if p.Can(anyPerm) {
+		return true
+	}
+	return p.Type == PrincipalUser && p.UserID == userID && p.Can(ownPerm)
+}
+
+// String 用于日志和审计，如 user:1、api_key:3
+func (p *Principal) String() string {
+	if p.Type == PrincipalAPIKey {
+		return fmt.Sprintf("%s:%d", p.Type, p.APIKeyID)
+	}
+	return fmt.Sprintf("%s:%d", p.Type, p.UserID)
+}
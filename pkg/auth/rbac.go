@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Role string
+
+const (
+	RoleUser     Role = "user"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+	RoleAuditor  Role = "auditor"
+)
+
+type Permission string
+
+const (
+	// 查看自己的钱包
+	PermWalletRead Permission = "wallet:read"
+	// 查看任意用户的钱包
+	PermWalletReadAll Permission = "wallet:read_all"
+	// 从自己的钱包扣减
+	PermWalletSpend Permission = "wallet:spend"
+	// 从任意用户的钱包扣减
+	PermWalletDebit Permission = "wallet:debit"
+	// 向任意用户发放货币
+	PermWalletCredit Permission = "wallet:credit"
+	// 创建钱包、直接修改余额
+	PermWalletManage Permission = "wallet:manage"
+	// 上报告警
+	PermAlertWrite Permission = "alerts:write"
+	// 管理 API key
+	PermAPIKeyManage Permission = "api_keys:manage"
+	// 管理用户账号
+	PermUserManage Permission = "users:manage"
+	// 查询和导出审计日志，只授予审计员
+	PermAuditRead Permission = "audit:read"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:     {PermWalletRead, PermWalletSpend},
+	RoleOperator: {PermWalletRead, PermWalletReadAll, PermWalletSpend, PermWalletDebit, PermWalletCredit, PermWalletManage},
+	RoleAdmin:    {PermWalletRead, PermWalletReadAll, PermWalletSpend, PermWalletDebit, PermWalletCredit, PermWalletManage, PermAPIKeyManage, PermUserManage},
+	RoleAuditor:  {PermWalletRead, PermWalletReadAll, PermAuditRead},
+}
+
+// Scope 是 API key 的授权范围，服务间调用不区分资源归属
+type Scope string
+
+const (
+	ScopeWalletRead   Scope = "wallet:read"
+	ScopeWalletCredit Scope = "wallet:credit"
+	ScopeWalletDebit  Scope = "wallet:debit"
+	ScopeAlertsWrite  Scope = "alerts:write"
+)
+
+var scopePermissions = map[Scope][]Permission{
+	ScopeWalletRead:   {PermWalletRead, PermWalletReadAll},
+	ScopeWalletCredit: {PermWalletCredit},
+	ScopeWalletDebit:  {PermWalletDebit},
+	ScopeAlertsWrite:  {PermAlertWrite},
+}
+
+// Roles 返回所有角色
+func Roles() []Role {
+	return []Role{RoleUser, RoleOperator, RoleAdmin, RoleAuditor}
+}
+
+// ParseRole 校验角色名，空字符串视为普通用户（兼容没有角色的旧数据和旧 token）
+func ParseRole(name string) (Role, bool) {
+	if name == "" {
+		return RoleUser, true
+	}
+	role := Role(name)
+	_, ok := rolePermissions[role]
+	return role, ok
+}
+
+// HasPermission 判断角色是否拥有权限，未知角色没有任何权限
+func (r Role) HasPermission(perm Permission) bool {
+	return containsPermission(rolePermissions[r], perm)
+}
+
+// ParseScopes 校验 API key 的授权范围并去重
+func ParseScopes(names []string) ([]Scope, error) {
+	seen := make(map[Scope]bool, len(names))
+	scopes := make([]Scope, 0, len(names))
+	for _, name := range names {
+		scope := Scope(name)
+		if _, ok := scopePermissions[scope]; !ok {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// HasPermission 判断授权范围是否包含权限
+func (s Scope) HasPermission(perm Permission) bool {
+	return containsPermission(scopePermissions[s], perm)
+}
+
+func containsPermission(perms []Permission, perm Permission) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrNotRefreshToken     = errors.New("not a refresh token")
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+)
+
+// TokenPair 登录或刷新后返回给客户端的 token
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	// access token 剩余有效秒数
+	ExpiresIn int64
+}
+
+// 用户禁用状态在 Redis 中的缓存时间，缓存丢失或过期后从数据库重新读取
+const userDisabledCacheTTL = 10 * time.Minute
+
+// TokenStore 基于 Redis 管理会话：refresh token 轮换、吊销名单和用户 token 版本。
+// 用户禁用状态以数据库为准，Redis 只作缓存
+type TokenStore struct {
+	rdb *redis.Client
+	db  *gorm.DB
+}
+
+func NewTokenStore(rdb *redis.Client, db *gorm.DB) *TokenStore {
+	return &TokenStore{rdb: rdb, db: db}
+}
+
+func refreshTokenKey(jti string) string   { return "refresh_token:" + jti }
+func revokedTokenKey(jti string) string   { return "revoked_token:" + jti }
+func revokedSessionKey(sid string) string { return "revoked_session:" + sid }
+func tokenVersionKey(userID uint) string  { return fmt.Sprintf("user_token_version:%d", userID) }
+func disabledUserKey(userID uint) string  { return fmt.Sprintf("user_disabled:%d", userID) }
+
+// IssueTokens 为新会话签发 access token 和 refresh token，角色写入 token
+func (s *TokenStore) IssueTokens(ctx context.Context, userID uint, role Role) (*TokenPair, error) {
+	version, err := s.tokenVersion(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(ctx, userID, role, newID(), version)
+}
+
+func (s *TokenStore) issue(ctx context.Context, userID uint, role Role, sessionID string, version int64) (*TokenPair, error) {
+	accessToken, _, err := newToken(userID, role, TokenTypeAccess, sessionID, version, TokenExpiry())
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshClaims, err := newToken(userID, role, TokenTypeRefresh, sessionID, version, RefreshTokenExpiry())
+	if err != nil {
+		return nil, err
+	}
+
+	// 只有登记在 Redis 中的 refresh token 才能使用，使用后立即删除
+	if err := s.rdb.Set(ctx, refreshTokenKey(refreshClaims.ID), sessionID, RefreshTokenExpiry()).Err(); err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(TokenExpiry().Seconds()),
+	}, nil
+}
+
+// Refresh 使用 refresh token 换取新的 token 对，旧的 refresh token 随即失效。
+// 已使用过的 refresh token 再次出现说明可能被盗用，此时吊销整个会话。
+func (s *TokenStore) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	claims, err := ParseToken(refreshToken)
+	if err != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if !claims.IsRefresh() || claims.SessionID == "" {
+		return nil, ErrNotRefreshToken
+	}
+
+	revoked, err := s.IsRevoked(ctx, claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+
+	_, err = s.rdb.GetDel(ctx, refreshTokenKey(claims.ID)).Result()
+	if err == redis.Nil {
+		if err := s.revokeSession(ctx, claims.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 角色沿用登录时的值，角色变更后需 RevokeAll 让用户重新登录
+	return s.issue(ctx, claims.UserID, Role(claims.Role), claims.SessionID, claims.Version)
+}
+
+// Revoke 注销 token 所属的会话，当前 access token 立即失效
+func (s *TokenStore) Revoke(ctx context.Context, claims *Claims) error {
+	pipe := s.rdb.TxPipeline()
+	if ttl := remaining(claims); ttl > 0 {
+		pipe.Set(ctx, revokedTokenKey(claims.ID), 1, ttl)
+	}
+	if claims.SessionID != "" {
+		pipe.Set(ctx, revokedSessionKey(claims.SessionID), 1, RefreshTokenExpiry())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeAll 注销用户的所有会话，之前签发的 access token 和 refresh token 全部失效
+func (s *TokenStore) RevokeAll(ctx context.Context, userID uint) error {
+	return s.rdb.Incr(ctx, tokenVersionKey(userID)).Err()
+}
+
+// SetUserDisabled 在数据库中更新禁用状态后调用，刷新缓存。禁用时注销所有会话，禁用期间任何 token 都视为已吊销
+func (s *TokenStore) SetUserDisabled(ctx context.Context, userID uint, disabled bool) error {
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, disabledUserKey(userID), disabledValue(disabled), userDisabledCacheTTL)
+	if disabled {
+		pipe.Incr(ctx, tokenVersionKey(userID))
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// IsRevoked 检查 token 是否已被吊销（单个 token、所属会话、用户全部会话或用户被禁用）
+func (s *TokenStore) IsRevoked(ctx context.Context, claims *Claims) (bool, error) {
+	pipe := s.rdb.Pipeline()
+	var tokenRevoked, sessionRevoked *redis.IntCmd
+	if claims.ID != "" {
+		tokenRevoked = pipe.Exists(ctx, revokedTokenKey(claims.ID))
+	}
+	if claims.SessionID != "" {
+		sessionRevoked = pipe.Exists(ctx, revokedSessionKey(claims.SessionID))
+	}
+	userDisabled := pipe.Get(ctx, disabledUserKey(claims.UserID))
+	version := pipe.Get(ctx, tokenVersionKey(claims.UserID))
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	disabled, err := s.userDisabled(ctx, claims.UserID, userDisabled)
+	if err != nil {
+		return false, err
+	}
+	if disabled {
+		return true, nil
+	}
+
+	if tokenRevoked != nil && tokenRevoked.Val() > 0 {
+		return true, nil
+	}
+	if sessionRevoked != nil && sessionRevoked.Val() > 0 {
+		return true, nil
+	}
+	current, err := parseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	return claims.Version < current, nil
+}
+
+// userDisabled 读取用户是否被禁用。缓存未命中时查询数据库并写回缓存，用户不存在（已删除）时视为禁用
+func (s *TokenStore) userDisabled(ctx context.Context, userID uint, cached *redis.StringCmd) (bool, error) {
+	value, err := cached.Result()
+	if err == nil {
+		return value == disabledValue(true), nil
+	}
+	if err != redis.Nil {
+		return false, err
+	}
+
+	var user models.User
+	err = s.db.WithContext(ctx).Select("id", "disabled_at").First(&user, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	disabled := user.DisabledAt != nil
+	// 写回失败不影响本次判断，下次请求会再查询数据库
+	s.rdb.SetNX(ctx, disabledUserKey(userID), disabledValue(disabled), userDisabledCacheTTL)
+	return disabled, nil
+}
+
+func disabledValue(disabled bool) string {
+	if disabled {
+		return "1"
+	}
+	return "0"
+}
+
+func (s *TokenStore) revokeSession(ctx context.Context, sessionID string) error {
+	return s.rdb.Set(ctx, revokedSessionKey(sessionID), 1, RefreshTokenExpiry()).Err()
+}
+
+func (s *TokenStore) tokenVersion(ctx context.Context, userID uint) (int64, error) {
+	return parseVersion(s.rdb.Get(ctx, tokenVersionKey(userID)))
+}
+
+func parseVersion(cmd *redis.StringCmd) (int64, error) {
+	value, err := cmd.Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+func remaining(claims *Claims) time.Duration {
+	if claims.ExpiresAt == nil {
+		return 0
+	}
+	return time.Until(claims.ExpiresAt.Time)
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newAPIKeyStore(t *testing.T) (*auth.APIKeyStore, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, pkg.AutoMigrate(db))
+	return auth.NewAPIKeyStore(db), db
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store, db := newAPIKeyStore(t)
+	ctx := context.Background()
+
+	key, record, err := store.Create(ctx, auth.NewAPIKey{
+		Name:   "billing",
+		Scopes: []auth.Scope{auth.ScopeWalletRead, auth.ScopeWalletCredit},
+	})
+	require.NoError(t, err)
+	assert.True(t, len(record.Prefix) < len(key))
+	assert.NotContains(t, record.KeyHash, key)
+	assert.Nil(t, record.LastUsedAt)
+
+	principal, err := store.Authenticate(ctx, key, "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, auth.PrincipalAPIKey, principal.Type)
+	assert.Equal(t, record.ID, principal.APIKeyID)
+	assert.True(t, principal.Can(auth.PermWalletReadAll))
+	assert.True(t, principal.Can(auth.PermWalletCredit))
+	assert.False(t, principal.Can(auth.PermWalletDebit))
+	assert.False(t, principal.Can(auth.PermWalletManage))
+	// API key 没有"本人"的概念，只能依靠授权范围
+	assert.False(t, principal.CanAccessUser(0, auth.PermWalletSpend, auth.PermWalletDebit))
+
+	var stored models.APIKey
+	require.NoError(t, db.First(&stored, record.ID).Error)
+	require.NotNil(t, stored.LastUsedAt)
+
+	_, err = store.Authenticate(ctx, key+"x", "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyInvalid)
+	_, err = store.Authenticate(ctx, "not-an-api-key", "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyInvalid)
+
+	require.NoError(t, store.Revoke(ctx, record.ID))
+	_, err = store.Authenticate(ctx, key, "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyInvalid)
+}
+
+func TestAPIKeyExpiryAndIPAllowList(t *testing.T) {
+	store, _ := newAPIKeyStore(t)
+	ctx := context.Background()
+
+	expired := time.Now().Add(-time.Minute)
+	key, _, err := store.Create(ctx, auth.NewAPIKey{Name: "old", Scopes: []auth.Scope{auth.ScopeWalletRead}, ExpiresAt: &expired})
+	require.NoError(t, err)
+	_, err = store.Authenticate(ctx, key, "10.0.0.1")
+	assert.ErrorIs(t, err, auth.ErrAPIKeyExpired)
+
+	key, _, err = store.Create(ctx, auth.NewAPIKey{
+		Name:       "internal",
+		Scopes:     []auth.Scope{auth.ScopeAlertsWrite},
+		AllowedIPs: []string{"10.0.0.0/8", "192.168.1.10"},
+	})
+	require.NoError(t, err)
+
+	for ip, allowed := range map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+		"invalid":      false,
+	} {
+		_, err := store.Authenticate(ctx, key, ip)
+		if allowed {
+			assert.NoError(t, err, ip)
+		} else {
+			assert.ErrorIs(t, err, auth.ErrAPIKeyIPNotAllowed, ip)
+		}
+	}
+
+	_, _, err = store.Create(ctx, auth.NewAPIKey{Name: "bad", Scopes: []auth.Scope{auth.ScopeWalletRead}, AllowedIPs: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := auth.ParseScopes([]string{"wallet:read", "wallet:debit", "wallet:read"})
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Scope{auth.ScopeWalletRead, auth.ScopeWalletDebit}, scopes)
+
+	_, err = auth.ParseScopes([]string{"wallet:manage"})
+	assert.Error(t, err)
+	_, err = auth.ParseScopes(nil)
+	assert.Error(t, err)
+}
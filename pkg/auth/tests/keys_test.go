@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePrivateKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func writePublicKey(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pub.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	return path
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return key
+}
+
+func tokenHeader(t *testing.T, token string) map[string]interface{} {
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &auth.Claims{})
+	require.NoError(t, err)
+	return parsed.Header
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edKey := newEd25519Key(t)
+
+	for name, tc := range map[string]struct {
+		key crypto.Signer
+		alg string
+	}{
+		"RS256": {rsaKey, "RS256"},
+		"EdDSA": {edKey, "EdDSA"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, auth.Init(pkg.JWTConfig{Keys: []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: writePrivateKey(t, tc.key)}}}))
+
+			token, err := auth.GenerateToken(7)
+			require.NoError(t, err)
+			header := tokenHeader(t, token)
+			assert.Equal(t, tc.alg, header["alg"])
+			assert.Equal(t, "k1", header["kid"])
+
+			claims, err := auth.ParseToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, uint(7), claims.UserID)
+
+			jwks := auth.CurrentJWKS()
+			require.Len(t, jwks.Keys, 1)
+			assert.Equal(t, "k1", jwks.Keys[0].Kid)
+			assert.Equal(t, tc.alg, jwks.Keys[0].Alg)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey, futureKey := newEd25519Key(t), newEd25519Key(t), newEd25519Key(t)
+	now := time.Now()
+	oldCfg := pkg.JWTKeyConfig{KID: "old", PrivateKeyFile: writePrivateKey(t, oldKey)}
+	newCfg := pkg.JWTKeyConfig{KID: "new", PrivateKeyFile: writePrivateKey(t, newKey), ActiveFrom: now.Add(-time.Minute)}
+	futureCfg := pkg.JWTKeyConfig{KID: "future", PrivateKeyFile: writePrivateKey(t, futureKey), ActiveFrom: now.Add(time.Hour)}
+
+	require.NoError(t, auth.Init(pkg.JWTConfig{Keys: []pkg.JWTKeyConfig{oldCfg}}))
+	oldToken, err := auth.GenerateToken(1)
+	require.NoError(t, err)
+
+	// 新密钥生效后用于签名，旧密钥在宽限期内仍可校验，未生效的密钥提前发布
+	oldCfg.ExpiresAt = now.Add(time.Hour)
+	require.NoError(t, auth.SetKeys([]pkg.JWTKeyConfig{oldCfg, newCfg, futureCfg}))
+	newToken, err := auth.GenerateToken(1)
+	require.NoError(t, err)
+	assert.Equal(t, "new", tokenHeader(t, newToken)["kid"])
+
+	_, err = auth.ParseToken(oldToken)
+	assert.NoError(t, err)
+	_, err = auth.ParseToken(newToken)
+	assert.NoError(t, err)
+
+	var kids []string
+	for _, key := range auth.CurrentJWKS().Keys {
+		kids = append(kids, key.Kid)
+	}
+	assert.ElementsMatch(t, []string{"old", "new", "future"}, kids)
+
+	// 宽限期结束后旧 token 失效，旧公钥不再发布
+	oldCfg.ExpiresAt = now.Add(-time.Second)
+	require.NoError(t, auth.SetKeys([]pkg.JWTKeyConfig{oldCfg, newCfg}))
+	_, err = auth.ParseToken(oldToken)
+	assert.ErrorIs(t, err, auth.ErrUnknownKey)
+	_, err = auth.ParseToken(newToken)
+	assert.NoError(t, err)
+	assert.Len(t, auth.CurrentJWKS().Keys, 1)
+
+	// 只有未生效的密钥时无法签名，加载失败并保留原有密钥
+	assert.ErrorIs(t, auth.SetKeys([]pkg.JWTKeyConfig{futureCfg}), auth.ErrNoSigningKey)
+	_, err = auth.GenerateToken(1)
+	assert.NoError(t, err)
+}
+
+func TestVerifyOnlyPublicKey(t *testing.T) {
+	key := newEd25519Key(t)
+	signer := pkg.JWTKeyConfig{KID: "remote", PrivateKeyFile: writePrivateKey(t, key)}
+	require.NoError(t, auth.Init(pkg.JWTConfig{Keys: []pkg.JWTKeyConfig{signer}}))
+	token, err := auth.GenerateToken(1)
+	require.NoError(t, err)
+
+	verifier := pkg.JWTKeyConfig{KID: "remote", PublicKeyFile: writePublicKey(t, key.Public())}
+	local := pkg.JWTKeyConfig{KID: "local", PrivateKeyFile: writePrivateKey(t, newEd25519Key(t))}
+	require.NoError(t, auth.SetKeys([]pkg.JWTKeyConfig{verifier, local}))
+
+	_, err = auth.ParseToken(token)
+	assert.NoError(t, err)
+	token, err = auth.GenerateToken(1)
+	require.NoError(t, err)
+	assert.Equal(t, "local", tokenHeader(t, token)["kid"])
+}
+
+func TestHS256Compatibility(t *testing.T) {
+	require.NoError(t, auth.Init(pkg.JWTConfig{Secret: "legacy-secret"}))
+	legacyToken, err := auth.GenerateToken(1)
+	require.NoError(t, err)
+	assert.Equal(t, "HS256", tokenHeader(t, legacyToken)["alg"])
+	assert.Empty(t, auth.CurrentJWKS().Keys)
+
+	keys := []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: writePrivateKey(t, newEd25519Key(t))}}
+
+	// 迁移期间保留 secret，旧 token 仍然有效
+	require.NoError(t, auth.Init(pkg.JWTConfig{Secret: "legacy-secret", Keys: keys}))
+	_, err = auth.ParseToken(legacyToken)
+	assert.NoError(t, err)
+
+	require.NoError(t, auth.Init(pkg.JWTConfig{Keys: keys}))
+	_, err = auth.ParseToken(legacyToken)
+	assert.Error(t, err)
+}
+
+func TestRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	require.NoError(t, auth.Init(pkg.JWTConfig{Secret: "legacy-secret", Keys: []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: writePrivateKey(t, rsaKey)}}}))
+
+	// 用公钥作为 HMAC 密钥伪造带 kid 的 token
+	publicPEM, err := os.ReadFile(writePublicKey(t, &rsaKey.PublicKey))
+	require.NoError(t, err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{UserID: 1})
+	forged.Header["kid"] = "k1"
+	token, err := forged.SignedString(publicPEM)
+	require.NoError(t, err)
+
+	_, err = auth.ParseToken(token)
+	assert.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLoginGuard(t *testing.T, cfg pkg.LockoutConfig) (*auth.LoginGuard, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return auth.NewLoginGuard(rdb, cfg), mr
+}
+
+func TestLoginGuardDelayAndLockout(t *testing.T) {
+	guard, mr := newLoginGuard(t, pkg.LockoutConfig{
+		MaxFailures: 4,
+		Duration:    10 * time.Minute,
+		DelayAfter:  2,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Second,
+	})
+	ctx := context.Background()
+
+	fail := func() []auth.Lockout {
+		lockouts, err := guard.RecordFailure(ctx, "Alice", "10.0.0.1")
+		require.NoError(t, err)
+		return lockouts
+	}
+	wait := func(username string) time.Duration {
+		d, err := guard.Check(ctx, username, "10.0.0.2")
+		require.NoError(t, err)
+		return d
+	}
+
+	assert.Empty(t, fail())
+	assert.Zero(t, wait("alice"))
+
+	// 第 2 次失败起需要等待，且每次翻倍
+	assert.Empty(t, fail())
+	assert.Equal(t, time.Second, wait("alice"))
+	mr.FastForward(time.Second)
+	assert.Zero(t, wait("alice"))
+
+	assert.Empty(t, fail())
+	assert.Equal(t, 2*time.Second, wait("alice"))
+	mr.FastForward(2 * time.Second)
+
+	// 达到上限后锁定，用户名不区分大小写
+	lockouts := fail()
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, auth.LockoutScopeUser, lockouts[0].Scope)
+	assert.Equal(t, "alice", lockouts[0].Subject)
+	assert.Equal(t, int64(4), lockouts[0].Failures)
+	assert.Equal(t, 10*time.Minute, wait(" ALICE "))
+	assert.Zero(t, wait("bob"))
+
+	mr.FastForward(10 * time.Minute)
+	assert.Zero(t, wait("alice"))
+}
+
+func TestLoginGuardIPLockout(t *testing.T) {
+	guard, _ := newLoginGuard(t, pkg.LockoutConfig{MaxFailures: 100, IPMaxFailures: 3, DelayAfter: 100})
+	ctx := context.Background()
+
+	var lockouts []auth.Lockout
+	for _, username := range []string{"a", "b", "c"} {
+		var err error
+		lockouts, err = guard.RecordFailure(ctx, username, "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, auth.LockoutScopeIP, lockouts[0].Scope)
+
+	d, err := guard.Check(ctx, "d", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Positive(t, d)
+	d, err = guard.Check(ctx, "d", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+}
+
+func TestLoginGuardFailureWindow(t *testing.T) {
+	guard, mr := newLoginGuard(t, pkg.LockoutConfig{MaxFailures: 100, DelayAfter: 100, Window: time.Minute})
+	ctx := context.Background()
+	key := "login_failures:user:alice"
+
+	_, err := guard.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, mr.TTL(key))
+
+	// 窗口从第一次失败开始计算，后续失败不会延长
+	mr.FastForward(30 * time.Second)
+	_, err = guard.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, mr.TTL(key))
+
+	// 没有过期时间的计数会被补上，不会永久保留
+	mr.Set(key, "7")
+	_, err = guard.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	v, err := mr.Get(key)
+	require.NoError(t, err)
+	assert.Equal(t, "8", v)
+	assert.Equal(t, time.Minute, mr.TTL(key))
+}
+
+func TestLoginGuardResetAndUnlock(t *testing.T) {
+	guard, _ := newLoginGuard(t, pkg.LockoutConfig{MaxFailures: 3, DelayAfter: 100})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		_, err := guard.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+	}
+	// 登录成功后重新计数
+	require.NoError(t, guard.RecordSuccess(ctx, "alice"))
+	lockouts, err := guard.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Empty(t, lockouts)
+
+	for i := 0; i < 2; i++ {
+		lockouts, err = guard.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.Len(t, lockouts, 1)
+
+	unlocked, err := guard.Unlock(ctx, "alice")
+	require.NoError(t, err)
+	assert.True(t, unlocked)
+	d, err := guard.Check(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, d)
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordResetToken(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := auth.NewPasswordResetStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := context.Background()
+
+	token, err := store.Issue(ctx, 7, time.Minute)
+	require.NoError(t, err)
+
+	// 只能使用一次
+	userID, err := store.Consume(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(7), userID)
+	_, err = store.Consume(ctx, token)
+	assert.ErrorIs(t, err, auth.ErrResetTokenInvalid)
+
+	// 重新申请后旧 token 失效
+	first, err := store.Issue(ctx, 7, time.Minute)
+	require.NoError(t, err)
+	second, err := store.Issue(ctx, 7, time.Minute)
+	require.NoError(t, err)
+	_, err = store.Consume(ctx, first)
+	assert.ErrorIs(t, err, auth.ErrResetTokenInvalid)
+
+	// 过期后失效
+	mr.FastForward(2 * time.Minute)
+	_, err = store.Consume(ctx, second)
+	assert.ErrorIs(t, err, auth.ErrResetTokenInvalid)
+}
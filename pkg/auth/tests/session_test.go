@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func newTokenStore(t *testing.T) *auth.TokenStore {
+	store, _, _ := newTokenStoreWithBackends(t)
+	return store
+}
+
+// newTokenStoreWithBackends 返回 token store 及其数据库和 Redis，数据库中有用户 1 和 2
+func newTokenStoreWithBackends(t *testing.T) (*auth.TokenStore, *gorm.DB, *miniredis.Miniredis) {
+	auth.Init(pkg.JWTConfig{Secret: "test-secret", Expiry: time.Minute, RefreshExpiry: time.Hour})
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, pkg.AutoMigrate(db))
+	for _, id := range []uint{1, 2} {
+		require.NoError(t, db.Create(&models.User{Model: gorm.Model{ID: id}, Username: fmt.Sprintf("user%d", id), Password: "x"}).Error)
+	}
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return auth.NewTokenStore(rdb, db), db, mr
+}
+
+func TestIssueTokens(t *testing.T) {
+	store := newTokenStore(t)
+	ctx := context.Background()
+
+	pair, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), pair.ExpiresIn)
+
+	access, err := auth.ParseToken(pair.AccessToken)
+	require.NoError(t, err)
+	refresh, err := auth.ParseToken(pair.RefreshToken)
+	require.NoError(t, err)
+
+	assert.False(t, access.IsRefresh())
+	assert.True(t, refresh.IsRefresh())
+	assert.Equal(t, uint(1), access.UserID)
+	assert.Equal(t, string(auth.RoleUser), access.Role)
+	assert.Equal(t, access.SessionID, refresh.SessionID)
+	assert.NotEqual(t, access.ID, refresh.ID)
+}
+
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	store := newTokenStore(t)
+	ctx := context.Background()
+
+	pair, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+
+	rotated, err := store.Refresh(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, pair.RefreshToken, rotated.RefreshToken)
+
+	// access token 不能用来刷新
+	_, err = store.Refresh(ctx, rotated.AccessToken)
+	assert.ErrorIs(t, err, auth.ErrNotRefreshToken)
+
+	// 旧 refresh token 再次使用视为被盗用，整个会话被吊销
+	_, err = store.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+
+	_, err = store.Refresh(ctx, rotated.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+
+	claims, err := auth.ParseToken(rotated.AccessToken)
+	require.NoError(t, err)
+	revoked, err := store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestRevokeSession(t *testing.T) {
+	store := newTokenStore(t)
+	ctx := context.Background()
+
+	first, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	second, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+
+	claims, err := auth.ParseToken(first.AccessToken)
+	require.NoError(t, err)
+	require.NoError(t, store.Revoke(ctx, claims))
+
+	revoked, err := store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = store.Refresh(ctx, first.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+
+	// 其他会话不受影响
+	other, err := auth.ParseToken(second.AccessToken)
+	require.NoError(t, err)
+	revoked, err = store.IsRevoked(ctx, other)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestRevokeAll(t *testing.T) {
+	store := newTokenStore(t)
+	ctx := context.Background()
+
+	before, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	otherUser, err := store.IssueTokens(ctx, 2, auth.RoleUser)
+	require.NoError(t, err)
+
+	require.NoError(t, store.RevokeAll(ctx, 1))
+
+	claims, err := auth.ParseToken(before.AccessToken)
+	require.NoError(t, err)
+	revoked, err := store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	_, err = store.Refresh(ctx, before.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+
+	// 注销后重新登录签发的 token 有效
+	after, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	claims, err = auth.ParseToken(after.AccessToken)
+	require.NoError(t, err)
+	revoked, err = store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	claims, err = auth.ParseToken(otherUser.AccessToken)
+	require.NoError(t, err)
+	revoked, err = store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestDisabledUserSurvivesCacheLoss(t *testing.T) {
+	store, db, mr := newTokenStoreWithBackends(t)
+	ctx := context.Background()
+
+	pair, err := store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	claims, err := auth.ParseToken(pair.AccessToken)
+	require.NoError(t, err)
+
+	// 禁用状态写在数据库中，Redis 缓存丢失后仍然生效
+	now := time.Now()
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", 1).Update("disabled_at", &now).Error)
+	mr.FlushAll()
+	revoked, err := store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// 启用后刷新缓存，之后签发的 token 有效
+	require.NoError(t, db.Model(&models.User{}).Where("id = ?", 1).Update("disabled_at", nil).Error)
+	require.NoError(t, store.SetUserDisabled(ctx, 1, false))
+	pair, err = store.IssueTokens(ctx, 1, auth.RoleUser)
+	require.NoError(t, err)
+	claims, err = auth.ParseToken(pair.AccessToken)
+	require.NoError(t, err)
+	revoked, err = store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	// 已删除的用户视为禁用
+	require.NoError(t, db.Delete(&models.User{}, 2).Error)
+	pair, err = store.IssueTokens(ctx, 2, auth.RoleUser)
+	require.NoError(t, err)
+	claims, err = auth.ParseToken(pair.AccessToken)
+	require.NoError(t, err)
+	revoked, err = store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+}
@@ -2,20 +2,72 @@ package pkg
 
 import (
 	"fmt"
+	"net"
+	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	Log      LogConfig
+	Server       ServerConfig
+	Database     DatabaseConfig
+	Redis        RedisConfig
+	Log          LogConfig
+	Security     SecurityConfig
+	Notification NotificationConfig
+	Alert        AlertConfig
+	Tracing      TracingConfig
 }
 
 type ServerConfig struct {
 	Port int
 	Mode string
+	// 收到退出信号后，readiness 先失败一段时间再停止监听，便于负载均衡摘除流量
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+	// 等待进行中请求完成的最长时间
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	TLS             TLSConfig
+	// 可信的反向代理（IP 或 CIDR），只有来自这些地址的请求才使用 X-Forwarded-For 和 X-Real-IP 中的客户端 IP。
+	// 默认不信任任何代理，客户端 IP 为连接的对端地址
+	TrustedProxies []string      `mapstructure:"trusted_proxies"`
+	OpenAPI        OpenAPIConfig `mapstructure:"openapi"`
+	GRPC           GRPCConfig    `mapstructure:"grpc"`
+	Events         EventsConfig  `mapstructure:"events"`
+}
+
+// EventsConfig 余额变更推送（SSE 和 gRPC 订阅）
+type EventsConfig struct {
+	// SSE 心跳间隔，用于保持连接并让客户端发现中断
+	Heartbeat time.Duration
+	// 每个用户保留的事件数，断线重连时只能从这些事件中恢复
+	HistorySize int64 `mapstructure:"history_size"`
+	// 用户没有新事件后历史保留的时间
+	HistoryTTL time.Duration `mapstructure:"history_ttl"`
+}
+
+// GRPCConfig 账本 gRPC 服务，与 HTTP 服务使用不同的端口。启用 TLS 时使用同一份证书
+type GRPCConfig struct {
+	Enabled bool
+	Port    int
+}
+
+// OpenAPIConfig 按内嵌的 OpenAPI 文档校验请求和响应
+type OpenAPIConfig struct {
+	// 拒绝不符合文档的请求
+	ValidateRequests bool `mapstructure:"validate_requests"`
+	// 校验响应，不符合文档时返回 500。响应会被完整缓冲，只应在测试中开启
+	ValidateResponses bool `mapstructure:"validate_responses"`
+}
+
+type TLSConfig struct {
+	Enabled  bool
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// 配置后 /monitoring 和管理路由要求客户端证书 (mTLS)
+	ClientCAFile string `mapstructure:"client_ca_file"`
 }
 
 type DatabaseConfig struct {
@@ -38,31 +90,372 @@ type RedisConfig struct {
 }
 
 type LogConfig struct {
-	Level      string
-	Filename   string
+	Level string
+	// 输出位置：file、stdout 或 both
+	Output   string
+	Filename string
+	// 单个日志文件的最大大小 (MB)、保留天数和保留个数
 	MaxSize    int
 	MaxAge     int
 	MaxBackups int
+	Compress   bool
+}
+
+// TracingConfig OpenTelemetry 链路追踪
+type TracingConfig struct {
+	Enabled     bool
+	ServiceName string `mapstructure:"service_name"`
+	// 导出方式：none、stdout、file 或 otlp
+	Exporter string
+	// otlp 导出地址，例如 localhost:4318
+	Endpoint string
+	Insecure bool
+	// file 导出时写入的文件
+	FilePath    string  `mapstructure:"file_path"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// NotificationConfig 告警通知渠道
+type NotificationConfig struct {
+	Webhooks []string
+	// 向单个用户投递消息（如密码重置）的 webhook，请求体为 {"user": ..., "text": ...}
+	UserWebhooks []string `mapstructure:"user_webhooks"`
+}
+
+// AlertConfig 告警处理相关设置
+type AlertConfig struct {
+	// 告警在 Redis 中保留的时长，用于统计
+	Retention time.Duration
+	// 是否对未处理的告警进行升级
+	EscalationEnabled bool `mapstructure:"escalation_enabled"`
+}
+
+// SecurityConfig 对应 config/security.yaml 中的 security 段
+type SecurityConfig struct {
+	JWT      JWTConfig
+	Password PasswordConfig
+	// 新密码使用的哈希算法，旧哈希在登录时自动升级
+	PasswordHash PasswordHashConfig `mapstructure:"password_hash"`
+	RateLimit    RateLimitConfig    `mapstructure:"rate_limit"`
+	CORS         CORSConfig
+	Lockout      LockoutConfig
+	MFA          MFAConfig
+	// 密码重置
+	PasswordReset PasswordResetConfig `mapstructure:"password_reset"`
+	// 没有管理员时启动创建的管理员
+	BootstrapAdmin BootstrapAdminConfig `mapstructure:"bootstrap_admin"`
+}
+
+type JWTConfig struct {
+	// HS256 密钥。未配置 Keys 时用于签名；配置了 Keys 时只用于校验旧 token，可留空
+	Secret string
+	// access token 有效期
+	Expiry        time.Duration
+	RefreshExpiry time.Duration `mapstructure:"refresh_expiry"`
+	// RS256/EdDSA 签名密钥，按 kid 区分，可通过热更新加入新密钥完成轮换
+	Keys []JWTKeyConfig
+}
+
+// JWTKeyConfig 描述一把非对称签名密钥，算法由密钥类型决定（RSA 为 RS256，Ed25519 为 EdDSA）
+type JWTKeyConfig struct {
+	KID string `mapstructure:"kid"`
+	// PEM 格式私钥，只用于校验的旧密钥可以只配置公钥
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+	// 开始用于签名的时间，为空表示立即生效；已生效的密钥中 active_from 最晚的用于签名
+	ActiveFrom time.Time `mapstructure:"active_from"`
+	// 过期后不再接受该密钥签发的 token，为空表示不过期；被新密钥替换后到过期前为宽限期
+	ExpiresAt time.Time `mapstructure:"expires_at"`
 }
 
+type PasswordConfig struct {
+	MinLength int `mapstructure:"min_length"`
+	// 为 0 时不限制
+	MaxLength        int  `mapstructure:"max_length"`
+	RequireSpecial   bool `mapstructure:"require_special"`
+	RequireNumber    bool `mapstructure:"require_number"`
+	RequireUppercase bool `mapstructure:"require_uppercase"`
+	RequireLowercase bool `mapstructure:"require_lowercase"`
+	// 拒绝包含用户名的密码（不区分大小写）
+	RejectUsername bool `mapstructure:"reject_username"`
+	// 常见或已泄露密码列表，每行一个，为空时不检查
+	DenylistFile string `mapstructure:"denylist_file"`
+}
+
+// PasswordHashConfig 密码哈希算法和参数
+type PasswordHashConfig struct {
+	// argon2id 或 bcrypt
+	Algorithm  string
+	Argon2     Argon2Config
+	BcryptCost int `mapstructure:"bcrypt_cost"`
+}
+
+type Argon2Config struct {
+	// 内存，单位 KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 `mapstructure:"salt_length"`
+	KeyLength   uint32 `mapstructure:"key_length"`
+}
+
+// RateLimitConfig 按客户端限流，已登录用户按用户 ID，API key 按 key，其他请求按 IP 分别计数
+type RateLimitConfig struct {
+	// 默认策略，未单独配置的路由共用一个计数
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int
+	// 按路由覆盖默认策略，每条路由单独计数
+	Routes []RouteRateLimit
+	// 认证之前按 IP 计数，所有需要认证的路由共用，认证失败的请求同样计数。未配置时使用默认策略
+	IP IPRateLimit `mapstructure:"ip"`
+}
+
+type IPRateLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int
+}
+
+type RouteRateLimit struct {
+	Method string
+	// gin 路由模板，如 /userCurrency/:id
+	Path              string
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int
+}
+
+// LockoutConfig 登录失败限制，按用户名和来源 IP 分别计数
+type LockoutConfig struct {
+	// 统计窗口内用户名失败达到次数后锁定
+	MaxFailures int `mapstructure:"max_failures"`
+	// 统计窗口内同一 IP 失败达到次数后锁定该 IP
+	IPMaxFailures int `mapstructure:"ip_max_failures"`
+	// 失败次数的统计窗口
+	Window time.Duration
+	// 锁定时长
+	Duration time.Duration
+	// 失败达到次数后开始延迟，每次失败延迟翻倍，不超过 MaxDelay
+	DelayAfter int           `mapstructure:"delay_after"`
+	BaseDelay  time.Duration `mapstructure:"base_delay"`
+	MaxDelay   time.Duration `mapstructure:"max_delay"`
+}
+
+// PasswordResetConfig 密码重置链接通过 notification.user_webhooks 发送
+type PasswordResetConfig struct {
+	// 重置 token 有效期
+	Expiry time.Duration
+	// 重置页面地址，token 作为 token 查询参数附加在后面；为空时只发送 token
+	URL string
+}
+
+// BootstrapAdminConfig 启动时数据库中没有可用的管理员则创建该用户，用户已存在时提升为管理员。
+// 用户名为空时不处理
+type BootstrapAdminConfig struct {
+	Username string
+	// 只在创建用户时使用，需符合密码策略
+	Password string
+}
+
+// MFAConfig 两步验证 (TOTP) 配置
+type MFAConfig struct {
+	// 必须启用两步验证的角色，未绑定验证器的用户登录时需先完成绑定
+	RequiredRoles []string `mapstructure:"required_roles"`
+	// 验证器 App 中显示的服务名
+	Issuer string
+	// 密码校验通过后提交验证码的时限
+	ChallengeExpiry time.Duration `mapstructure:"challenge_expiry"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+}
+
+// 生产环境禁止使用的示例密钥
+var placeholderSecrets = map[string]bool{
+	"":                     true,
+	"your-secret-key":      true,
+	"your-secret-key-here": true,
+	"change-me":            true,
+}
+
+// AppConfig 为启动时加载的配置，结构性配置（数据库、Redis 等）以此为准；
+// 可热更新的配置请通过 CurrentConfig 读取
 var AppConfig Config
 
-func InitConfig() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath("./config")
+const configDir = "./config"
+
+// 参与加载与热更新的配置文件，后者覆盖前者
+var configFiles = []string{"config", "security"}
 
-	if err := viper.ReadInConfig(); err != nil {
-		Log.Fatalf("Error reading config file: %v", err)
+func InitConfig() {
+	cfg, err := loadConfig()
+	if err != nil {
+		Log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	if err := viper.Unmarshal(&AppConfig); err != nil {
-		Log.Fatalf("Unable to decode config into struct: %v", err)
+	AppConfig = *cfg
+	currentConfig.Store(cfg)
+	if err := ConfigureLogger(cfg.Log); err != nil {
+		Log.Fatalf("Invalid log configuration: %v", err)
 	}
 
 	Log.Info("Configuration loaded successfully")
 }
 
+// loadConfig 从配置目录读取并合并所有配置文件，解析后进行校验
+func loadConfig() (*Config, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.AddConfigPath(configDir)
+
+	// 允许通过环境变量覆盖配置，例如 SECURITY_JWT_SECRET
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	for i, name := range configFiles {
+		v.SetConfigName(name)
+		var err error
+		if i == 0 {
+			err = v.ReadInConfig()
+		} else {
+			err = v.MergeInConfig()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s config file: %w", name, err)
+		}
+	}
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+	))); err != nil {
+		return nil, fmt.Errorf("unable to decode config into struct: %w", err)
+	}
+
+	if err := ValidateConfig(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// ValidateConfig 校验配置，生产模式下拒绝使用示例 JWT 密钥
+func ValidateConfig(cfg *Config) error {
+	jwt := cfg.Security.JWT
+	if IsProduction(cfg) {
+		// 使用非对称密钥时可以不配置 HS256 密钥，但配置了就不能是示例值
+		if len(jwt.Keys) == 0 && placeholderSecrets[jwt.Secret] {
+			return fmt.Errorf("security.jwt.secret must be set to a non-placeholder value in %s mode", cfg.Server.Mode)
+		}
+		if len(jwt.Keys) > 0 && jwt.Secret != "" && placeholderSecrets[jwt.Secret] {
+			return fmt.Errorf("security.jwt.secret must be empty or a non-placeholder value in %s mode", cfg.Server.Mode)
+		}
+	}
+	kids := make(map[string]bool, len(jwt.Keys))
+	for _, key := range jwt.Keys {
+		if key.KID == "" {
+			return fmt.Errorf("security.jwt.keys: kid is required")
+		}
+		if kids[key.KID] {
+			return fmt.Errorf("security.jwt.keys: duplicate kid %q", key.KID)
+		}
+		kids[key.KID] = true
+		if key.PrivateKeyFile == "" && key.PublicKeyFile == "" {
+			return fmt.Errorf("security.jwt.keys: key %q needs private_key_file or public_key_file", key.KID)
+		}
+	}
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("server.trusted_proxies: invalid ip or cidr %q", proxy)
+			}
+		}
+	}
+	if tls := cfg.Server.TLS; tls.Enabled && (tls.CertFile == "" || tls.KeyFile == "") {
+		return fmt.Errorf("server.tls.cert_file and server.tls.key_file are required when tls is enabled")
+	}
+	if cfg.Security.JWT.Expiry < 0 || cfg.Security.JWT.RefreshExpiry < 0 {
+		return fmt.Errorf("security.jwt expiry values must not be negative")
+	}
+	if rl := cfg.Security.RateLimit; rl.RequestsPerSecond < 0 || rl.Burst < 0 || rl.IP.RequestsPerSecond < 0 || rl.IP.Burst < 0 {
+		return fmt.Errorf("security.rate_limit values must not be negative")
+	}
+	for _, route := range cfg.Security.RateLimit.Routes {
+		if route.Method == "" || !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("security.rate_limit.routes entries need a method and a path starting with /")
+		}
+		if route.RequestsPerSecond < 0 || route.Burst < 0 {
+			return fmt.Errorf("security.rate_limit.routes values for %s %s must not be negative", route.Method, route.Path)
+		}
+	}
+	if l := cfg.Security.Lockout; l.MaxFailures < 0 || l.IPMaxFailures < 0 || l.DelayAfter < 0 ||
+		l.Window < 0 || l.Duration < 0 || l.BaseDelay < 0 || l.MaxDelay < 0 {
+		return fmt.Errorf("security.lockout values must not be negative")
+	}
+	if p := cfg.Security.Password; p.MinLength < 0 || p.MaxLength < 0 || (p.MaxLength > 0 && p.MaxLength < p.MinLength) {
+		return fmt.Errorf("security.password.max_length must not be less than min_length")
+	}
+	switch ph := cfg.Security.PasswordHash; ph.Algorithm {
+	case "", "argon2id":
+	case "bcrypt":
+		// 与 bcrypt.MinCost、bcrypt.MaxCost 一致
+		if ph.BcryptCost != 0 && (ph.BcryptCost < 4 || ph.BcryptCost > 31) {
+			return fmt.Errorf("security.password_hash.bcrypt_cost must be between 4 and 31")
+		}
+	default:
+		return fmt.Errorf("security.password_hash.algorithm must be argon2id or bcrypt")
+	}
+	if cfg.Security.PasswordReset.Expiry < 0 {
+		return fmt.Errorf("security.password_reset.expiry must not be negative")
+	}
+	if cfg.Security.MFA.ChallengeExpiry < 0 {
+		return fmt.Errorf("security.mfa.challenge_expiry must not be negative")
+	}
+	if cfg.Log.Level != "" {
+		if _, err := logrus.ParseLevel(cfg.Log.Level); err != nil {
+			return fmt.Errorf("log.level: %w", err)
+		}
+	}
+	switch cfg.Log.Output {
+	case "", LogOutputFile, LogOutputStdout, LogOutputBoth:
+	default:
+		return fmt.Errorf("log.output must be one of file, stdout or both")
+	}
+	for _, url := range cfg.Notification.Webhooks {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return fmt.Errorf("notification.webhooks: invalid url %q", url)
+		}
+	}
+	for _, url := range cfg.Notification.UserWebhooks {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return fmt.Errorf("notification.user_webhooks: invalid url %q", url)
+		}
+	}
+	if t := cfg.Tracing; t.Enabled {
+		switch t.Exporter {
+		case "", "none", "stdout", "otlp":
+		case "file":
+			if t.FilePath == "" {
+				return fmt.Errorf("tracing.file_path is required for the file exporter")
+			}
+		default:
+			return fmt.Errorf("tracing.exporter must be one of none, stdout, file or otlp")
+		}
+	}
+	if cfg.Alert.Retention < 0 {
+		return fmt.Errorf("alert.retention must not be negative")
+	}
+	return nil
+}
+
+// IsProduction 判断是否运行在生产模式
+func IsProduction(cfg *Config) bool {
+	return cfg.Server.Mode == "release" || cfg.Server.Mode == "production"
+}
+
 func GetDSN() string {
 	db := AppConfig.Database
 	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&parseTime=%v&loc=%s",
@@ -1,6 +1,8 @@
 package pkg
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/kakaluote000/demo-api/internal/models"
@@ -11,11 +13,11 @@ import (
 
 func InitDB() *gorm.DB {
 	dsn := GetDSN()
-	
+
 	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: newGormLogger().LogMode(logger.Info),
 	})
-	
+
 	if err != nil {
 		Log.Fatalf("failed to connect database: %v", err)
 	}
@@ -30,14 +32,88 @@ func InitDB() *gorm.DB {
 	sqlDB.SetMaxOpenConns(100)
 	sqlDB.SetConnMaxLifetime(time.Hour)
 
-	// 自动迁移
-	AutoMigrate(db)
+	// 自动迁移，失败时不启动，否则唯一索引等约束可能缺失
+	if err := AutoMigrate(db); err != nil {
+		Log.Fatalf("failed to migrate database: %v", err)
+	}
 
 	return db
 }
 
-func AutoMigrate(db *gorm.DB) {
-	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.UserCurrency{})
-	db.AutoMigrate(&models.CurrencyTransaction{})
+// IsDuplicateKey 判断错误是否为违反唯一索引，不依赖 gorm.Config.TranslateError
+func IsDuplicateKey(db *gorm.DB, err error) bool {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		return errors.Is(translator.Translate(err), gorm.ErrDuplicatedKey)
+	}
+	return false
+}
+
+func AutoMigrate(db *gorm.DB) error {
+	if err := mergeDuplicateWallets(db); err != nil {
+		return fmt.Errorf("merge duplicate wallets: %w", err)
+	}
+	return db.AutoMigrate(
+		&models.User{},
+		&models.UserCurrency{},
+		&models.CurrencyTransaction{},
+		&models.APIKey{},
+		&models.AuditLog{},
+		&models.RecoveryCode{},
+	)
+}
+
+// mergeDuplicateWallets 在创建 idx_user_currency 唯一索引前合并同一用户同一币种的多个钱包。
+// 早期版本没有唯一索引，并发创建可能留下重复记录。保留 ID 最小的未删除记录，余额为所有未删除记录之和，其余记录被删除
+func mergeDuplicateWallets(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.UserCurrency{}) || migrator.HasIndex(&models.UserCurrency{}, "idx_user_currency") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var groups []struct {
+			UserID     uint
+			CurrencyID uint
+		}
+		if err := tx.Unscoped().Model(&models.UserCurrency{}).Select("user_id, currency_id").
+			Group("user_id, currency_id").Having("COUNT(*) > 1").Scan(&groups).Error; err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			var wallets []models.UserCurrency
+			if err := tx.Unscoped().Where("user_id = ? AND currency_id = ?", group.UserID, group.CurrencyID).
+				Order("id").Find(&wallets).Error; err != nil {
+				return err
+			}
+			keep := wallets[0]
+			var balance uint
+			var active []uint
+			for _, wallet := range wallets {
+				if wallet.DeletedAt.Valid {
+					continue
+				}
+				if len(active) == 0 {
+					keep = wallet
+				}
+				active = append(active, wallet.ID)
+				balance += wallet.CurrencyNum
+			}
+
+			if err := tx.Unscoped().Where("user_id = ? AND currency_id = ? AND id <> ?", group.UserID, group.CurrencyID, keep.ID).
+				Delete(&models.UserCurrency{}).Error; err != nil {
+				return err
+			}
+			if len(active) > 0 {
+				if err := tx.Model(&keep).Update("currency_num", balance).Error; err != nil {
+					return err
+				}
+			}
+			Log.Warnf("Merged %d wallets of user %d currency %d into wallet %d, balance %d", len(wallets), group.UserID, group.CurrencyID, keep.ID, balance)
+		}
+		return nil
+	})
 }
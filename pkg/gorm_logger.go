@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// 超过该耗时的 SQL 以 warn 级别记录
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger 将 GORM 日志写入请求级日志，使 SQL 日志同样带有请求 ID 和 trace ID
+type gormLogger struct {
+	level logger.LogLevel
+}
+
+func newGormLogger() logger.Interface {
+	return &gormLogger{level: logger.Warn}
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &gormLogger{level: level}
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		LoggerFromContext(ctx).Infof(msg, args...)
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		LoggerFromContext(ctx).Warnf(msg, args...)
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		LoggerFromContext(ctx).Errorf(msg, args...)
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	entry := LoggerFromContext(ctx)
+	fields := func() logrus.Fields {
+		sql, rows := fc()
+		return logrus.Fields{"sql": sql, "rows": rows, "elapsed_ms": float64(elapsed.Microseconds()) / 1000}
+	}
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		entry.WithFields(fields()).WithError(err).Error("SQL error")
+	case elapsed > slowQueryThreshold && l.level >= logger.Warn:
+		entry.WithFields(fields()).Warn("Slow SQL")
+	case l.level >= logger.Info:
+		entry.WithFields(fields()).Debug("SQL")
+	}
+}
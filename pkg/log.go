@@ -1,32 +1,99 @@
 package pkg
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"os"
 
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	LogOutputFile   = "file"
+	LogOutputStdout = "stdout"
+	LogOutputBoth   = "both"
 )
 
 var Log *logrus.Logger
 
 func init() {
 	Log = InitLogrus()
-	Log.Info("Logger initialized")
 }
 
+// InitLogrus 创建默认日志，加载配置前输出到标准输出
 func InitLogrus() *logrus.Logger {
 	log := logrus.New()
+	log.SetOutput(os.Stdout)
 
-	// 创建日志文件
-	file, err := os.OpenFile("app.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	// 设置日志格式
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	return log
+}
+
+// ConfigureLogger 按 LogConfig 设置日志级别、输出位置和文件切割，
+// 直接修改 Log 本身，已持有 Log 的包无需重新获取
+func ConfigureLogger(cfg LogConfig) error {
+	output, err := newLogOutput(cfg)
 	if err != nil {
-		log.Fatalf("Failed to log to file, using default stderr")
+		return err
 	}
+	Log.SetOutput(output)
+	applyLogLevel(cfg)
+	return nil
+}
 
-	// 设置日志输出到文件
-	log.SetOutput(file)
+func newLogOutput(cfg LogConfig) (io.Writer, error) {
+	switch cfg.Output {
+	case LogOutputStdout:
+		return os.Stdout, nil
+	case LogOutputFile, LogOutputBoth, "":
+	default:
+		return nil, fmt.Errorf("unknown log output %q", cfg.Output)
+	}
 
-	// 设置日志格式
-	log.SetFormatter(&logrus.JSONFormatter{})
+	filename := cfg.Filename
+	if filename == "" {
+		filename = "app.log"
+	}
 
-	return log
+	// 提前检查文件是否可写，失败时退回标准输出而不是静默丢失日志
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		Log.WithError(err).Warnf("Failed to open log file %s, logging to stdout", filename)
+		return os.Stdout, nil
+	}
+	file.Close()
+
+	rotating := &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     cfg.MaxAge,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+	}
+	if cfg.Output == LogOutputBoth {
+		return io.MultiWriter(os.Stdout, rotating), nil
+	}
+	return rotating, nil
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger 将请求级日志存入 context
+func ContextWithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// LoggerFromContext 返回请求级日志，携带请求 ID、用户 ID 和路由等字段；
+// context 中没有时返回全局日志
+func LoggerFromContext(ctx context.Context) *logrus.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+			return entry
+		}
+	}
+	return logrus.NewEntry(Log)
 }
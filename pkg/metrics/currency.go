@@ -0,0 +1,14 @@
+package metrics
+
+import "strconv"
+
+// RecordCurrencyOperation 记录货币操作次数和金额分布
+func RecordCurrencyOperation(operation string, currencyID, amount uint) {
+	CurrencyOperations.WithLabelValues(operation).Inc()
+	CurrencyAmount.WithLabelValues(CurrencyLabel(currencyID), operation).Observe(float64(amount))
+}
+
+// CurrencyLabel 币种 ID 作为指标标签
+func CurrencyLabel(currencyID uint) string {
+	return strconv.FormatUint(uint64(currencyID), 10)
+}
@@ -11,7 +11,7 @@ var (
 			Name: "http_requests_total",
 			Help: "Total number of HTTP requests",
 		},
-		[]string{"method", "path", "status"},
+		[]string{"method", "route", "status"},
 	)
 
 	RequestDuration = promauto.NewHistogramVec(
@@ -20,7 +20,7 @@ var (
 			Help:    "HTTP request duration in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"method", "path"},
+		[]string{"method", "route"},
 	)
 
 	CurrencyOperations = promauto.NewCounterVec(
@@ -30,4 +30,109 @@ var (
 		},
 		[]string{"operation_type"},
 	)
-)
\ No newline at end of file
+
+	CurrencyAmount = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "currency_operation_amount",
+			Help:    "Amount moved by currency operations",
+			Buckets: prometheus.ExponentialBuckets(1, 10, 8),
+		},
+		[]string{"currency", "operation_type"},
+	)
+
+	InsufficientFunds = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "currency_insufficient_funds_total",
+			Help: "Total number of operations rejected for insufficient funds",
+		},
+		[]string{"currency"},
+	)
+
+	CirculatingSupply = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "currency_circulating_supply",
+			Help: "Total amount of each currency held by all users",
+		},
+		[]string{"currency"},
+	)
+
+	ConfigReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of configuration reload attempts",
+		},
+		[]string{"result"},
+	)
+
+	LoginAttempts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "login_attempts_total",
+			Help: "Total number of login attempts by result",
+		},
+		[]string{"result"},
+	)
+
+	AccountLockouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "login_lockouts_total",
+			Help: "Total number of lockouts triggered by repeated login failures",
+		},
+		[]string{"scope"},
+	)
+
+	RateLimitRejections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limit_rejections_total",
+			Help: "Total number of requests rejected by the rate limiter",
+		},
+		[]string{"method", "route"},
+	)
+
+	RateLimitDegraded = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rate_limit_degraded",
+			Help: "Whether the rate limiter has fallen back to per-instance limits because Redis is unavailable",
+		},
+	)
+
+	DeprecatedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_deprecated_requests_total",
+			Help: "Total number of requests to deprecated route aliases",
+		},
+		[]string{"method", "route"},
+	)
+
+	GRPCRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "grpc_requests_total",
+			Help: "Total number of gRPC requests by status code",
+		},
+		[]string{"method", "code"},
+	)
+
+	GRPCRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "grpc_request_duration_seconds",
+			Help:    "gRPC request duration in seconds, streams are measured until they end",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method"},
+	)
+
+	BalanceSubscribers = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "balance_subscribers",
+			Help: "Number of open balance change subscriptions",
+		},
+		[]string{"transport"},
+	)
+
+	BalanceSubscriptionsDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "balance_subscriptions_dropped_total",
+			Help: "Number of balance change subscriptions closed by the server so that the client resumes from history",
+		},
+		[]string{"reason"},
+	)
+)
@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/kakaluote000/demo-api/internal/models"
+	"gorm.io/gorm"
+)
+
+// UpdateSupply 从数据库汇总各货币的流通总量并更新指标。
+// 由数据库汇总而不是在各副本内累加，保证多副本部署时数值一致。
+func UpdateSupply(ctx context.Context, db *gorm.DB) error {
+	var rows []struct {
+		CurrencyID uint
+		Total      uint64
+	}
+	err := db.WithContext(ctx).Model(&models.UserCurrency{}).
+		Select("currency_id, SUM(currency_num) AS total").
+		Group("currency_id").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		CirculatingSupply.WithLabelValues(CurrencyLabel(row.CurrencyID)).Set(float64(row.Total))
+	}
+	return nil
+}
@@ -1,16 +1,36 @@
 package notification
 
 import (
+	"errors"
 	"sync"
+
+	"github.com/kakaluote000/demo-api/pkg"
 )
 
+var ErrNoUserNotifier = errors.New("no user notification channel configured")
+
 type NotificationManager struct {
 	notifiers []Notifier
-	mu        sync.RWMutex
+	// 面向单个用户的渠道，与广播渠道分开，避免把用户消息发到告警群
+	userNotifiers []UserNotifier
+	mu            sync.RWMutex
 }
 
-func (m *NotificationManager) NotifyUser(user string, message string) {
-	panic("unimplemented")
+// NotifyUser 通过所有用户渠道发送消息，没有配置用户渠道时返回 ErrNoUserNotifier
+func (m *NotificationManager) NotifyUser(user string, message string) []error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.userNotifiers) == 0 {
+		return []error{ErrNoUserNotifier}
+	}
+	errors := make([]error, 0)
+	for _, notifier := range m.userNotifiers {
+		if err := notifier.SendToUser(user, message); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
 }
 
 func NewNotificationManager() *NotificationManager {
@@ -19,6 +39,36 @@ func NewNotificationManager() *NotificationManager {
 	}
 }
 
+// NewNotificationManagerFromConfig 根据配置创建通知渠道
+func NewNotificationManagerFromConfig(cfg pkg.NotificationConfig) *NotificationManager {
+	m := NewNotificationManager()
+	m.ApplyConfig(cfg)
+	return m
+}
+
+// ApplyConfig 使用配置中的通知渠道整体替换现有渠道，用于配置热更新
+func (m *NotificationManager) ApplyConfig(cfg pkg.NotificationConfig) {
+	notifiers := make([]Notifier, 0, len(cfg.Webhooks))
+	for _, url := range cfg.Webhooks {
+		notifiers = append(notifiers, NewWebhookNotifier(url))
+	}
+	userNotifiers := make([]UserNotifier, 0, len(cfg.UserWebhooks))
+	for _, url := range cfg.UserWebhooks {
+		userNotifiers = append(userNotifiers, NewWebhookNotifier(url))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifiers = notifiers
+	m.userNotifiers = userNotifiers
+}
+
+func (m *NotificationManager) AddUserNotifier(n UserNotifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.userNotifiers = append(m.userNotifiers, n)
+}
+
 func (m *NotificationManager) AddNotifier(n Notifier) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
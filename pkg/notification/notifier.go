@@ -5,12 +5,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 type Notifier interface {
 	Send(message string) error
 }
 
+// UserNotifier 将消息发送给指定用户，如密码重置链接
+type UserNotifier interface {
+	SendToUser(user, message string) error
+}
+
+// webhook 请求的超时时间，避免接收方无响应时发送方一直阻塞
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
 type WebhookNotifier struct {
 	WebhookURL string
 }
@@ -33,13 +42,21 @@ func NewWebhookNotifier(url string) *WebhookNotifier {
 }
 
 func (n *WebhookNotifier) Send(message string) error {
-	payload := map[string]string{"text": message}
+	return n.post(map[string]string{"text": message})
+}
+
+// SendToUser 由 webhook 接收方（如邮件、短信网关）根据 user 投递
+func (n *WebhookNotifier) SendToUser(user, message string) error {
+	return n.post(map[string]string{"user": user, "text": message})
+}
+
+func (n *WebhookNotifier) post(payload map[string]string) error {
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(n.WebhookURL, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := webhookClient.Post(n.WebhookURL, "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,183 @@
+// Package ratelimit 使用 GCRA (Generic Cell Rate Algorithm) 按客户端限流。
+// 效果等同令牌桶：桶容量为 Burst，每秒补充 Rate 个，但每个 key 只需保存一个时间戳，
+// 可以放在 Redis 中由所有实例共享
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Policy 限流策略，Rate 为每秒补充的请求数，Burst 为允许的突发请求数
+type Policy struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited 判断策略是否不限流
+func (p Policy) Unlimited() bool {
+	return p.Rate <= 0 || p.Burst <= 0
+}
+
+func (p Policy) interval() time.Duration {
+	return time.Duration(float64(time.Second) / p.Rate)
+}
+
+// Result 一次限流判断的结果
+type Result struct {
+	Allowed bool
+	// 桶容量，即 Burst
+	Limit     int
+	Remaining int
+	// 桶恢复满额所需时间
+	ResetAfter time.Duration
+	// 被拒绝时，到下一个请求可以通过所需等待的时间
+	RetryAfter time.Duration
+}
+
+// Limiter 判断 key 对应的客户端能否再发起一个请求
+type Limiter interface {
+	Allow(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// gcra 根据理论到达时间 (TAT) 计算结果，返回新的 TAT；被拒绝时 TAT 不变
+func gcra(now, tat time.Time, policy Policy) (Result, time.Time) {
+	interval := policy.interval()
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval)
+	diff := now.Sub(newTAT.Add(-time.Duration(policy.Burst) * interval))
+	if diff < 0 {
+		return Result{Limit: policy.Burst, ResetAfter: tat.Sub(now), RetryAfter: -diff}, tat
+	}
+	return Result{
+		Allowed:    true,
+		Limit:      policy.Burst,
+		Remaining:  int(diff / interval),
+		ResetAfter: newTAT.Sub(now),
+	}, newTAT
+}
+
+// LocalLimiter 在进程内限流，每个实例单独计数
+type LocalLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]time.Time
+	// 上次清理过期 key 的时间
+	swept time.Time
+}
+
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{buckets: make(map[string]time.Time)}
+}
+
+// 清理过期 key 的间隔
+const sweepInterval = time.Minute
+
+func (l *LocalLimiter) Allow(_ context.Context, key string, policy Policy) (Result, error) {
+	if policy.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	result, tat := gcra(now, l.buckets[key], policy)
+	l.buckets[key] = tat
+	if now.Sub(l.swept) > sweepInterval {
+		// TAT 早于当前时间说明桶已满，可以删除
+		for k, t := range l.buckets {
+			if t.Before(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = now
+	}
+	return result, nil
+}
+
+// gcraScript 使用 Redis 的时间计算，避免各实例时钟不一致。时间单位为微秒
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+local new_tat = tat + interval
+local diff = now - (new_tat - burst * interval)
+if diff < 0 then
+  return {0, 0, tat - now, -diff}
+end
+redis.call("SET", KEYS[1], new_tat, "PX", math.ceil((new_tat - now) / 1000))
+return {1, math.floor(diff / interval), new_tat - now, 0}
+`)
+
+// RedisLimiter 使用 Redis 限流，所有实例共享计数
+type RedisLimiter struct {
+	rdb    *redis.Client
+	prefix string
+}
+
+func NewRedisLimiter(rdb *redis.Client) *RedisLimiter {
+	return &RedisLimiter{rdb: rdb, prefix: "rate_limit:"}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	if policy.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	interval := math.Max(1, math.Round(float64(policy.interval().Microseconds())))
+	values, err := gcraScript.Run(ctx, l.rdb, []string{l.prefix + key}, policy.Burst, int64(interval)).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      policy.Burst,
+		Remaining:  int(values[1]),
+		ResetAfter: time.Duration(values[2]) * time.Microsecond,
+		RetryAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// FallbackLimiter 优先使用 primary，出错时改用 fallback，保证 Redis 不可用时仍然限流。
+// OnFallback 在进入和退出降级状态时各调用一次
+type FallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+	degraded atomic.Bool
+
+	OnFallback func(degraded bool, err error)
+}
+
+func NewFallbackLimiter(primary, fallback Limiter) *FallbackLimiter {
+	return &FallbackLimiter{primary: primary, fallback: fallback}
+}
+
+func (l *FallbackLimiter) Allow(ctx context.Context, key string, policy Policy) (Result, error) {
+	result, err := l.primary.Allow(ctx, key, policy)
+	if err == nil {
+		if l.degraded.CompareAndSwap(true, false) && l.OnFallback != nil {
+			l.OnFallback(false, nil)
+		}
+		return result, nil
+	}
+	if l.degraded.CompareAndSwap(false, true) && l.OnFallback != nil {
+		l.OnFallback(true, err)
+	}
+	return l.fallback.Allow(ctx, key, policy)
+}
+
+// Degraded 判断当前是否在使用 fallback
+func (l *FallbackLimiter) Degraded() bool {
+	return l.degraded.Load()
+}
@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/kakaluote000/demo-api/pkg/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func assertBurst(t *testing.T, limiter ratelimit.Limiter, key string) {
+	ctx := context.Background()
+	policy := ratelimit.Policy{Rate: 1, Burst: 3}
+	for i := 0; i < 3; i++ {
+		result, err := limiter.Allow(ctx, key, policy)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+	}
+
+	result, err := limiter.Allow(ctx, key, policy)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.InDelta(t, time.Second, result.RetryAfter, float64(100*time.Millisecond))
+	assert.InDelta(t, 3*time.Second, result.ResetAfter, float64(100*time.Millisecond))
+
+	// 其他客户端不受影响
+	result, err = limiter.Allow(ctx, key+"-other", policy)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func newRedis(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	return redis.NewClient(&redis.Options{Addr: mr.Addr()}), mr
+}
+
+func TestLocalLimiter(t *testing.T) {
+	assertBurst(t, ratelimit.NewLocalLimiter(), "user:1")
+}
+
+func TestRedisLimiter(t *testing.T) {
+	rdb, mr := newRedis(t)
+	assertBurst(t, ratelimit.NewRedisLimiter(rdb), "user:1")
+	assert.True(t, mr.Exists("rate_limit:user:1"))
+}
+
+func TestRedisLimiterSharedBetweenInstances(t *testing.T) {
+	rdb, _ := newRedis(t)
+	a, b := ratelimit.NewRedisLimiter(rdb), ratelimit.NewRedisLimiter(rdb)
+	policy := ratelimit.Policy{Rate: 1, Burst: 2}
+
+	for _, limiter := range []ratelimit.Limiter{a, b} {
+		result, err := limiter.Allow(context.Background(), "ip:10.0.0.1", policy)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	result, err := a.Allow(context.Background(), "ip:10.0.0.1", policy)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+}
+
+func TestUnlimitedPolicy(t *testing.T) {
+	rdb, mr := newRedis(t)
+	result, err := ratelimit.NewRedisLimiter(rdb).Allow(context.Background(), "user:1", ratelimit.Policy{})
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Empty(t, mr.Keys())
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	rdb, mr := newRedis(t)
+	limiter := ratelimit.NewFallbackLimiter(ratelimit.NewRedisLimiter(rdb), ratelimit.NewLocalLimiter())
+	var transitions []bool
+	limiter.OnFallback = func(degraded bool, _ error) { transitions = append(transitions, degraded) }
+	policy := ratelimit.Policy{Rate: 1, Burst: 1}
+
+	mr.Close()
+	result, err := limiter.Allow(context.Background(), "user:1", policy)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.True(t, limiter.Degraded())
+
+	// Redis 不可用时仍按本地计数限流
+	result, err = limiter.Allow(context.Background(), "user:1", policy)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	require.NoError(t, mr.Restart())
+	result, err = limiter.Allow(context.Background(), "user:1", policy)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.False(t, limiter.Degraded())
+	assert.Equal(t, []bool{true, false}, transitions)
+}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kakaluote000/demo-api/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// 同一次保存往往触发多个文件事件，合并后再重新加载
+const reloadDebounce = 200 * time.Millisecond
+
+var (
+	currentConfig atomic.Pointer[Config]
+
+	reloadMu    sync.Mutex
+	reloadHooks []func(old, next *Config)
+)
+
+// CurrentConfig 返回当前生效的配置，热更新后会被整体替换，调用方不应修改返回值
+func CurrentConfig() *Config {
+	if cfg := currentConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &AppConfig
+}
+
+// OnConfigReload 注册配置热更新回调，回调在新配置生效后按注册顺序执行
+func OnConfigReload(fn func(old, next *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// WatchConfig 监听配置文件变化和 SIGHUP 信号，触发配置热更新
+func WatchConfig(ctx context.Context) {
+	var (
+		timerMu sync.Mutex
+		timer   *time.Timer
+	)
+	trigger := func(source string) {
+		timerMu.Lock()
+		defer timerMu.Unlock()
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(reloadDebounce, func() {
+			if err := ReloadConfig(source); err != nil {
+				Log.WithError(err).WithField("source", source).Error("Config reload rejected")
+			}
+		})
+	}
+
+	for _, name := range configFiles {
+		v := viper.New()
+		v.SetConfigName(name)
+		v.SetConfigType("yaml")
+		v.AddConfigPath(configDir)
+		if err := v.ReadInConfig(); err != nil {
+			Log.WithError(err).Warnf("Unable to watch %s config file", name)
+			continue
+		}
+		v.OnConfigChange(func(e fsnotify.Event) {
+			trigger("file:" + e.Name)
+		})
+		v.WatchConfig()
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sighup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sighup:
+				trigger("signal:SIGHUP")
+			}
+		}
+	}()
+}
+
+// ReloadConfig 重新读取配置文件，校验通过后原子替换可热更新的配置。
+// 需要重启才能生效的配置变更只记录告警，不会被应用。
+func ReloadConfig(source string) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	loaded, err := loadConfig()
+	if err != nil {
+		metrics.ConfigReloads.WithLabelValues("failed").Inc()
+		return err
+	}
+
+	old := CurrentConfig()
+	next := mergeReloadable(old, loaded)
+
+	restartRequired := diffConfig(restartOnly(old), restartOnly(loaded))
+	changes := diffConfig(old, next)
+
+	fields := logrus.Fields{"source": source}
+	if len(restartRequired) > 0 {
+		Log.WithFields(fields).WithField("changes", restartRequired).Warn("Config changes require restart and were not applied")
+	}
+	if len(changes) == 0 {
+		metrics.ConfigReloads.WithLabelValues("unchanged").Inc()
+		Log.WithFields(fields).Info("Config reloaded without changes")
+		return nil
+	}
+
+	currentConfig.Store(next)
+	applyLogLevel(next.Log)
+	for _, hook := range reloadHooks {
+		hook(old, next)
+	}
+
+	metrics.ConfigReloads.WithLabelValues("success").Inc()
+	Log.WithFields(fields).WithField("changes", changes).Info("Config reloaded")
+	return nil
+}
+
+// mergeReloadable 以旧配置为基础，只替换允许运行时修改的部分
+func mergeReloadable(old, loaded *Config) *Config {
+	next := *old
+	next.Log.Level = loaded.Log.Level
+	next.Notification = loaded.Notification
+	next.Alert = loaded.Alert
+
+	secret := old.Security.JWT.Secret
+	next.Security = loaded.Security
+	next.Security.JWT.Secret = secret
+	return &next
+}
+
+// restartOnly 提取只能在重启后生效的配置
+func restartOnly(cfg *Config) *Config {
+	return &Config{
+		Server:   cfg.Server,
+		Database: cfg.Database,
+		Redis:    cfg.Redis,
+		Tracing:  cfg.Tracing,
+		Log: LogConfig{
+			Output:     cfg.Log.Output,
+			Filename:   cfg.Log.Filename,
+			MaxSize:    cfg.Log.MaxSize,
+			MaxAge:     cfg.Log.MaxAge,
+			MaxBackups: cfg.Log.MaxBackups,
+			Compress:   cfg.Log.Compress,
+		},
+		Security: SecurityConfig{JWT: JWTConfig{Secret: cfg.Security.JWT.Secret}},
+	}
+}
+
+// diffConfig 逐字段比较两份配置，返回形如 "security.rate_limit.burst: 150 -> 200" 的差异列表
+func diffConfig(old, next *Config) []string {
+	before := map[string]string{}
+	after := map[string]string{}
+	flattenConfig("", reflect.ValueOf(*old), before)
+	flattenConfig("", reflect.ValueOf(*next), after)
+
+	var changes []string
+	for key, value := range after {
+		if before[key] == value {
+			continue
+		}
+		// 不在日志中输出敏感信息
+		if isSensitiveKey(key) {
+			changes = append(changes, fmt.Sprintf("%s: ****** -> ******", key))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", key, before[key], value))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+func flattenConfig(prefix string, v reflect.Value, out map[string]string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.ToLower(field.Name)
+		if tag := field.Tag.Get("mapstructure"); tag != "" {
+			key = tag
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct {
+			flattenConfig(key, value, out)
+			continue
+		}
+		out[key] = fmt.Sprintf("%v", value.Interface())
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	return strings.HasSuffix(key, ".password") || strings.HasSuffix(key, ".secret")
+}
+
+func applyLogLevel(cfg LogConfig) {
+	if cfg.Level == "" {
+		return
+	}
+	if level, err := logrus.ParseLevel(cfg.Level); err == nil {
+		Log.SetLevel(level)
+	}
+}
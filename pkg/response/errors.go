@@ -0,0 +1,160 @@
+package response
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"gorm.io/gorm"
+)
+
+// Code 机器可读的错误码，发布后不应修改
+type Code string
+
+// Error 带错误码的错误，HTTP 状态码和各语言的消息由目录统一定义。
+// 目录中的错误是共享的，WithDetails 和 Wrap 返回副本
+type Error struct {
+	Code    Code
+	Status  int
+	Details interface{}
+
+	message Message
+	cause   error
+}
+
+func define(code Code, status int, en, zh string) *Error {
+	return &Error{Code: code, Status: status, message: Message{en: en, zh: zh}}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return string(e.Code) + ": " + e.cause.Error()
+	}
+	return string(e.Code) + ": " + e.message.en
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is 错误码相同即视为同一错误，errors.Is(err, response.ErrUserNotFound) 对副本同样成立
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Message 返回 lang 对应的消息
+func (e *Error) Message(lang Lang) string {
+	return e.message.Text(lang)
+}
+
+// WithDetails 返回附带详细信息的副本，详细信息会返回给客户端
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// Wrap 返回记录了原始错误的副本，原始错误只写入日志，不返回给客户端
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.cause = err
+	return &c
+}
+
+// 通用错误
+var (
+	ErrInvalidRequest     = define("INVALID_REQUEST", http.StatusBadRequest, "Invalid request", "请求参数无效")
+	ErrUnauthorized       = define("UNAUTHORIZED", http.StatusUnauthorized, "Authentication is required", "需要登录")
+	ErrPermissionDenied   = define("PERMISSION_DENIED", http.StatusForbidden, "Permission denied", "没有权限")
+	ErrNotFound           = define("NOT_FOUND", http.StatusNotFound, "Resource not found", "资源不存在")
+	ErrRateLimited        = define("RATE_LIMITED", http.StatusTooManyRequests, "Too many requests", "请求过于频繁")
+	ErrResourceLocked     = define("RESOURCE_LOCKED", http.StatusConflict, "Resource is locked", "资源正在被其他请求处理")
+	ErrDatabase           = define("DATABASE_ERROR", http.StatusInternalServerError, "Database error", "数据库错误")
+	ErrInternal           = define("INTERNAL_ERROR", http.StatusInternalServerError, "Internal server error", "服务器内部错误")
+	ErrServiceUnavailable = define("SERVICE_UNAVAILABLE", http.StatusServiceUnavailable, "Service is temporarily unavailable", "服务暂时不可用")
+)
+
+// 认证
+var (
+	ErrInvalidToken        = define("INVALID_TOKEN", http.StatusUnauthorized, "Invalid token", "无效的 token")
+	ErrTokenRevoked        = define("TOKEN_REVOKED", http.StatusUnauthorized, "Token has been revoked", "token 已失效")
+	ErrInvalidRefreshToken = define("INVALID_REFRESH_TOKEN", http.StatusUnauthorized, "Invalid refresh token", "无效的 refresh token")
+	ErrInvalidAPIKey       = define("INVALID_API_KEY", http.StatusUnauthorized, "Invalid API key", "无效的 API key")
+	ErrIPNotAllowed        = define("IP_NOT_ALLOWED", http.StatusForbidden, "Client IP is not allowed", "客户端 IP 不在允许范围内")
+	ErrClientCertRequired  = define("CLIENT_CERT_REQUIRED", http.StatusForbidden, "Client certificate required", "需要客户端证书")
+	ErrInvalidCredentials  = define("INVALID_CREDENTIALS", http.StatusUnauthorized, "Invalid username or password", "用户名或密码错误")
+	ErrLoginThrottled      = define("LOGIN_THROTTLED", http.StatusTooManyRequests, "Too many failed login attempts, please try again later", "登录失败次数过多，请稍后再试")
+	ErrAccountDisabled     = define("ACCOUNT_DISABLED", http.StatusForbidden, "Account is disabled", "账号已被停用")
+	ErrUserSessionRequired = define("USER_SESSION_REQUIRED", http.StatusBadRequest, "This operation requires a user session", "该操作需要用户登录，不能使用 API key")
+	ErrInvalidMFAToken     = define("INVALID_MFA_TOKEN", http.StatusUnauthorized, "Invalid or expired MFA token", "两步验证 token 无效或已过期")
+	ErrInvalidMFACode      = define("INVALID_MFA_CODE", http.StatusUnauthorized, "Invalid verification code", "验证码错误")
+	ErrInvalidTOTPCode     = define("INVALID_TOTP_CODE", http.StatusBadRequest, "Invalid verification code", "验证码错误")
+	ErrMFACodeRequired     = define("MFA_CODE_REQUIRED", http.StatusBadRequest, "Either code or recovery_code is required", "需要提供验证码或恢复码")
+	ErrMFARequired         = define("MFA_REQUIRED", http.StatusForbidden, "Two-factor authentication is required for your role", "当前角色必须启用两步验证")
+	ErrMFANotEnabled       = define("MFA_NOT_ENABLED", http.StatusBadRequest, "Two-factor authentication is not enabled", "未启用两步验证")
+	ErrMFAAlreadyEnabled   = define("MFA_ALREADY_ENABLED", http.StatusConflict, "Two-factor authentication is already enabled", "已启用两步验证")
+	ErrMFANotEnrolling     = define("MFA_ENROLLMENT_NOT_STARTED", http.StatusBadRequest, "Two-factor enrollment has not been started", "尚未开始绑定两步验证")
+	ErrWrongPassword       = define("WRONG_PASSWORD", http.StatusUnauthorized, "Current password is incorrect", "当前密码错误")
+	ErrWeakPassword        = define("WEAK_PASSWORD", http.StatusBadRequest, "Password does not meet security requirements", "密码不符合安全要求")
+	ErrInvalidResetToken   = define("INVALID_RESET_TOKEN", http.StatusBadRequest, "Invalid or expired reset token", "重置 token 无效或已过期")
+)
+
+// 用户
+var (
+	ErrUserNotFound        = define("USER_NOT_FOUND", http.StatusNotFound, "User not found", "用户不存在")
+	ErrUsernameTaken       = define("USERNAME_TAKEN", http.StatusBadRequest, "Username already exists", "用户名已存在")
+	ErrUnknownRole         = define("UNKNOWN_ROLE", http.StatusBadRequest, "Unknown role", "未知角色")
+	ErrCannotModifySelf    = define("CANNOT_MODIFY_SELF", http.StatusBadRequest, "Cannot disable, delete or change the role of your own account", "不能停用、删除或修改自己账号的角色")
+	ErrUserAlreadyDisabled = define("USER_ALREADY_DISABLED", http.StatusConflict, "User is already disabled", "用户已被停用")
+	ErrUserNotDisabled     = define("USER_NOT_DISABLED", http.StatusConflict, "User is not disabled", "用户未被停用")
+	ErrUserNotDeleted      = define("USER_NOT_DELETED", http.StatusConflict, "User is not deleted", "用户未被删除")
+)
+
+// 钱包
+var (
+	ErrWalletNotFound    = define("WALLET_NOT_FOUND", http.StatusNotFound, "User currency not found", "钱包不存在")
+	ErrInsufficientFunds = define("INSUFFICIENT_FUNDS", http.StatusBadRequest, "Insufficient currency", "余额不足")
+	ErrWalletExists      = define("WALLET_EXISTS", http.StatusConflict, "User already has a wallet in this currency", "该币种的钱包已存在")
+)
+
+// 其他资源
+var (
+	ErrAPIKeyNotFound = define("API_KEY_NOT_FOUND", http.StatusNotFound, "API key not found", "API key 不存在")
+	ErrAlertNotFound  = define("ALERT_NOT_FOUND", http.StatusNotFound, "Alert not found", "告警不存在")
+)
+
+// domainErrors 将各模块的错误映射为错误码，按顺序匹配
+var domainErrors = []struct {
+	err error
+	to  *Error
+}{
+	{auth.ErrAPIKeyInvalid, ErrInvalidAPIKey},
+	{auth.ErrAPIKeyExpired, ErrInvalidAPIKey},
+	{auth.ErrAPIKeyIPNotAllowed, ErrIPNotAllowed},
+	{auth.ErrCredentialsMissing, ErrUnauthorized},
+	{auth.ErrAccessTokenInvalid, ErrInvalidToken},
+	{auth.ErrAuthUnavailable, ErrServiceUnavailable},
+	{auth.ErrTokenRevoked, ErrTokenRevoked},
+	{auth.ErrRefreshTokenReused, ErrInvalidRefreshToken},
+	{auth.ErrNotRefreshToken, ErrInvalidRefreshToken},
+	{auth.ErrRefreshTokenInvalid, ErrInvalidRefreshToken},
+	{auth.ErrMFAChallengeInvalid, ErrInvalidMFAToken},
+	{auth.ErrResetTokenInvalid, ErrInvalidResetToken},
+	{gorm.ErrRecordNotFound, ErrNotFound},
+}
+
+// From 将任意错误转换为 *Error。已经是 *Error 的直接返回，
+// 目录中的模块错误返回对应的错误码，其他错误视为内部错误
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	for _, d := range domainErrors {
+		if errors.Is(err, d.err) {
+			return d.to.Wrap(err)
+		}
+	}
+	return ErrInternal.Wrap(err)
+}
@@ -0,0 +1,49 @@
+package response
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg"
+)
+
+// LangFromContext 根据请求的 Accept-Language 返回响应使用的语言
+func LangFromContext(c *gin.Context) Lang {
+	return ParseLang(c.GetHeader("Accept-Language"))
+}
+
+// OK 返回 200 和数据
+func OK(c *gin.Context, data interface{}) {
+	Success(c, http.StatusOK, MsgSuccess, data)
+}
+
+// Success 返回成功响应，data 为 nil 时不输出 data 字段
+func Success(c *gin.Context, status int, msg Message, data interface{}) {
+	c.JSON(status, Response{
+		Code:      CodeOK,
+		Message:   msg.Text(LangFromContext(c)),
+		Data:      data,
+		RequestID: c.GetString("requestID"),
+	})
+}
+
+// Fail 返回错误响应，err 通过 From 转换为错误码。5xx 错误的原始原因写入请求日志
+func Fail(c *gin.Context, err error) {
+	e := From(err)
+	if e.Status >= http.StatusInternalServerError {
+		entry := pkg.LoggerFromContext(c.Request.Context()).WithField("code", e.Code)
+		if e.cause != nil {
+			entry = entry.WithError(e.cause)
+		}
+		entry.Error("Request failed")
+	}
+	resp := NewError(e, LangFromContext(c))
+	resp.RequestID = c.GetString("requestID")
+	c.JSON(e.Status, resp)
+}
+
+// Abort 返回错误响应并中止后续处理，用于中间件
+func Abort(c *gin.Context, err error) {
+	Fail(c, err)
+	c.Abort()
+}
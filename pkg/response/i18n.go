@@ -0,0 +1,73 @@
+package response
+
+import (
+	"golang.org/x/text/language"
+)
+
+// Lang 响应消息的语言
+type Lang string
+
+const (
+	LangEN Lang = "en"
+	LangZH Lang = "zh"
+)
+
+// DefaultLang 没有 Accept-Language 或不支持时使用的语言
+const DefaultLang = LangEN
+
+// 第一个为默认语言
+var languageMatcher = language.NewMatcher([]language.Tag{language.English, language.Chinese})
+
+// ParseLang 根据 Accept-Language 选择语言，如 "zh-CN,zh;q=0.9,en;q=0.8" 返回 zh
+func ParseLang(acceptLanguage string) Lang {
+	if acceptLanguage == "" {
+		return DefaultLang
+	}
+	tags, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(tags) == 0 {
+		return DefaultLang
+	}
+	_, index, confidence := languageMatcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLang
+	}
+	if index == 1 {
+		return LangZH
+	}
+	return LangEN
+}
+
+// Message 成功响应的提示信息
+type Message struct {
+	en string
+	zh string
+}
+
+// Text 返回 lang 对应的文本，缺少翻译时使用英文
+func (m Message) Text(lang Lang) string {
+	if lang == LangZH && m.zh != "" {
+		return m.zh
+	}
+	return m.en
+}
+
+// 成功响应的提示信息
+var (
+	MsgSuccess            = Message{"success", "成功"}
+	MsgUserRegistered     = Message{"User registered successfully", "注册成功"}
+	MsgLoggedOut          = Message{"Logged out successfully", "已退出登录"}
+	MsgAllLoggedOut       = Message{"All sessions logged out successfully", "已退出所有会话"}
+	MsgWalletCreated      = Message{"User currency added successfully", "钱包创建成功"}
+	MsgWalletUpdated      = Message{"User currency updated successfully", "余额已更新"}
+	MsgCurrencyAdded      = Message{"User currency added successfully", "货币已发放"}
+	MsgCurrencySubtracted = Message{"User currency subtracted successfully", "货币已扣减"}
+	MsgUserDeleted        = Message{"User deleted successfully", "用户已删除"}
+	MsgUserUnlocked       = Message{"User unlocked successfully", "用户已解锁"}
+	MsgAPIKeyRevoked      = Message{"API key revoked successfully", "API key 已吊销"}
+	MsgAlertReceived      = Message{"Alert received and processed", "告警已接收"}
+	MsgAlertUpdated       = Message{"Alert status updated successfully", "告警状态已更新"}
+	MsgPasswordReset      = Message{"Password has been reset", "密码已重置"}
+	MsgPasswordResetSent  = Message{"If the account exists, a password reset link has been sent", "如果账号存在，重置链接已发送"}
+	MsgMFADisabled        = Message{"Two-factor authentication disabled", "两步验证已关闭"}
+	MsgHealthy            = Message{"Server is healthy", "服务正常"}
+)
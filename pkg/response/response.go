@@ -1,10 +1,18 @@
 package response
 
-// Response 基础响应结构
+// CodeOK 成功响应的 code
+const CodeOK Code = "OK"
+
+// Response 基础响应结构，所有接口都使用这一结构返回
 type Response struct {
-	Code    int         `json:"code"`
+	// 成功时为 OK，失败时为错误码，如 INSUFFICIENT_FUNDS
+	Code Code `json:"code" swaggertype:"string"`
+	// 按 Accept-Language 本地化的说明，仅供展示，客户端应根据 code 判断
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
+	// 错误的附加信息，如未通过的密码规则
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
 }
 
 // SuccessResponse 成功响应
@@ -21,19 +29,20 @@ type ErrorResponse struct {
 func NewSuccess(data interface{}) *SuccessResponse {
 	return &SuccessResponse{
 		Response: Response{
-			Code:    200,
+			Code:    CodeOK,
 			Message: "success",
 			Data:    data,
 		},
 	}
 }
 
-// NewError 创建错误响应
-func NewError(code int, message string) *ErrorResponse {
+// NewError 创建错误响应，message 使用 lang 对应的语言
+func NewError(err *Error, lang Lang) *ErrorResponse {
 	return &ErrorResponse{
 		Response: Response{
-			Code:    code,
-			Message: message,
+			Code:    err.Code,
+			Message: err.Message(lang),
+			Details: err.Details,
 		},
 	}
 }
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg/auth"
+	"github.com/kakaluote000/demo-api/pkg/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestParseLang(t *testing.T) {
+	tests := []struct {
+		header string
+		want   response.Lang
+	}{
+		{"", response.LangEN},
+		{"zh-CN,zh;q=0.9,en;q=0.8", response.LangZH},
+		{"zh-TW", response.LangZH},
+		{"en-US,zh;q=0.5", response.LangEN},
+		{"fr-FR", response.LangEN},
+		{"fr;q=0.9,zh;q=0.8", response.LangZH},
+		{"not a language;;;", response.LangEN},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, response.ParseLang(tt.header), tt.header)
+	}
+}
+
+func TestFromMapsDomainErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want *response.Error
+	}{
+		{response.ErrInsufficientFunds, response.ErrInsufficientFunds},
+		{fmt.Errorf("subtract: %w", response.ErrUserNotFound), response.ErrUserNotFound},
+		{auth.ErrTokenRevoked, response.ErrTokenRevoked},
+		{fmt.Errorf("lookup: %w", auth.ErrAPIKeyExpired), response.ErrInvalidAPIKey},
+		{gorm.ErrRecordNotFound, response.ErrNotFound},
+		{errors.New("boom"), response.ErrInternal},
+	}
+	for _, tt := range tests {
+		got := response.From(tt.err)
+		assert.Equal(t, tt.want.Code, got.Code, tt.err.Error())
+		assert.Equal(t, tt.want.Status, got.Status, tt.err.Error())
+	}
+
+	// 转换后仍能取到原始错误
+	assert.ErrorIs(t, response.From(auth.ErrTokenRevoked), auth.ErrTokenRevoked)
+}
+
+func TestErrorCopies(t *testing.T) {
+	detailed := response.ErrInvalidRequest.WithDetails("currency_num is required")
+	assert.Nil(t, response.ErrInvalidRequest.Details)
+	assert.Equal(t, "currency_num is required", detailed.Details)
+	assert.ErrorIs(t, detailed, response.ErrInvalidRequest)
+	assert.NotErrorIs(t, detailed, response.ErrNotFound)
+
+	cause := errors.New("connection reset")
+	wrapped := response.ErrDatabase.Wrap(cause)
+	assert.ErrorIs(t, wrapped, cause)
+	assert.ErrorIs(t, wrapped, response.ErrDatabase)
+	assert.NoError(t, errors.Unwrap(response.ErrDatabase))
+	assert.Equal(t, "DATABASE_ERROR: connection reset", wrapped.Error())
+}
+
+func TestGinResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("requestID", "req-1")
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		response.OK(c, gin.H{"currency_num": 10})
+	})
+	router.GET("/created", func(c *gin.Context) {
+		response.Success(c, http.StatusCreated, response.MsgWalletCreated, nil)
+	})
+	router.GET("/funds", func(c *gin.Context) {
+		response.Fail(c, response.ErrInsufficientFunds.WithDetails(gin.H{"balance": 5}))
+	})
+	router.GET("/internal", func(c *gin.Context) {
+		response.Fail(c, errors.New("dial tcp 10.0.0.1:3306: connection refused"))
+	})
+
+	serve := func(path, acceptLanguage string) (*httptest.ResponseRecorder, map[string]any) {
+		req := httptest.NewRequest("GET", path, nil)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+		return w, body
+	}
+
+	w, body := serve("/ok", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]any{
+		"code":       "OK",
+		"message":    "success",
+		"data":       map[string]any{"currency_num": float64(10)},
+		"request_id": "req-1",
+	}, body)
+
+	w, body = serve("/created", "zh-CN,zh;q=0.9")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "钱包创建成功", body["message"])
+	assert.NotContains(t, body, "data")
+
+	w, body = serve("/funds", "en")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "INSUFFICIENT_FUNDS", body["code"])
+	assert.Equal(t, "Insufficient currency", body["message"])
+	assert.Equal(t, map[string]any{"balance": float64(5)}, body["details"])
+
+	_, body = serve("/funds", "zh")
+	assert.Equal(t, "余额不足", body["message"])
+
+	// 内部错误的原因不返回给客户端
+	w, body = serve("/internal", "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "INTERNAL_ERROR", body["code"])
+	assert.NotContains(t, w.Body.String(), "3306")
+}
+
+func TestInvalidListsFieldErrors(t *testing.T) {
+	type request struct {
+		Name   string `json:"name" binding:"required,max=8"`
+		Amount uint   `json:"amount" binding:"gt=0"`
+	}
+	bind := func(body string) error {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		var req request
+		return c.ShouldBindJSON(&req)
+	}
+
+	e := response.Invalid(bind(`{"name":"too long name","amount":0}`))
+	assert.Equal(t, response.ErrValidationFailed.Code, e.Code)
+	assert.Equal(t, []response.FieldError{
+		{Field: "name", Rule: "max", Param: "8"},
+		{Field: "amount", Rule: "gt", Param: "0"},
+	}, e.Details)
+
+	e = response.Invalid(bind(`{"name":"ok","amount":"ten"}`))
+	assert.Equal(t, response.ErrValidationFailed.Code, e.Code)
+	assert.Equal(t, []response.FieldError{{Field: "amount", Rule: "type", Param: "uint"}}, e.Details)
+
+	e = response.Invalid(bind(`{"name":`))
+	assert.Equal(t, response.ErrInvalidRequest.Code, e.Code)
+}
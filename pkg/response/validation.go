@@ -0,0 +1,59 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// ErrValidationFailed 请求体字段校验失败，details 为 []FieldError
+var ErrValidationFailed = define("VALIDATION_FAILED", http.StatusBadRequest, "Request validation failed", "请求参数校验失败")
+
+// ErrResponseValidation 响应不符合 OpenAPI 文档，只在开启响应校验时出现，details 为不符合的原因
+var ErrResponseValidation = define("RESPONSE_VALIDATION_FAILED", http.StatusInternalServerError, "Response does not match the API specification", "响应与接口文档不一致")
+
+// FieldError 单个字段的校验错误，Rule 为未通过的校验规则，如 required、gt、max
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+func init() {
+	// 校验错误中使用 json 字段名，与客户端提交的字段一致
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+// Invalid 将请求绑定错误转换为 *Error。字段校验失败和字段类型错误逐个列出，
+// 其他错误（如 JSON 格式错误）返回 ErrInvalidRequest
+func Invalid(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{Field: fe.Field(), Rule: fe.Tag(), Param: fe.Param()})
+		}
+		return ErrValidationFailed.WithDetails(fields)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return ErrValidationFailed.WithDetails([]FieldError{{Field: typeErr.Field, Rule: "type", Param: typeErr.Type.String()}})
+	}
+	return ErrInvalidRequest.WithDetails(err.Error())
+}
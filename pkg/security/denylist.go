@@ -0,0 +1,63 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Denylist 常见或已泄露密码的集合。每个密码只保存 SHA-256 的前 8 字节并排序，
+// 百万条约占 8MB，按二分查找判断；截断带来的误判概率可忽略
+type Denylist struct {
+	hashes []uint64
+}
+
+// LoadDenylist 从文件加载禁用密码，每行一个，忽略空行和 # 开头的注释，比较时不区分大小写
+func LoadDenylist(path string) (*Denylist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := &Denylist{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		d.hashes = append(d.hashes, denylistHash(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(d.hashes, func(i, j int) bool { return d.hashes[i] < d.hashes[j] })
+	return d, nil
+}
+
+// Contains 判断密码是否在禁用列表中，nil 表示未配置
+func (d *Denylist) Contains(password string) bool {
+	if d == nil {
+		return false
+	}
+	h := denylistHash(password)
+	i := sort.Search(len(d.hashes), func(i int) bool { return d.hashes[i] >= h })
+	return i < len(d.hashes) && d.hashes[i] == h
+}
+
+// Len 返回禁用密码数量
+func (d *Denylist) Len() int {
+	if d == nil {
+		return 0
+	}
+	return len(d.hashes)
+}
+
+func denylistHash(password string) uint64 {
+	sum := sha256.Sum256([]byte(strings.ToLower(password)))
+	return binary.BigEndian.Uint64(sum[:8])
+}
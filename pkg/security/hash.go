@@ -0,0 +1,174 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+)
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// 校验哈希时接受的 argon2 内存上限 (KiB)，防止异常的哈希在每次登录时占用大量内存
+const maxArgon2Memory = 1 << 20
+
+// 未加载配置时使用的默认参数，参考 OWASP 推荐值
+var defaultHashConfig = pkg.PasswordHashConfig{
+	Algorithm: HashAlgorithmArgon2id,
+	Argon2: pkg.Argon2Config{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	BcryptCost: 12,
+}
+
+var hashConfig atomic.Pointer[pkg.PasswordHashConfig]
+
+// SetHashConfig 设置新密码使用的哈希算法和参数，未配置的字段使用默认值。
+// 已有哈希不受影响，用户下次登录时按新参数重新计算
+func SetHashConfig(cfg pkg.PasswordHashConfig) {
+	d := defaultHashConfig
+	if cfg.Algorithm != "" {
+		d.Algorithm = cfg.Algorithm
+	}
+	if cfg.Argon2.Memory > 0 {
+		d.Argon2.Memory = cfg.Argon2.Memory
+	}
+	if cfg.Argon2.Iterations > 0 {
+		d.Argon2.Iterations = cfg.Argon2.Iterations
+	}
+	if cfg.Argon2.Parallelism > 0 {
+		d.Argon2.Parallelism = cfg.Argon2.Parallelism
+	}
+	if cfg.Argon2.SaltLength > 0 {
+		d.Argon2.SaltLength = cfg.Argon2.SaltLength
+	}
+	if cfg.Argon2.KeyLength > 0 {
+		d.Argon2.KeyLength = cfg.Argon2.KeyLength
+	}
+	if cfg.BcryptCost > 0 {
+		d.BcryptCost = cfg.BcryptCost
+	}
+	hashConfig.Store(&d)
+}
+
+func currentHashConfig() pkg.PasswordHashConfig {
+	if cfg := hashConfig.Load(); cfg != nil {
+		return *cfg
+	}
+	return defaultHashConfig
+}
+
+// HashPassword 使用当前配置计算密码哈希。
+// argon2id 使用 PHC 格式：$argon2id$v=19$m=<KiB>,t=<次数>,p=<并行度>$<salt>$<hash>，
+// bcrypt 使用其标准格式 $2a$<cost>$...
+func HashPassword(password string) (string, error) {
+	cfg := currentHashConfig()
+	if cfg.Algorithm == HashAlgorithmBcrypt {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), cfg.BcryptCost)
+		return string(bytes), err
+	}
+
+	salt := make([]byte, cfg.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := argon2Params{
+		memory:      cfg.Argon2.Memory,
+		iterations:  cfg.Argon2.Iterations,
+		parallelism: cfg.Argon2.Parallelism,
+		salt:        salt,
+	}
+	key := argon2.IDKey([]byte(password), salt, p.iterations, p.memory, p.parallelism, cfg.Argon2.KeyLength)
+	return p.encode(key), nil
+}
+
+// CheckPasswordHash 校验密码，同时支持 argon2id 和 bcrypt 格式的哈希
+func CheckPasswordHash(password, hash string) bool {
+	if strings.HasPrefix(hash, "$"+HashAlgorithmArgon2id+"$") {
+		p, key, err := decodeArgon2(hash)
+		if err != nil {
+			return false
+		}
+		actual := argon2.IDKey([]byte(password), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(key)))
+		return subtle.ConstantTimeCompare(actual, key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// NeedsRehash 判断哈希的算法或参数是否与当前配置不一致，应在登录成功后重新计算
+func NeedsRehash(hash string) bool {
+	cfg := currentHashConfig()
+	if cfg.Algorithm == HashAlgorithmBcrypt {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost != cfg.BcryptCost
+	}
+
+	p, key, err := decodeArgon2(hash)
+	if err != nil {
+		return true
+	}
+	return p.memory != cfg.Argon2.Memory ||
+		p.iterations != cfg.Argon2.Iterations ||
+		p.parallelism != cfg.Argon2.Parallelism ||
+		uint32(len(p.salt)) != cfg.Argon2.SaltLength ||
+		uint32(len(key)) != cfg.Argon2.KeyLength
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+}
+
+func (p argon2Params) encode(key []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		HashAlgorithmArgon2id, argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2(hash string) (argon2Params, []byte, error) {
+	var p argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashAlgorithmArgon2id {
+		return p, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism); err != nil {
+		return p, nil, errInvalidHash
+	}
+	// argon2 要求内存至少为 8*p KiB，t=0 或 p=0 时 argon2.IDKey 会 panic
+	if p.iterations < 1 || p.parallelism < 1 || p.memory < 8*uint32(p.parallelism) || p.memory > maxArgon2Memory {
+		return p, nil, errInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, errInvalidHash
+	}
+	p.salt = salt
+	return p, key, nil
+}
@@ -1,31 +1,93 @@
 package security
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync/atomic"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/kakaluote000/demo-api/pkg"
+)
+
+// 密码策略规则名，随 PolicyViolation 返回给客户端
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleUppercase = "uppercase"
+	RuleLowercase = "lowercase"
+	RuleNumber    = "number"
+	RuleSpecial   = "special"
+	RuleUsername  = "username"
+	RuleDenylist  = "denylist"
 )
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+// 未加载配置时使用的默认密码策略
+var defaultPasswordPolicy = pkg.PasswordConfig{
+	MinLength:        8,
+	RequireSpecial:   true,
+	RequireNumber:    true,
+	RequireUppercase: true,
+	RequireLowercase: true,
+	RejectUsername:   true,
 }
 
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+type passwordPolicyState struct {
+	cfg      pkg.PasswordConfig
+	denylist *Denylist
 }
 
-func ValidatePassword(password string) bool {
-	var (
-		hasMinLen  = false
-		hasUpper   = false
-		hasLower   = false
-		hasNumber  = false
-		hasSpecial = false
-	)
-	if len(password) >= 8 {
-		hasMinLen = true
+var passwordPolicy atomic.Pointer[passwordPolicyState]
+
+// PolicyViolation 描述密码未通过的一条规则
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// SetPasswordPolicy 使用 security.yaml 中的 password 配置替换默认策略，未配置时保留默认值。
+// 禁用词表加载失败时返回错误并保留原有策略
+func SetPasswordPolicy(cfg pkg.PasswordConfig) error {
+	if cfg == (pkg.PasswordConfig{}) {
+		return nil
+	}
+	state := &passwordPolicyState{cfg: cfg}
+	if cfg.DenylistFile != "" {
+		denylist, err := LoadDenylist(cfg.DenylistFile)
+		if err != nil {
+			return err
+		}
+		state.denylist = denylist
+	}
+	passwordPolicy.Store(state)
+	return nil
+}
+
+func currentPasswordPolicy() *passwordPolicyState {
+	if policy := passwordPolicy.Load(); policy != nil {
+		return policy
+	}
+	return &passwordPolicyState{cfg: defaultPasswordPolicy}
+}
+
+// CheckPassword 按当前策略检查密码，返回所有未通过的规则，全部通过时返回空。
+// username 为空时跳过用户名检查
+func CheckPassword(password, username string) []PolicyViolation {
+	policy := currentPasswordPolicy()
+	cfg := policy.cfg
+	var violations []PolicyViolation
+	fail := func(rule, message string) {
+		violations = append(violations, PolicyViolation{Rule: rule, Message: message})
 	}
+
+	length := utf8.RuneCountInString(password)
+	if length < cfg.MinLength {
+		fail(RuleMinLength, "Password is too short")
+	}
+	if cfg.MaxLength > 0 && length > cfg.MaxLength {
+		fail(RuleMaxLength, "Password is too long")
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSpecial bool
 	for _, char := range password {
 		switch {
 		case unicode.IsUpper(char):
@@ -38,5 +100,30 @@ func ValidatePassword(password string) bool {
 			hasSpecial = true
 		}
 	}
-	return hasMinLen && hasUpper && hasLower && hasNumber && hasSpecial
-}
\ No newline at end of file
+	if cfg.RequireUppercase && !hasUpper {
+		fail(RuleUppercase, "Password must contain an uppercase letter")
+	}
+	if cfg.RequireLowercase && !hasLower {
+		fail(RuleLowercase, "Password must contain a lowercase letter")
+	}
+	if cfg.RequireNumber && !hasNumber {
+		fail(RuleNumber, "Password must contain a number")
+	}
+	if cfg.RequireSpecial && !hasSpecial {
+		fail(RuleSpecial, "Password must contain a special character")
+	}
+
+	if cfg.RejectUsername && username != "" &&
+		strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		fail(RuleUsername, "Password must not contain the username")
+	}
+	if policy.denylist.Contains(password) {
+		fail(RuleDenylist, "Password is too common or has appeared in a data breach")
+	}
+	return violations
+}
+
+// ValidatePassword 判断密码是否满足当前策略，不做用户名检查
+func ValidatePassword(password string) bool {
+	return len(CheckPassword(password, "")) == 0
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	security.SetHashConfig(pkg.PasswordHashConfig{})
+
+	hash, err := security.HashPassword("Str0ng!Pass")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$"), hash)
+	assert.True(t, security.CheckPasswordHash("Str0ng!Pass", hash))
+	assert.False(t, security.CheckPasswordHash("wrong", hash))
+	assert.False(t, security.NeedsRehash(hash))
+
+	// 相同密码每次使用不同的 salt
+	other, err := security.HashPassword("Str0ng!Pass")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other)
+
+	assert.False(t, security.CheckPasswordHash("Str0ng!Pass", "$argon2id$v=19$m=1,t=1,p=1$bad$bad"))
+}
+
+func TestCheckPasswordHashRejectsInvalidArgon2Params(t *testing.T) {
+	security.SetHashConfig(pkg.PasswordHashConfig{})
+	hash, err := security.HashPassword("Str0ng!Pass")
+	require.NoError(t, err)
+	params := "m=19456,t=2,p=1"
+	require.Contains(t, hash, params)
+
+	// 参数不合法时校验失败而不是 panic 或占用大量内存
+	for _, invalid := range []string{"m=19456,t=0,p=1", "m=19456,t=2,p=0", "m=0,t=2,p=1", "m=7,t=2,p=1", "m=4294967295,t=2,p=1"} {
+		assert.NotPanics(t, func() {
+			assert.False(t, security.CheckPasswordHash("Str0ng!Pass", strings.Replace(hash, params, invalid, 1)), invalid)
+		})
+		assert.True(t, security.NeedsRehash(strings.Replace(hash, params, invalid, 1)), invalid)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	defer security.SetHashConfig(pkg.PasswordHashConfig{})
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("Str0ng!Pass"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	// 旧的 bcrypt 哈希仍可校验，但需要升级
+	security.SetHashConfig(pkg.PasswordHashConfig{})
+	assert.True(t, security.CheckPasswordHash("Str0ng!Pass", string(legacy)))
+	assert.True(t, security.NeedsRehash(string(legacy)))
+
+	// 参数变化后旧的 argon2id 哈希需要升级
+	hash, err := security.HashPassword("Str0ng!Pass")
+	require.NoError(t, err)
+	security.SetHashConfig(pkg.PasswordHashConfig{Argon2: pkg.Argon2Config{Iterations: 3}})
+	assert.True(t, security.NeedsRehash(hash))
+	assert.True(t, security.CheckPasswordHash("Str0ng!Pass", hash))
+
+	// 配置为 bcrypt 时按 cost 判断
+	security.SetHashConfig(pkg.PasswordHashConfig{Algorithm: security.HashAlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+	assert.False(t, security.NeedsRehash(string(legacy)))
+	assert.True(t, security.NeedsRehash(hash))
+	bcryptHash, err := security.HashPassword("Str0ng!Pass")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(bcryptHash, "$2a$04$"), bcryptHash)
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// 与 security 包的默认策略一致，测试结束后恢复
+var defaultPolicy = pkg.PasswordConfig{MinLength: 8, RequireSpecial: true, RequireNumber: true, RequireUppercase: true, RequireLowercase: true, RejectUsername: true}
+
+func restorePolicy(t *testing.T) {
+	t.Cleanup(func() { security.SetPasswordPolicy(defaultPolicy) })
+}
+
+func rules(violations []security.PolicyViolation) []string {
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func writeDenylist(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "denylist.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestCheckPassword(t *testing.T) {
+	restorePolicy(t)
+	require.NoError(t, security.SetPasswordPolicy(pkg.PasswordConfig{
+		MinLength:        8,
+		MaxLength:        20,
+		RequireSpecial:   true,
+		RequireNumber:    true,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RejectUsername:   true,
+		DenylistFile:     writeDenylist(t, "# comment\n\nP@ssw0rd\nSummer2024!\n"),
+	}))
+
+	for name, tc := range map[string]struct {
+		password string
+		want     []string
+	}{
+		"valid":                 {"Str0ng!Pass", nil},
+		"every rule fails":      {"", []string{security.RuleMinLength, security.RuleUppercase, security.RuleLowercase, security.RuleNumber, security.RuleSpecial}},
+		"too long":              {"Str0ng!Pass-Str0ng!Pass", []string{security.RuleMaxLength}},
+		"contains username":     {"xxALICE1!", []string{security.RuleUsername}},
+		"denylisted":            {"P@ssw0rd", []string{security.RuleDenylist}},
+		"denylist ignores case": {"summer2024!", []string{security.RuleUppercase, security.RuleDenylist}},
+		"length counts runes":   {"密码Aa1!", []string{security.RuleMinLength}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.want, rules(security.CheckPassword(tc.password, "alice")))
+		})
+	}
+
+	// 不传用户名时跳过用户名检查
+	assert.True(t, security.ValidatePassword("xxALICE1!"))
+}
+
+func TestSetPasswordPolicyKeepsPolicyOnError(t *testing.T) {
+	restorePolicy(t)
+	require.NoError(t, security.SetPasswordPolicy(pkg.PasswordConfig{MinLength: 4, DenylistFile: writeDenylist(t, "abcd\n")}))
+	assert.Error(t, security.SetPasswordPolicy(pkg.PasswordConfig{MinLength: 4, DenylistFile: "/nonexistent"}))
+	assert.Equal(t, []string{security.RuleDenylist}, rules(security.CheckPassword("ABCD", "")))
+}
+
+func TestDenylist(t *testing.T) {
+	d, err := security.LoadDenylist(writeDenylist(t, "123456\npassword\n  qwerty  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, 3, d.Len())
+	assert.True(t, d.Contains("PASSWORD"))
+	assert.True(t, d.Contains("qwerty"))
+	assert.False(t, d.Contains("Str0ng!Pass"))
+
+	var empty *security.Denylist
+	assert.False(t, empty.Contains("123456"))
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kakaluote000/demo-api/pkg/security"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// RFC 6238 附录 B 的 SHA1 测试向量，取后 6 位
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := security.TOTPCode(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := security.GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	code, err := security.TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	counter, ok := security.ValidateTOTP(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix()/30, counter)
+
+	// 允许前后一个时间步的偏差
+	_, ok = security.ValidateTOTP(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok)
+	_, ok = security.ValidateTOTP(secret, code, now.Add(90*time.Second))
+	assert.False(t, ok)
+
+	_, ok = security.ValidateTOTP(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = security.ValidateTOTP("not base32!", code, now)
+	assert.False(t, ok)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := security.TOTPProvisioningURI("demo-api", "alice", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/demo-api:alice?"), uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=demo-api")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := security.GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[A-Z2-7]{5}-[A-Z2-7]{5}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+
+	// 忽略大小写和分隔符
+	assert.Equal(t, security.HashRecoveryCode(codes[0]), security.HashRecoveryCode(strings.ToLower(strings.ReplaceAll(codes[0], "-", ""))))
+	assert.NotEqual(t, security.HashRecoveryCode(codes[0]), security.HashRecoveryCode(codes[1]))
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfigRejectsPlaceholderSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		secret  string
+		keys    []pkg.JWTKeyConfig
+		wantErr bool
+	}{
+		{name: "Debug mode with placeholder", mode: "debug", secret: "your-secret-key-here", wantErr: false},
+		{name: "Release mode with placeholder", mode: "release", secret: "your-secret-key-here", wantErr: true},
+		{name: "Release mode with empty secret", mode: "release", secret: "", wantErr: true},
+		{name: "Release mode with real secret", mode: "release", secret: "c0mpl3x-s3cr3t-v4lu3", wantErr: false},
+		{name: "Release mode with keys and no secret", mode: "release", secret: "", keys: []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: "k1.pem"}}, wantErr: false},
+		{name: "Release mode with keys and placeholder", mode: "release", secret: "your-secret-key-here", keys: []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: "k1.pem"}}, wantErr: true},
+		{name: "Key without kid", mode: "debug", secret: "x", keys: []pkg.JWTKeyConfig{{PrivateKeyFile: "k1.pem"}}, wantErr: true},
+		{name: "Duplicate kid", mode: "debug", secret: "x", keys: []pkg.JWTKeyConfig{{KID: "k1", PrivateKeyFile: "a.pem"}, {KID: "k1", PublicKeyFile: "b.pem"}}, wantErr: true},
+		{name: "Key without files", mode: "debug", secret: "x", keys: []pkg.JWTKeyConfig{{KID: "k1"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := pkg.Config{
+				Server:   pkg.ServerConfig{Mode: tt.mode},
+				Security: pkg.SecurityConfig{JWT: pkg.JWTConfig{Secret: tt.secret, Keys: tt.keys}},
+			}
+			err := pkg.ValidateConfig(&cfg)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestValidateConfigTrustedProxies(t *testing.T) {
+	cfg := pkg.Config{Server: pkg.ServerConfig{TrustedProxies: []string{"10.0.0.1", "172.16.0.0/12", "::1"}}}
+	assert.NoError(t, pkg.ValidateConfig(&cfg))
+	cfg.Server.TrustedProxies = []string{"proxy.internal"}
+	assert.Error(t, pkg.ValidateConfig(&cfg))
+}
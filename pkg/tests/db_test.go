@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/kakaluote000/demo-api/internal/models"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// legacyWallet 是没有唯一索引时的钱包表
+type legacyWallet struct {
+	gorm.Model
+	UserID      uint `gorm:"column:user_id;not null"`
+	CurrencyID  uint `gorm:"column:currency_id;not null"`
+	CurrencyNum uint `gorm:"column:currency_num;not null"`
+}
+
+func (legacyWallet) TableName() string { return "user_currencies" }
+
+func TestAutoMigrateMergesDuplicateWallets(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	require.NoError(t, db.AutoMigrate(&legacyWallet{}))
+	deleted := gorm.DeletedAt{Time: time.Now(), Valid: true}
+	require.NoError(t, db.Create([]*legacyWallet{
+		{UserID: 1, CurrencyID: 1, CurrencyNum: 30},
+		{UserID: 1, CurrencyID: 1, CurrencyNum: 20},
+		{UserID: 1, CurrencyID: 1, CurrencyNum: 99, Model: gorm.Model{DeletedAt: deleted}},
+		{UserID: 1, CurrencyID: 2, CurrencyNum: 5},
+	}).Error)
+
+	// 重复的钱包合并后才能创建唯一索引，已删除的记录不计入余额
+	require.NoError(t, pkg.AutoMigrate(db))
+	assert.True(t, db.Migrator().HasIndex(&models.UserCurrency{}, "idx_user_currency"))
+
+	var wallets []models.UserCurrency
+	require.NoError(t, db.Unscoped().Order("currency_id").Find(&wallets).Error)
+	require.Len(t, wallets, 2)
+	assert.Equal(t, uint(1), wallets[0].ID)
+	assert.Equal(t, uint(50), wallets[0].CurrencyNum)
+	assert.Equal(t, uint(5), wallets[1].CurrencyNum)
+
+	// 已经迁移过的数据库再次迁移不做任何修改
+	require.NoError(t, pkg.AutoMigrate(db))
+	assert.Error(t, db.Create(&models.UserCurrency{UserID: 1, CurrencyID: 1}).Error)
+}
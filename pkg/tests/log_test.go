@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureLogger(t *testing.T) {
+	defer pkg.Log.SetOutput(os.Stdout)
+	defer pkg.Log.SetLevel(logrus.InfoLevel)
+
+	filename := filepath.Join(t.TempDir(), "app.log")
+	require.NoError(t, pkg.ConfigureLogger(pkg.LogConfig{
+		Level:    "warn",
+		Output:   pkg.LogOutputFile,
+		Filename: filename,
+		MaxSize:  1,
+	}))
+
+	pkg.Log.Info("filtered out")
+	pkg.Log.WithField("user_id", 7).Warn("kept")
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "kept", entry["msg"])
+	assert.Equal(t, float64(7), entry["user_id"])
+}
+
+func TestConfigureLoggerRejectsUnknownOutput(t *testing.T) {
+	assert.Error(t, pkg.ConfigureLogger(pkg.LogConfig{Output: "syslog"}))
+}
+
+func TestLoggerFromContext(t *testing.T) {
+	fallback := pkg.LoggerFromContext(context.Background())
+	assert.Same(t, pkg.Log, fallback.Logger)
+
+	entry := logrus.NewEntry(pkg.Log).WithField("request_id", "abc")
+	ctx := pkg.ContextWithLogger(context.Background(), entry)
+	assert.Equal(t, "abc", pkg.LoggerFromContext(ctx).Data["request_id"])
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAppConfig = `server:
+  port: 8080
+  mode: debug
+database:
+  host: 127.0.0.1
+  port: 3306
+log:
+  level: info
+`
+
+const testSecurityConfig = `security:
+  jwt:
+    secret: test-secret
+    expiry: 1h
+  rate_limit:
+    requests_per_second: 100
+    burst: 150
+`
+
+func writeConfig(t *testing.T, dir, name, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config", name), []byte(content), 0o600))
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "config"), 0o755))
+	writeConfig(t, dir, "config.yaml", testAppConfig)
+	writeConfig(t, dir, "security.yaml", testSecurityConfig)
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	pkg.InitConfig()
+
+	var reloaded *pkg.Config
+	pkg.OnConfigReload(func(_, next *pkg.Config) {
+		reloaded = next
+	})
+
+	t.Run("Reloadable settings are applied", func(t *testing.T) {
+		writeConfig(t, dir, "security.yaml", `security:
+  jwt:
+    secret: test-secret
+    expiry: 2h
+  rate_limit:
+    requests_per_second: 10
+    burst: 20
+`)
+		require.NoError(t, pkg.ReloadConfig("test"))
+		require.NotNil(t, reloaded)
+		assert.Equal(t, 10.0, pkg.CurrentConfig().Security.RateLimit.RequestsPerSecond)
+		assert.Equal(t, 20, reloaded.Security.RateLimit.Burst)
+	})
+
+	t.Run("JWT keys are reloadable", func(t *testing.T) {
+		writeConfig(t, dir, "security.yaml", `security:
+  jwt:
+    secret: test-secret
+    expiry: 2h
+    keys:
+      - kid: "2024-01"
+        private_key_file: ./certs/old.pem
+        expires_at: "2024-07-08T00:00:00Z"
+      - kid: "2024-07"
+        private_key_file: ./certs/new.pem
+        active_from: "2024-07-01T00:00:00Z"
+  rate_limit:
+    requests_per_second: 10
+    burst: 20
+`)
+		require.NoError(t, pkg.ReloadConfig("test"))
+		keys := pkg.CurrentConfig().Security.JWT.Keys
+		require.Len(t, keys, 2)
+		assert.Equal(t, "2024-07", keys[1].KID)
+		assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), keys[1].ActiveFrom.UTC())
+		assert.Equal(t, time.Date(2024, 7, 8, 0, 0, 0, 0, time.UTC), keys[0].ExpiresAt.UTC())
+		assert.True(t, keys[0].ActiveFrom.IsZero())
+	})
+
+	t.Run("Structural settings require restart", func(t *testing.T) {
+		writeConfig(t, dir, "config.yaml", `server:
+  port: 9090
+  mode: debug
+database:
+  host: 10.0.0.1
+  port: 3306
+log:
+  level: debug
+`)
+		require.NoError(t, pkg.ReloadConfig("test"))
+		assert.Equal(t, "debug", pkg.CurrentConfig().Log.Level)
+		assert.Equal(t, 8080, pkg.CurrentConfig().Server.Port)
+		assert.Equal(t, "127.0.0.1", pkg.CurrentConfig().Database.Host)
+	})
+
+	t.Run("Invalid config is rejected", func(t *testing.T) {
+		writeConfig(t, dir, "config.yaml", `server:
+  port: 8080
+  mode: debug
+log:
+  level: verbose
+`)
+		assert.Error(t, pkg.ReloadConfig("test"))
+		assert.Equal(t, "debug", pkg.CurrentConfig().Log.Level)
+	})
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kakaluote000/demo-api/pkg/tlsutil"
+	"github.com/kakaluote000/demo-api/pkg/tlsutil/tlstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertReloaderPicksUpNewCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t, dir, "ca")
+	certFile, keyFile := ca.IssueServerCert(t, dir, "server")
+
+	reloader, err := tlsutil.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	first, _ := reloader.GetCertificate(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx)
+	time.Sleep(50 * time.Millisecond)
+
+	// 在其他目录签发新证书后覆盖原文件，模拟证书轮换
+	renewed := t.TempDir()
+	newCert, newKey := ca.IssueServerCert(t, renewed, "server")
+	copyFile(t, newKey, keyFile)
+	copyFile(t, newCert, certFile)
+
+	assert.Eventually(t, func() bool {
+		current, _ := reloader.GetCertificate(nil)
+		return current != first
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestCertReloaderKeepsCertificateOnError(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlstest.NewCA(t, dir, "ca")
+	certFile, keyFile := ca.IssueServerCert(t, dir, "server")
+
+	reloader, err := tlsutil.NewCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	first, _ := reloader.GetCertificate(nil)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "server.crt"), []byte("garbage"), 0o600))
+	assert.Error(t, reloader.Reload())
+
+	current, _ := reloader.GetCertificate(nil)
+	assert.Same(t, first, current)
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dst, data, 0o600))
+}
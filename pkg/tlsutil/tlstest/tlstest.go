@@ -0,0 +1,133 @@
+// Package tlstest 生成自签名 CA 及其签发的证书，用于测试 TLS 和 mTLS
+package tlstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type CA struct {
+	Cert     *x509.Certificate
+	Key      *ecdsa.PrivateKey
+	CertFile string
+}
+
+// NewCA 在 dir 下生成自签名 CA，证书写入 <name>.crt
+func NewCA(t testing.TB, dir, name string) *CA {
+	t.Helper()
+	key := newKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          serial(t),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	return &CA{Cert: cert, Key: key, CertFile: certFile}
+}
+
+// IssueServerCert 签发 localhost/127.0.0.1 的服务端证书，返回证书和私钥文件路径
+func (ca *CA) IssueServerCert(t testing.TB, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	template := ca.leafTemplate(t, name)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	template.DNSNames = []string{"localhost"}
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	return ca.issue(t, dir, name, template)
+}
+
+// IssueClientCert 签发客户端证书
+func (ca *CA) IssueClientCert(t testing.TB, dir, commonName string) tls.Certificate {
+	t.Helper()
+	template := ca.leafTemplate(t, commonName)
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	certFile, keyFile := ca.issue(t, dir, commonName, template)
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("load client certificate: %v", err)
+	}
+	return cert
+}
+
+// Pool 返回只包含该 CA 的证书池
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+func (ca *CA) leafTemplate(t testing.TB, commonName string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: serial(t),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"demo-api"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+}
+
+func (ca *CA) issue(t testing.TB, dir, name string, template *x509.Certificate) (certFile, keyFile string) {
+	key := newKey(t)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func newKey(t testing.TB) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func serial(t testing.TB) *big.Int {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatalf("generate serial: %v", err)
+	}
+	return n
+}
+
+func writePEM(t testing.TB, file, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatalf("write %s: %v", file, err)
+	}
+}
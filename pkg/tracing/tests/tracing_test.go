@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kakaluote000/demo-api/pkg"
+	"github.com/kakaluote000/demo-api/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+	}
+	Parent struct {
+		SpanID string
+	}
+}
+
+func TestFileExporterPropagatesTraceparent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	file := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := tracing.Init(pkg.TracingConfig{
+		Enabled:     true,
+		ServiceName: "test",
+		Exporter:    tracing.ExporterFile,
+		FilePath:    file,
+	})
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(otelgin.Middleware("test"))
+	r.GET("/userCurrency/:id", func(c *gin.Context) {
+		_, span := tracing.StartSpan(c.Request.Context(), "redis.get")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/userCurrency/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, shutdown(context.Background()))
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	spans := map[string]exportedSpan{}
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for decoder.More() {
+		var span exportedSpan
+		require.NoError(t, decoder.Decode(&span))
+		spans[span.Name] = span
+	}
+
+	require.Contains(t, spans, "/userCurrency/:id")
+	require.Contains(t, spans, "redis.get")
+	assert.Equal(t, traceID, spans["/userCurrency/:id"].SpanContext.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", spans["/userCurrency/:id"].Parent.SpanID)
+	assert.Equal(t, traceID, spans["redis.get"].SpanContext.TraceID)
+}
@@ -4,12 +4,10 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/kakaluote000/demo-api/cmd/app"
 	"github.com/kakaluote000/demo-api/internal/handlers"
-	"github.com/kakaluote000/demo-api/internal/middleware"
 )
 
 func BenchmarkLoginHandler(b *testing.B) {
@@ -96,23 +94,3 @@ func BenchmarkWithRedisCache(b *testing.B) {
 		}
 	})
 }
-
-func BenchmarkDistributedLock(b *testing.B) {
-	gin.SetMode(gin.TestMode)
-	app := app.NewApp()
-	router := gin.New()
-	router.POST("/updateUserCurrency",
-		middleware.DistributedLockMiddleware(app, "test_lock", 1*time.Second),
-		handlers.UpdateUserCurrencyHandler(app))
-
-	b.SetParallelism(50)
-	b.RunParallel(func(pb *testing.PB) {
-		for pb.Next() {
-			w := httptest.NewRecorder()
-			req := httptest.NewRequest("POST", "/updateUserCurrency",
-				strings.NewReader(`{"user_id":1,"currency_id":1,"currency_num":100}`))
-			req.Header.Set("Content-Type", "application/json")
-			router.ServeHTTP(w, req)
-		}
-	})
-}